@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//-----------------------------------------------------------------------------
+// Admin routes
+// support staff holding ADMIN_ROLE (from the authy response or JWT roles
+// claim) can work on any user's lists. Every admin request is recorded in
+// the admin_actions collection with the acting admin's public_id
+
+const adminActionsCollection = "admin_actions"
+
+// maxItemHolders caps how many users an item search returns per list
+const maxItemHolders = 1000
+
+// AdminMiddleware lets through requests from users holding the admin role.
+// It runs after AuthMiddleware; services never count as admins
+func (a *App) AdminMiddleware() gin.HandlerFunc {
+	role := a.config().AdminRole
+	return func(c *gin.Context) {
+		if _, isService := c.Get("service"); isService {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Admin role required"})
+			return
+		}
+
+		publicID, _ := c.Get("public_id")
+		roles, _ := c.Get("roles")
+		identity := &Identity{PublicID: publicID.(string)}
+		identity.Roles, _ = roles.([]string)
+		if !identity.HasRole(role) {
+			a.requestLog(c).Warn().Str("public_id", identity.PublicID).Msg("Admin route refused")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Admin role required"})
+			return
+		}
+
+		c.Set("admin_id", identity.PublicID)
+		c.Next()
+	}
+}
+
+// AdminTargetMiddleware checks the :public_id and :list_type of an admin
+// route and makes the target user the request's public_id, so the normal
+// list handlers act on their lists
+func (a *App) AdminTargetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target := c.Param("public_id")
+		if !IsValidUUID(target) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid public_id format"})
+			return
+		}
+		if !IsListRouteType(c.Param("list_type")) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid list type"})
+			return
+		}
+
+		c.Set("public_id", target)
+		c.Next()
+	}
+}
+
+// AdminAudit records the admin request once the handler has run
+func (a *App) AdminAudit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		adminID, _ := c.Get("admin_id")
+		record := AdminAction{
+			ID:        uuid.New().String(),
+			AdminID:   adminID.(string),
+			Action:    action,
+			PublicID:  c.Param("public_id"),
+			ListType:  c.Param("list_type"),
+			ItemID:    c.Param("itemId"),
+			Status:    c.Writer.Status(),
+			RequestID: c.GetString("request_id"),
+			At:        time.Now(),
+		}
+		if record.ItemID == "" {
+			record.ItemID = c.Param("item_id")
+		}
+		a.recordAdminAction(c.Request.Context(), record)
+	}
+}
+
+func (a *App) recordAdminAction(ctx context.Context, record AdminAction) {
+	log := loggerFromContext(ctx, a.Log)
+	log.Info().
+		Str("admin_id", record.AdminID).
+		Str("action", record.Action).
+		Str("public_id", record.PublicID).
+		Str("list_type", record.ListType).
+		Str("item_id", record.ItemID).
+		Int("status", record.Status).
+		Msg("Admin action")
+
+	if a.DB == nil {
+		return
+	}
+
+	// the action is done, so record it even if the client has gone
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if _, err := a.GetCollection(adminActionsCollection).InsertOne(ctx, record); err != nil {
+		log.Error().Err(err).Msg("Error recording admin action")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Admin handlers
+
+// FindItemHolders lists the users who have an item, across every list type
+// or just the one named in ?list_type
+func (a *App) FindItemHolders(c *gin.Context) {
+	parsedID, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid item ID format"})
+		return
+	}
+	itemID := parsedID.String()
+
+	listTypes := GetListRouteTypes()
+	if listType := c.Query("list_type"); listType != "" {
+		if !IsListRouteType(listType) {
+			c.JSON(http.StatusBadRequest, NewValidationError("list_type", "is not a list type"))
+			return
+		}
+		listTypes = []string{listType}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	response := ItemHoldersResponse{ItemID: itemID, Lists: map[string][]string{}}
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(maxItemHolders)
+	for _, listType := range listTypes {
+		cursor, err := a.GetCollection(listType).Find(ctx, bson.M{"item_ids": itemID}, opts)
+		if err != nil {
+			a.requestLog(c).Error().Err(err).Str("list_type", listType).Msg("Error searching for item")
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		var holders []struct {
+			ID string `bson:"_id"`
+		}
+		if err = cursor.All(ctx, &holders); err != nil {
+			a.requestLog(c).Error().Err(err).Str("list_type", listType).Msg("Error reading item search results")
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		publicIDs := make([]string, len(holders))
+		for i, h := range holders {
+			publicIDs[i] = h.ID
+		}
+		response.Lists[listType] = publicIDs
+	}
+
+	c.JSON(http.StatusOK, response)
+}
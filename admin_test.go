@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	adminTestAdminID = "aaaaaaaa-bbbb-4ccc-8ddd-eeeeeeeeeeee"
+	adminTestUserID  = "123e4567-e89b-12d3-a456-426614174000"
+)
+
+// roleVerifier maps tokens straight to identities
+type roleVerifier map[string]*Identity
+
+func (v roleVerifier) Verify(_ context.Context, token string) (*Identity, error) {
+	if identity, ok := v[token]; ok {
+		return identity, nil
+	}
+	return nil, errInvalidToken(nil)
+}
+
+func TestRoles(t *testing.T) {
+	t.Run("should parse arrays and delimited strings", func(t *testing.T) {
+		assert.Equal(t, []string{"admin", "support"}, parseRoles([]interface{}{"admin", "support", 3}))
+		assert.Equal(t, []string{"admin", "support"}, parseRoles("admin support"))
+		assert.Equal(t, []string{"admin", "support"}, parseRoles("admin,support"))
+		assert.Empty(t, parseRoles(nil))
+	})
+
+	t.Run("should read roles from the authy response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"public_id": "` + adminTestAdminID + `", "roles": ["admin"]}`))
+		}))
+		defer server.Close()
+
+		logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+		identity, err := NewAuthyVerifier(server.URL, newTestAuthyClient(server), &logger).Verify(context.Background(), "token")
+		require.NoError(t, err)
+		assert.True(t, identity.HasRole("admin"))
+	})
+
+	t.Run("should read roles from a JWT claim", func(t *testing.T) {
+		logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+		verifier := &JWTVerifier{Secret: []byte(jwtTestSecret), PublicIDClaim: "public_id", RolesClaim: "roles", log: &logger}
+
+		claims := validClaims()
+		claims["roles"] = []string{"admin"}
+		identity, err := verifier.Verify(context.Background(), signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+		require.NoError(t, err)
+		assert.True(t, identity.HasRole("admin"))
+		assert.False(t, identity.HasRole("support"))
+	})
+}
+
+func TestAdminRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).With().Timestamp().Logger()
+
+	keys, err := ParseServiceKeys([]byte(serviceTestKeysJSON()))
+	require.NoError(t, err)
+
+	app := &App{
+		Log:    &logger,
+		Config: testConfig(t),
+		Auth: roleVerifier{
+			"admin-token": {PublicID: adminTestAdminID, Roles: []string{"admin"}},
+			"user-token":  {PublicID: adminTestUserID},
+		},
+		ServiceKeys: keys,
+	}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	send := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+		return resp
+	}
+	admin := map[string]string{"X-Access-Token": "admin-token"}
+	listPath := "/list/admin/users/" + adminTestUserID + "/watchlist"
+
+	t.Run("should require authentication", func(t *testing.T) {
+		resp := send("GET", listPath, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("should refuse users without the admin role", func(t *testing.T) {
+		resp := send("GET", listPath, map[string]string{"X-Access-Token": "user-token"})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("should refuse services", func(t *testing.T) {
+		resp := send("GET", listPath, map[string]string{"X-Service-Key": serviceTestKey, "X-Public-ID": adminTestUserID})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("should validate the target user and list", func(t *testing.T) {
+		resp := send("GET", "/list/admin/users/not-a-uuid/watchlist", admin)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		resp = send("GET", "/list/admin/users/"+adminTestUserID+"/shopping", admin)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		resp = send("GET", "/list/admin/items/not-a-uuid", admin)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("should record admin actions with the acting admin", func(t *testing.T) {
+		logs.Reset()
+		send("DELETE", "/list/admin/users/not-a-uuid/watchlist", admin)
+
+		assert.Contains(t, logs.String(), `"message":"Admin action"`)
+		assert.Contains(t, logs.String(), `"admin_id":"`+adminTestAdminID+`"`)
+		assert.Contains(t, logs.String(), `"action":"clear_list"`)
+		assert.Contains(t, logs.String(), `"status":400`)
+	})
+}
+
+func TestAdminAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).With().Timestamp().Logger()
+	app := &App{Log: &logger, Config: testConfig(t)}
+
+	router := gin.New()
+	router.POST("/users/:public_id/:list_type", func(c *gin.Context) {
+		c.Set("admin_id", adminTestAdminID)
+	}, app.AdminAudit("add_item"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest("POST", "/users/"+adminTestUserID+"/bids", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, logs.String(), `"public_id":"`+adminTestUserID+`"`)
+	assert.Contains(t, logs.String(), `"list_type":"bids"`)
+	assert.Contains(t, logs.String(), `"status":201`)
+}
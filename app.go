@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
 type App struct {
@@ -12,33 +19,148 @@ type App struct {
 	DB     *mongo.Database
 	Client *mongo.Client
 	Log    *zerolog.Logger
+	Items  ItemProvider
+	Auth   TokenVerifier
+	Config *Config
+
+	ServiceKeys *ServiceKeys
+
+	levels     *LogLevels
+	levelsOnce sync.Once
+
+	authCache   *TokenCache
+	serviceOnly map[string]bool
+	cursors     *CursorCodec
+	cursorOnce  sync.Once
+
+	rateLimits    *RateLimiter
+	rateLimitOnce sync.Once
+
+	cors     *CORSConfig
+	corsOnce sync.Once
+
+	watcherCounts    *WatcherCountPolicy
+	watcherCountOnce sync.Once
+
+	audit     *AuditConfig
+	auditOnce sync.Once
+
+	metrics       *Metrics
+	metricsOnce   sync.Once
+	metricsRouter *gin.Engine
+
+	tracerProvider *sdktrace.TracerProvider
+
+	health     *HealthChecker
+	healthOnce sync.Once
+
+	shutdown     *ShutdownConfig
+	shutdownOnce sync.Once
+	draining     atomic.Bool
 }
 
 func (a *App) InitialiseApp() {
 
+	// put the logger under runtime level control before anything copies it
+	a.initialiseLogLevels()
+
 	a.Log.Info().Msg("Initialising app")
 
 	// setup gin mode
-	if os.Getenv("LOGLEVEL") == "debug" {
+	if log := a.config().Log; log != nil && log.Level == zerolog.DebugLevel {
 		gin.SetMode(gin.DebugMode)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	// initialise router
-	a.Router = gin.Default()
+	router, err := newRouter(a.config().TrustedProxies)
+	if err != nil {
+		a.Log.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
+	a.Router = router
+
+	// initialise tracing before anything makes a traced call
+	a.initialiseTracing()
 
 	// initialise database
 	a.initialiseDatabase()
 
+	// initialise share link indexes
+	a.initialiseShares()
+
+	// initialise list change auditing
+	a.initialiseAudit()
+
+	// initialise token verification
+	a.initialiseAuth()
+
+	// initialise readiness checks
+	a.initialiseHealth()
+
+	// initialise service keys and list write rules
+	a.initialiseServiceAuth()
+
+	// initialise rate limiting
+	a.initialiseRateLimiting()
+
+	// initialise CORS origins
+	a.initialiseCORS()
+
+	// initialise watcher count privacy
+	a.initialiseWatcherCounts()
+
+	// initialise item details provider
+	a.initialiseItemProvider()
+
+	// initialise shutdown timings
+	a.initialiseShutdown()
+
 	// initialise routes
 	a.initialiseRoutes()
 
 }
 
+// newRouter returns a router without gin's text access log, which would
+// bypass the JSON logger and its redaction. LoggingMiddleware logs requests.
+// X-Forwarded-For is only believed from trustedProxies - gin trusts every
+// peer by default, which would let a client pick its own IP, and with it a
+// fresh rate limit bucket, on every request
+func newRouter(trustedProxies []string) (*gin.Engine, error) {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Run serves on addr until SIGINT or SIGTERM, then drains and cleans up
 func (a *App) Run(addr string) {
 	a.Log.Info().Msgf("Starting server on %s", addr)
-	if err := a.Router.Run(addr); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP re-reads the log levels
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go a.watchReloads(ctx, hup)
+
+	if a.metricsRouter != nil {
+		metricsLn, err := net.Listen("tcp", a.config().MetricsAddr)
+		if err != nil {
+			a.Log.Fatal().Err(err).Msg("Failed to start metrics server")
+		}
+		stopMetrics := a.serveMetrics(metricsLn)
+		defer stopMetrics()
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
 		a.Log.Fatal().Err(err).Msg("Failed to start server")
 	}
+	if err := a.Serve(ctx, ln); err != nil {
+		a.Log.Fatal().Err(err).Msg("Server failed")
+	}
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//-----------------------------------------------------------------------------
+// Mutation audit log
+// every change to a list appends a record to the audit collection saying
+// who made it, whose list it was and which items went in or out. Records
+// are kept for AUDIT_RETENTION (default 90 days, 0 keeps them forever) and
+// admins read them back through /list/admin/audit
+
+const (
+	auditCollection        = "audit"
+	defaultAuditRetention  = 90 * 24 * time.Hour
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+var auditOperations = []string{"add", "remove", "clear"}
+
+type AuditConfig struct {
+	Retention time.Duration
+}
+
+// NewAuditConfig reads AUDIT_RETENTION
+func NewAuditConfig(s Settings) (*AuditConfig, error) {
+	retention, err := time.ParseDuration(s.GetOrDefault("AUDIT_RETENTION", defaultAuditRetention.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUDIT_RETENTION: %w", err)
+	}
+	if retention < 0 {
+		return nil, fmt.Errorf("AUDIT_RETENTION must not be negative")
+	}
+	return &AuditConfig{Retention: retention}, nil
+}
+
+// EnsureAuditIndexes creates the query and retention indexes. Each record
+// carries its own expires_at, so a change to AUDIT_RETENTION applies to new
+// records without rebuilding the index
+func EnsureAuditIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "public_id", Value: 1}, {Key: "at", Value: -1}},
+			Options: options.Index().SetName("public_id_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "at", Value: -1}},
+			Options: options.Index().SetName("at"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+		},
+	})
+	return err
+}
+
+// initialiseAudit reads the retention period and creates the audit indexes
+func (a *App) initialiseAudit() {
+	config := a.config().Audit
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid audit settings")
+	}
+	a.audit = config
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := EnsureAuditIndexes(ctx, a.GetCollection(auditCollection)); err != nil {
+		a.Log.Fatal().Err(err).Msg("Failed to create audit indexes")
+	}
+	a.Log.Info().Dur("retention", config.Retention).Msg("Audit log configured")
+}
+
+// auditConfig returns the app's audit settings, taking them from the config
+// on first use
+func (a *App) auditConfig() *AuditConfig {
+	a.auditOnce.Do(func() {
+		if a.audit != nil {
+			return
+		}
+		config := a.config().Audit
+		if config == nil {
+			a.Log.Error().Msg("Invalid audit settings, using the default retention")
+			config = &AuditConfig{Retention: defaultAuditRetention}
+		}
+		a.audit = config
+	})
+	return a.audit
+}
+
+// auditActor says who is making a request: a service, an admin acting
+// through the admin routes, or the user themselves
+func auditActor(c *gin.Context) (string, string) {
+	if v, ok := c.Get("service"); ok {
+		return "service", v.(*ServiceIdentity).Name
+	}
+	if adminID, ok := c.Get("admin_id"); ok {
+		return "admin", adminID.(string)
+	}
+	publicID, _ := c.Get("public_id")
+	return "user", publicID.(string)
+}
+
+// newAuditRecord builds the record for a list change made by a request
+func (a *App) newAuditRecord(c *gin.Context, operation, listType string, change *ListChange) AuditRecord {
+	publicID, _ := c.Get("public_id")
+	record := AuditRecord{
+		ID:        uuid.New().String(),
+		PublicID:  publicID.(string),
+		ListType:  listType,
+		Operation: operation,
+		ItemIDs:   []string{},
+		RequestID: c.GetString("request_id"),
+		At:        time.Now().UTC(),
+	}
+	record.ActorType, record.ActorID = auditActor(c)
+
+	if operation == "add" {
+		record.ItemIDs = append(record.ItemIDs, change.Added...)
+		record.EvictedIDs = change.Removed
+	} else {
+		record.ItemIDs = append(record.ItemIDs, change.Removed...)
+	}
+
+	if retention := a.auditConfig().Retention; retention > 0 {
+		expiresAt := record.At.Add(retention)
+		record.ExpiresAt = &expiresAt
+	}
+	return record
+}
+
+// auditListChange records a successful list mutation. The change has
+// already been made, so a failed write is logged rather than failing the
+// request. Requests that changed nothing, such as adding an item that is
+// already there, aren't recorded
+func (a *App) auditListChange(c *gin.Context, operation, listType string, change *ListChange) {
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return
+	}
+	record := a.newAuditRecord(c, operation, listType, change)
+
+	a.requestLog(c).Info().
+		Str("actor_type", record.ActorType).
+		Str("public_id", record.PublicID).
+		Str("list_type", record.ListType).
+		Str("operation", record.Operation).
+		Strs("item_ids", record.ItemIDs).
+		Strs("evicted_ids", record.EvictedIDs).
+		Msg("List changed")
+
+	if a.DB == nil {
+		return
+	}
+
+	// the change is made, so record it even if the client has gone
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 5*time.Second)
+	defer cancel()
+
+	if _, err := a.GetCollection(auditCollection).InsertOne(ctx, record); err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error recording list change")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Admin query
+
+// AuditQuery filters the audit log. Every field is optional
+type AuditQuery struct {
+	PublicID  string
+	ListType  string
+	Operation string
+	Since     *time.Time
+	Until     *time.Time
+	Limit     int
+}
+
+// ParseAuditQuery reads public_id, list_type, operation, since, until and
+// limit from the query string
+func ParseAuditQuery(c *gin.Context) (AuditQuery, error) {
+	q := AuditQuery{
+		PublicID:  c.Query("public_id"),
+		ListType:  c.Query("list_type"),
+		Operation: c.Query("operation"),
+		Limit:     defaultAuditQueryLimit,
+	}
+
+	if q.PublicID != "" && !IsValidUUID(q.PublicID) {
+		return q, &ListQueryError{Field: "public_id", Message: "must be a UUID"}
+	}
+	if q.ListType != "" && !IsListRouteType(q.ListType) {
+		return q, &ListQueryError{Field: "list_type", Message: "is not a list type"}
+	}
+	if q.Operation != "" && !Contains(auditOperations, q.Operation) {
+		return q, &ListQueryError{Field: "operation", Message: "must be add, remove or clear"}
+	}
+
+	for _, field := range []string{"since", "until"} {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+		t, err := ParseRFC3339(raw)
+		if err != nil {
+			return q, &ListQueryError{Field: field, Message: "must be an RFC3339 timestamp"}
+		}
+		if field == "since" {
+			q.Since = &t
+		} else {
+			q.Until = &t
+		}
+	}
+	if q.Since != nil && q.Until != nil && !q.Since.Before(*q.Until) {
+		return q, &ListQueryError{Field: "until", Message: "must be after since"}
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			return q, &ListQueryError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxAuditQueryLimit)}
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// Filter is the MongoDB filter for the query
+func (q AuditQuery) Filter() bson.M {
+	filter := bson.M{}
+	if q.PublicID != "" {
+		filter["public_id"] = q.PublicID
+	}
+	if q.ListType != "" {
+		filter["list_type"] = q.ListType
+	}
+	if q.Operation != "" {
+		filter["operation"] = q.Operation
+	}
+	at := bson.M{}
+	if q.Since != nil {
+		at["$gte"] = *q.Since
+	}
+	if q.Until != nil {
+		at["$lt"] = *q.Until
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+	return filter
+}
+
+// GetAuditRecords returns matching audit records, newest first. has_more
+// says there are older records; pass the last record's at as until to read
+// them
+func (a *App) GetAuditRecords(c *gin.Context) {
+	query, err := ParseAuditQuery(c)
+	if err != nil {
+		qe := err.(*ListQueryError)
+		c.JSON(http.StatusBadRequest, NewValidationError(qe.Field, qe.Message))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}}).
+		SetLimit(int64(query.Limit + 1))
+	cursor, err := a.GetCollection(auditCollection).Find(ctx, query.Filter(), opts)
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error querying audit log")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	records := make([]AuditRecord, 0)
+	if err := cursor.All(ctx, &records); err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error reading audit log")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	hasMore := len(records) > query.Limit
+	if hasMore {
+		records = records[:query.Limit]
+	}
+	c.JSON(http.StatusOK, gin.H{"records": records, "has_more": hasMore})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAuditConfig(t *testing.T) {
+	t.Run("should keep records for 90 days by default", func(t *testing.T) {
+		t.Setenv("AUDIT_RETENTION", "")
+		config, err := NewAuditConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, 90*24*time.Hour, config.Retention)
+	})
+
+	t.Run("should read the retention period", func(t *testing.T) {
+		for raw, want := range map[string]time.Duration{"720h": 720 * time.Hour, "0": 0} {
+			t.Setenv("AUDIT_RETENTION", raw)
+			config, err := NewAuditConfig(EnvSettings())
+			require.NoError(t, err, raw)
+			assert.Equal(t, want, config.Retention, raw)
+		}
+	})
+
+	t.Run("should reject bad retention periods", func(t *testing.T) {
+		for _, raw := range []string{"90 days", "-1h"} {
+			t.Setenv("AUDIT_RETENTION", raw)
+			_, err := NewAuditConfig(EnvSettings())
+			assert.Error(t, err, raw)
+		}
+	})
+}
+
+func TestAuditRecords(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	newContext := func(values map[string]interface{}) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/list/watchlist", nil)
+		c.Set("request_id", "req-1")
+		for k, v := range values {
+			c.Set(k, v)
+		}
+		return c
+	}
+
+	t.Run("should name the actor", func(t *testing.T) {
+		actorType, actorID := auditActor(newContext(map[string]interface{}{"public_id": adminTestUserID}))
+		assert.Equal(t, "user", actorType)
+		assert.Equal(t, adminTestUserID, actorID)
+
+		actorType, actorID = auditActor(newContext(map[string]interface{}{"public_id": adminTestUserID, "admin_id": adminTestAdminID}))
+		assert.Equal(t, "admin", actorType)
+		assert.Equal(t, adminTestAdminID, actorID)
+
+		actorType, actorID = auditActor(newContext(map[string]interface{}{
+			"public_id": adminTestUserID,
+			"service":   &ServiceIdentity{Name: "auction", PublicID: adminTestUserID},
+		}))
+		assert.Equal(t, "service", actorType)
+		assert.Equal(t, "auction", actorID)
+	})
+
+	t.Run("should record evictions separately for adds", func(t *testing.T) {
+		app := &App{Log: &logger, Config: testConfig(t), audit: &AuditConfig{Retention: time.Hour}}
+		c := newContext(map[string]interface{}{"public_id": adminTestUserID})
+
+		record := app.newAuditRecord(c, "add", "viewed", &ListChange{Added: []string{"new"}, Removed: []string{"old"}})
+		assert.Equal(t, adminTestUserID, record.PublicID)
+		assert.Equal(t, "viewed", record.ListType)
+		assert.Equal(t, []string{"new"}, record.ItemIDs)
+		assert.Equal(t, []string{"old"}, record.EvictedIDs)
+		assert.Equal(t, "req-1", record.RequestID)
+		require.NotNil(t, record.ExpiresAt)
+		assert.Equal(t, time.Hour, record.ExpiresAt.Sub(record.At))
+
+		record = app.newAuditRecord(c, "clear", "viewed", &ListChange{Removed: []string{"a", "b"}})
+		assert.Equal(t, []string{"a", "b"}, record.ItemIDs)
+		assert.Empty(t, record.EvictedIDs)
+
+		// a no-op builds a record with no items, though it's never written
+		record = app.newAuditRecord(c, "remove", "viewed", &ListChange{})
+		assert.Equal(t, []string{}, record.ItemIDs)
+	})
+
+	t.Run("should keep records forever with no retention", func(t *testing.T) {
+		app := &App{Log: &logger, Config: testConfig(t), audit: &AuditConfig{}}
+		record := app.newAuditRecord(newContext(map[string]interface{}{"public_id": adminTestUserID}), "add", "watchlist", &ListChange{})
+		assert.Nil(t, record.ExpiresAt)
+	})
+}
+
+func TestAuditQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	parse := func(rawQuery string) (AuditQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/list/admin/audit?"+rawQuery, nil)
+		return ParseAuditQuery(c)
+	}
+
+	t.Run("should build a filter from the query", func(t *testing.T) {
+		q, err := parse("public_id=" + adminTestUserID + "&list_type=watchlist&operation=clear&since=2025-01-01T00:00:00Z&until=2025-02-01T00:00:00Z&limit=10")
+		require.NoError(t, err)
+		assert.Equal(t, 10, q.Limit)
+
+		since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		until := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, bson.M{
+			"public_id": adminTestUserID,
+			"list_type": "watchlist",
+			"operation": "clear",
+			"at":        bson.M{"$gte": since, "$lt": until},
+		}, q.Filter())
+	})
+
+	t.Run("should match everything by default", func(t *testing.T) {
+		q, err := parse("")
+		require.NoError(t, err)
+		assert.Equal(t, defaultAuditQueryLimit, q.Limit)
+		assert.Equal(t, bson.M{}, q.Filter())
+	})
+
+	t.Run("should reject bad parameters", func(t *testing.T) {
+		for rawQuery, field := range map[string]string{
+			"public_id=nobody": "public_id",
+			"list_type=shares": "list_type",
+			"operation=update": "operation",
+			"since=yesterday":  "since",
+			"limit=0":          "limit",
+			"limit=5000":       "limit",
+			"since=2025-02-01T00:00:00Z&until=2025-01-01T00:00:00Z": "until",
+		} {
+			_, err := parse(rawQuery)
+			require.Error(t, err, rawQuery)
+			assert.Equal(t, field, err.(*ListQueryError).Field, rawQuery)
+		}
+	})
+
+	t.Run("should only be open to admins", func(t *testing.T) {
+		logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+		t.Setenv("RATE_LIMIT_REQUESTS", "0")
+		app := &App{
+			Log:    &logger,
+			Config: testConfig(t),
+			Auth: roleVerifier{
+				"admin-token": {PublicID: adminTestAdminID, Roles: []string{"admin"}},
+				"user-token":  {PublicID: adminTestUserID},
+			},
+		}
+		app.Router = gin.New()
+		app.initialiseRoutes()
+
+		get := func(path, token string) int {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Access-Token", token)
+			resp := httptest.NewRecorder()
+			app.Router.ServeHTTP(resp, req)
+			return resp.Code
+		}
+		assert.Equal(t, http.StatusForbidden, get("/list/admin/audit", "user-token"))
+		assert.Equal(t, http.StatusBadRequest, get("/list/admin/audit?operation=update", "admin-token"))
+	})
+}
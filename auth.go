@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//-----------------------------------------------------------------------------
+// Token verification
+// AuthMiddleware hands the access token to a TokenVerifier, which is either
+// the authy callout or local JWT verification depending on AUTH_MODE
+
+// Identity is who an access token belongs to
+type Identity struct {
+	PublicID string
+	Roles    []string
+}
+
+// HasRole reports whether the identity holds role
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRoles reads roles from a JSON array of strings or a space or comma
+// separated string, the two shapes authy and JWT issuers use
+func parseRoles(v interface{}) []string {
+	var roles []string
+	switch r := v.(type) {
+	case []interface{}:
+		for _, role := range r {
+			if s, ok := role.(string); ok && s != "" {
+				roles = append(roles, s)
+			}
+		}
+	case []string:
+		roles = append(roles, r...)
+	case string:
+		roles = strings.FieldsFunc(r, func(c rune) bool { return c == ' ' || c == ',' })
+	}
+	return roles
+}
+
+// TokenVerifier resolves an access token to an Identity
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+// AuthError carries the response AuthMiddleware should send when a token
+// can't be verified
+type AuthError struct {
+	Status     int
+	Message    string
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTokenRejected marks errors where the token itself was refused, as
+// opposed to the verifier being unable to check it
+var ErrTokenRejected = errors.New("token rejected")
+
+// ErrAuthyUnavailable marks errors where authy couldn't be reached or
+// answered with a 502/503/504 after retries, so nothing is known about the
+// token
+var ErrAuthyUnavailable = errors.New("authentication service unavailable")
+
+// authyRetryAfter is the Retry-After sent when authy is unhealthy but the
+// breaker hasn't opened to say how long to wait
+const authyRetryAfter = 5 * time.Second
+
+func errInvalidToken(err error) *AuthError {
+	if err == nil {
+		err = ErrTokenRejected
+	} else {
+		err = fmt.Errorf("%w: %w", ErrTokenRejected, err)
+	}
+	return &AuthError{Status: http.StatusUnauthorized, Message: "Invalid or expired token", Err: err}
+}
+
+//-----------------------------------------------------------------------------
+// Authy verifier
+// asks the authy service at AUTHYURL who the token belongs to
+
+type AuthyVerifier struct {
+	url     string
+	client  *AuthyClient
+	log     *zerolog.Logger
+	metrics *Metrics
+}
+
+func NewAuthyVerifier(url string, client *AuthyClient, log *zerolog.Logger) *AuthyVerifier {
+	return &AuthyVerifier{url: url, client: client, log: log}
+}
+
+func (v *AuthyVerifier) Verify(ctx context.Context, accessToken string) (*Identity, error) {
+	ctx, span := tracer().Start(ctx, "authy.verify", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	start := time.Now()
+	identity, err := v.verify(ctx, accessToken)
+	if v.metrics != nil {
+		v.metrics.ObserveAuthy(err, time.Since(start))
+	}
+
+	outcome := authyOutcome(err)
+	span.SetAttributes(attribute.String("authy.outcome", outcome))
+	// a rejected token is authy doing its job, not a failure
+	if outcome == "unavailable" || outcome == "error" {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "")
+	}
+	return identity, err
+}
+
+func (v *AuthyVerifier) verify(ctx context.Context, accessToken string) (*Identity, error) {
+	log := tagRequest(ctx, v.log)
+	if v.url == "" {
+		log.Error().Msg("AUTHYURL not set")
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service env error"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", v.url, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create authentication request")
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service error", Err: err}
+	}
+
+	req.Header.Set("X-Access-Token", accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	setRequestIDHeader(ctx, req)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := v.client.Do(ctx, req)
+	if err != nil {
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			log.Warn().Dur("retry_after", open.RetryAfter).Msg("Authentication service circuit open")
+			return nil, &AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable", Err: fmt.Errorf("%w: %w", ErrAuthyUnavailable, err), RetryAfter: open.RetryAfter}
+		}
+		// a timeout or refused connection says nothing about the token either
+		log.Error().Err(err).Msg("Failed to call authentication service")
+		return nil, &AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable", Err: fmt.Errorf("%w: %w", ErrAuthyUnavailable, err), RetryAfter: authyRetryAfter}
+	}
+
+	if retryable(resp.StatusCode) {
+		// authy is struggling, that says nothing about the token, so the
+		// client should try again rather than log in again
+		log.Error().Int("status", resp.StatusCode).Msg("Authentication service unhealthy")
+		return nil, &AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable", Err: fmt.Errorf("%w: status %d", ErrAuthyUnavailable, resp.StatusCode), RetryAfter: authyRetryAfter}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// the body can echo the token back, so only its size is logged
+		log.Warn().Int("status", resp.StatusCode).Int("body_bytes", len(resp.Body)).Msg("Authentication failed")
+		return nil, errInvalidToken(nil)
+	}
+
+	var authResponse struct {
+		PublicID string      `json:"public_id"`
+		Roles    interface{} `json:"roles"`
+	}
+
+	if err := json.Unmarshal(resp.Body, &authResponse); err != nil {
+		log.Error().Err(err).Msg("Failed to parse authentication response")
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service response error", Err: err}
+	}
+
+	if authResponse.PublicID == "" {
+		log.Error().Msg("Authentication service returned empty public_id")
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service response error"}
+	}
+
+	if !IsValidUUID(authResponse.PublicID) {
+		log.Error().Str("public_id", authResponse.PublicID).Msg("Authentication service returned invalid public_id format")
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service response error"}
+	}
+
+	return &Identity{PublicID: authResponse.PublicID, Roles: parseRoles(authResponse.Roles)}, nil
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+// initialiseAuth selects the token verifier from AUTH_MODE - authy (the
+// default) or jwt
+func (a *App) initialiseAuth() {
+	config := a.config()
+	switch mode := config.AuthMode; mode {
+	case "authy":
+		if config.Authy == nil {
+			a.Log.Fatal().Msg("Invalid authy client settings")
+		}
+		verifier := NewAuthyVerifier(config.AuthyURL, NewAuthyClient(config.Authy), a.componentLog(componentAuth))
+		verifier.metrics = a.appMetrics()
+		a.Auth = verifier
+		a.Log.Info().Msg("Authenticating tokens with authy")
+		if size := config.AuthCacheSize; size > 0 {
+			a.authCache = NewTokenCache(size)
+			a.Auth = NewCachingVerifier(a.Auth, a.authCache, config.AuthCacheTTL, config.AuthCacheNegativeTTL)
+			a.Log.Info().Int("size", size).Dur("ttl", config.AuthCacheTTL).Msg("Caching authy lookups")
+		}
+	case "jwt":
+		if config.JWT == nil {
+			a.Log.Fatal().Msg("Invalid JWT settings")
+		}
+		v, err := NewJWTVerifier(config.JWT, a.componentLog(componentAuth))
+		if err != nil {
+			a.Log.Fatal().Err(err).Msg("Failed to set up JWT verification")
+		}
+		a.Auth = v
+		a.Log.Info().Msg("Authenticating tokens as JWTs")
+	default:
+		a.Log.Fatal().Msgf("Unknown AUTH_MODE [%s]", mode)
+	}
+}
+
+// tokenVerifier returns the configured verifier, falling back to authy for
+// apps that haven't been through InitialiseApp
+func (a *App) tokenVerifier() TokenVerifier {
+	if a.Auth != nil {
+		return a.Auth
+	}
+	config := a.config()
+	client := &AuthyClient{HTTP: &http.Client{Timeout: 5 * time.Second}}
+	if config.Authy != nil {
+		client = NewAuthyClient(config.Authy)
+	}
+	verifier := NewAuthyVerifier(config.AuthyURL, client, a.componentLog(componentAuth))
+	verifier.metrics = a.appMetrics()
+	return verifier
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+//-----------------------------------------------------------------------------
+// Token cache
+// a bounded LRU of token lookups. Keys are hashes of the token so raw tokens
+// are never held in memory longer than the request that carried them
+
+type TokenCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+type tokenCacheEntry struct {
+	key      string
+	identity *Identity
+	err      error
+	expires  time.Time
+}
+
+func NewTokenCache(capacity int) *TokenCache {
+	return &TokenCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// HashToken returns the cache key for an access token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached result for key. The bool is false when nothing
+// unexpired is cached
+func (tc *TokenCache) Get(key string) (*Identity, error, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	el, ok := tc.items[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := el.Value.(*tokenCacheEntry)
+	if tc.now().After(entry.expires) {
+		tc.removeElement(el)
+		return nil, nil, false
+	}
+	tc.ll.MoveToFront(el)
+	return entry.identity, entry.err, true
+}
+
+// Add caches a lookup result for ttl, evicting the least recently used
+// entry if the cache is full
+func (tc *TokenCache) Add(key string, identity *Identity, err error, ttl time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	expires := tc.now().Add(ttl)
+	if el, ok := tc.items[key]; ok {
+		entry := el.Value.(*tokenCacheEntry)
+		entry.identity, entry.err, entry.expires = identity, err, expires
+		tc.ll.MoveToFront(el)
+		return
+	}
+
+	tc.items[key] = tc.ll.PushFront(&tokenCacheEntry{key: key, identity: identity, err: err, expires: expires})
+	for tc.ll.Len() > tc.capacity {
+		tc.removeElement(tc.ll.Back())
+	}
+}
+
+// Remove drops key from the cache
+func (tc *TokenCache) Remove(key string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if el, ok := tc.items[key]; ok {
+		tc.removeElement(el)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet
+// evicted
+func (tc *TokenCache) Len() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.ll.Len()
+}
+
+func (tc *TokenCache) removeElement(el *list.Element) {
+	tc.ll.Remove(el)
+	delete(tc.items, el.Value.(*tokenCacheEntry).key)
+}
+
+//-----------------------------------------------------------------------------
+// Caching verifier
+// wraps another verifier (normally authy), caching successful lookups for TTL
+// and rejected tokens for NegativeTTL. Concurrent lookups of the same token
+// share a single call
+
+type CachingVerifier struct {
+	Next        TokenVerifier
+	Cache       *TokenCache
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	group singleflight.Group
+}
+
+func NewCachingVerifier(next TokenVerifier, cache *TokenCache, ttl, negativeTTL time.Duration) *CachingVerifier {
+	return &CachingVerifier{
+		Next:        next,
+		Cache:       cache,
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+	}
+}
+
+func (v *CachingVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	key := HashToken(token)
+	if identity, err, ok := v.Cache.Get(key); ok {
+		return identity, err
+	}
+
+	result, err, _ := v.group.Do(key, func() (interface{}, error) {
+		// the lookup is shared so one caller going away mustn't cancel it
+		identity, err := v.Next.Verify(context.WithoutCancel(ctx), token)
+		switch {
+		case err == nil:
+			v.Cache.Add(key, identity, nil, v.TTL)
+		case errors.Is(err, ErrTokenRejected) && v.NegativeTTL > 0:
+			v.Cache.Add(key, nil, err, v.NegativeTTL)
+		}
+		return identity, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Identity), nil
+}
+
+//-----------------------------------------------------------------------------
+// Invalidation
+
+// InvalidateToken drops the token in X-Access-Token from the cache, so a
+// logged out token stops working here as soon as authy stops accepting it
+func (a *App) InvalidateToken(c *gin.Context) {
+	accessToken := c.GetHeader("X-Access-Token")
+	if accessToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing X-Access-Token header"})
+		return
+	}
+
+	if a.authCache != nil {
+		a.authCache.Remove(HashToken(accessToken))
+	}
+
+	c.Status(http.StatusNoContent)
+}
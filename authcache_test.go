@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingVerifier accepts "good" tokens, rejects everything else and counts
+// how often it is called
+type countingVerifier struct {
+	calls   int32
+	release chan struct{}
+	err     error
+}
+
+func (v *countingVerifier) Verify(_ context.Context, token string) (*Identity, error) {
+	atomic.AddInt32(&v.calls, 1)
+	if v.release != nil {
+		<-v.release
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	if token != "good" {
+		return nil, errInvalidToken(nil)
+	}
+	return &Identity{PublicID: authTestPublicID}, nil
+}
+
+func TestTokenCache(t *testing.T) {
+	t.Run("should evict the least recently used entry", func(t *testing.T) {
+		cache := NewTokenCache(2)
+		cache.Add("a", &Identity{PublicID: "a"}, nil, time.Minute)
+		cache.Add("b", &Identity{PublicID: "b"}, nil, time.Minute)
+
+		_, _, ok := cache.Get("a")
+		require.True(t, ok)
+
+		cache.Add("c", &Identity{PublicID: "c"}, nil, time.Minute)
+
+		_, _, ok = cache.Get("b")
+		assert.False(t, ok)
+		_, _, ok = cache.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 2, cache.Len())
+	})
+
+	t.Run("should expire entries after their ttl", func(t *testing.T) {
+		now := time.Now()
+		cache := NewTokenCache(10)
+		cache.now = func() time.Time { return now }
+
+		cache.Add("a", &Identity{PublicID: "a"}, nil, time.Minute)
+		_, _, ok := cache.Get("a")
+		assert.True(t, ok)
+
+		now = now.Add(2 * time.Minute)
+		_, _, ok = cache.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("should remove entries", func(t *testing.T) {
+		cache := NewTokenCache(10)
+		cache.Add("a", &Identity{PublicID: "a"}, nil, time.Minute)
+		cache.Remove("a")
+		_, _, ok := cache.Get("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("should not key on the raw token", func(t *testing.T) {
+		key := HashToken("secret-token")
+		assert.NotContains(t, key, "secret-token")
+		assert.Len(t, key, 64)
+	})
+}
+
+func TestCachingVerifier(t *testing.T) {
+	t.Run("should cache successful lookups", func(t *testing.T) {
+		next := &countingVerifier{}
+		v := NewCachingVerifier(next, NewTokenCache(10), time.Minute, time.Second)
+
+		for i := 0; i < 3; i++ {
+			identity, err := v.Verify(context.Background(), "good")
+			require.NoError(t, err)
+			assert.Equal(t, authTestPublicID, identity.PublicID)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&next.calls))
+	})
+
+	t.Run("should cache rejected tokens", func(t *testing.T) {
+		next := &countingVerifier{}
+		v := NewCachingVerifier(next, NewTokenCache(10), time.Minute, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(context.Background(), "bad")
+			assert.ErrorIs(t, err, ErrTokenRejected)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&next.calls))
+	})
+
+	t.Run("should not cache service failures", func(t *testing.T) {
+		next := &countingVerifier{err: &AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable"}}
+		v := NewCachingVerifier(next, NewTokenCache(10), time.Minute, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(context.Background(), "good")
+			assert.Error(t, err)
+		}
+		assert.Equal(t, int32(3), atomic.LoadInt32(&next.calls))
+	})
+
+	t.Run("should collapse concurrent lookups of the same token", func(t *testing.T) {
+		next := &countingVerifier{release: make(chan struct{})}
+		v := NewCachingVerifier(next, NewTokenCache(10), time.Minute, time.Second)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				identity, err := v.Verify(context.Background(), "good")
+				assert.NoError(t, err)
+				assert.Equal(t, authTestPublicID, identity.PublicID)
+			}()
+		}
+
+		// give the goroutines time to pile up behind the first lookup
+		time.Sleep(50 * time.Millisecond)
+		close(next.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&next.calls))
+	})
+}
+
+func TestInvalidateToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	next := &countingVerifier{}
+	app := &App{Log: &logger, Config: testConfig(t), authCache: NewTokenCache(10)}
+	app.Auth = NewCachingVerifier(next, app.authCache, time.Minute, time.Second)
+
+	router := gin.New()
+	router.DELETE("/list/auth/token", app.InvalidateToken)
+
+	_, err := app.Auth.Verify(context.Background(), "good")
+	require.NoError(t, err)
+	assert.Equal(t, 1, app.authCache.Len())
+
+	t.Run("should drop the token from the cache", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/list/auth/token", nil)
+		req.Header.Set("X-Access-Token", "good")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, 0, app.authCache.Len())
+
+		_, err := app.Auth.Verify(context.Background(), "good")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&next.calls))
+	})
+
+	t.Run("should require a token", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/list/auth/token", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
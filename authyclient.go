@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Authy HTTP client
+// one pooled client shared by every request. Each attempt gets its own
+// timeout within an overall budget, idempotent requests are retried with
+// jittered backoff and a circuit breaker stops us hammering authy while it's
+// down
+
+type AuthyClient struct {
+	HTTP           *http.Client
+	AttemptTimeout time.Duration
+	TotalTimeout   time.Duration
+	MaxRetries     int
+	BaseBackoff    time.Duration
+	MaxBackoff     time.Duration
+	Breaker        *CircuitBreaker
+}
+
+// AuthyResponse is a fully read authy response
+type AuthyResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// AuthyClientConfig holds the AUTHY_* client settings
+type AuthyClientConfig struct {
+	AttemptTimeout   time.Duration
+	TotalTimeout     time.Duration
+	MaxRetries       int
+	BaseBackoff      time.Duration
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	MaxIdleConns     int
+}
+
+// NewAuthyClientConfig reads the AUTHY_* settings
+func NewAuthyClientConfig(s Settings) (*AuthyClientConfig, error) {
+	r := &configReader{s: s}
+	config := &AuthyClientConfig{
+		AttemptTimeout:   r.duration("AUTHY_ATTEMPT_TIMEOUT", 2*time.Second),
+		TotalTimeout:     r.duration("AUTHY_TOTAL_TIMEOUT", 5*time.Second),
+		MaxRetries:       r.int("AUTHY_MAX_RETRIES", 2, 0),
+		BaseBackoff:      r.duration("AUTHY_BACKOFF", 100*time.Millisecond),
+		MaxBackoff:       r.duration("AUTHY_MAX_BACKOFF", time.Second),
+		BreakerThreshold: r.int("AUTHY_BREAKER_THRESHOLD", 5, 0),
+		BreakerCooldown:  r.duration("AUTHY_BREAKER_COOLDOWN", 30*time.Second),
+		MaxIdleConns:     r.int("AUTHY_MAX_IDLE_CONNS", 100, 0),
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewAuthyClient builds a pooled client from the authy settings
+func NewAuthyClient(config *AuthyClientConfig) *AuthyClient {
+	return &AuthyClient{
+		HTTP: &http.Client{
+			Transport: newPooledTransport(config.MaxIdleConns, 90*time.Second),
+		},
+		AttemptTimeout: config.AttemptTimeout,
+		TotalTimeout:   config.TotalTimeout,
+		MaxRetries:     config.MaxRetries,
+		BaseBackoff:    config.BaseBackoff,
+		MaxBackoff:     config.MaxBackoff,
+		Breaker:        NewCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+	}
+}
+
+// newPooledTransport clones the default transport with room for more idle
+// connections to authy
+func newPooledTransport(maxIdle int, idleTimeout time.Duration) http.RoundTripper {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		// something (usually a test mock) has replaced the default transport
+		return http.DefaultTransport
+	}
+	t := base.Clone()
+	t.MaxIdleConns = maxIdle
+	t.MaxIdleConnsPerHost = maxIdle
+	t.IdleConnTimeout = idleTimeout
+	return t
+}
+
+// retryable reports whether a response status is worth another attempt
+func retryable(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// backoff returns a full jitter delay for the given retry
+func (ac *AuthyClient) backoff(retry int) time.Duration {
+	ceiling := ac.BaseBackoff << retry
+	if ceiling > ac.MaxBackoff || ceiling <= 0 {
+		ceiling = ac.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// Do sends req, retrying GET and HEAD requests that fail at the network
+// level or with a 502/503/504. The breaker sees one result per call. A call
+// the caller gave up on, by cancelling ctx or through its own deadline, says
+// nothing about authy and isn't counted
+func (ac *AuthyClient) Do(ctx context.Context, req *http.Request) (*AuthyResponse, error) {
+	if ok, retryAfter := ac.Breaker.Allow(); !ok {
+		return nil, &CircuitOpenError{RetryAfter: retryAfter}
+	}
+
+	parent := ctx
+	if ac.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ac.TotalTimeout)
+		defer cancel()
+	}
+
+	attempts := 1
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		attempts += ac.MaxRetries
+	}
+
+	var resp *AuthyResponse
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(ac.backoff(attempt - 1)):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+		}
+
+		resp, err = ac.attempt(ctx, req)
+		if err == nil && !retryable(resp.StatusCode) {
+			ac.Breaker.Success()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if parent.Err() != nil {
+		ac.Breaker.Abandon()
+		return nil, parent.Err()
+	}
+	ac.Breaker.Failure()
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (ac *AuthyClient) attempt(ctx context.Context, req *http.Request) (*AuthyResponse, error) {
+	if ac.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ac.AttemptTimeout)
+		defer cancel()
+	}
+
+	resp, err := ac.HTTP.Do(req.Clone(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return &AuthyResponse{StatusCode: resp.StatusCode, Body: body}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Circuit breaker
+// opens after Threshold consecutive failures and rejects calls for Cooldown.
+// After that a single probe is let through - success closes the breaker
+// again, failure reopens it
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// CircuitOpenError is returned while the breaker is rejecting calls
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open, retry after %s", e.RetryAfter)
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		state:     CircuitClosed,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may go ahead and, if not, how long until the
+// breaker will let a probe through
+func (cb *CircuitBreaker) Allow() (bool, time.Duration) {
+	if cb == nil || cb.Threshold <= 0 {
+		return true, 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		wait := cb.openedAt.Add(cb.Cooldown).Sub(cb.now())
+		if wait > 0 {
+			return false, wait
+		}
+		cb.state = CircuitHalfOpen
+		return true, 0
+	case CircuitHalfOpen:
+		// a probe is already in flight
+		return false, cb.Cooldown
+	}
+	return true, 0
+}
+
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Failure() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.state == CircuitHalfOpen || (cb.Threshold > 0 && cb.failures >= cb.Threshold) {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// Abandon records a call that ended without saying anything about authy.
+// A probe that is abandoned leaves the breaker open, and the next call is
+// let through as a fresh probe
+func (cb *CircuitBreaker) Abandon() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitHalfOpen {
+		cb.state = CircuitOpen
+	}
+}
+
+// State returns the breaker's current state
+func (cb *CircuitBreaker) State() CircuitState {
+	if cb == nil {
+		return CircuitClosed
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
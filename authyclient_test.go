@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const authTestPublicIDJSON = `{"public_id": "` + authTestPublicID + `"}`
+
+func newTestAuthyClient(server *httptest.Server) *AuthyClient {
+	return &AuthyClient{
+		HTTP:           server.Client(),
+		AttemptTimeout: 50 * time.Millisecond,
+		TotalTimeout:   time.Second,
+		MaxRetries:     2,
+		BaseBackoff:    time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Breaker:        NewCircuitBreaker(3, time.Minute),
+	}
+}
+
+func newAuthyRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	return req
+}
+
+func TestAuthyClient(t *testing.T) {
+	t.Run("should retry idempotent requests on 503", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(authTestPublicIDJSON))
+		}))
+		defer server.Close()
+
+		client := newTestAuthyClient(server)
+		resp, err := client.Do(context.Background(), newAuthyRequest(t, server.URL))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, authTestPublicIDJSON, string(resp.Body))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+		assert.Equal(t, CircuitClosed, client.Breaker.State())
+	})
+
+	t.Run("should not retry rejected tokens", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		resp, err := newTestAuthyClient(server).Do(context.Background(), newAuthyRequest(t, server.URL))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("should not retry non idempotent requests", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		req, err := http.NewRequest("POST", server.URL, nil)
+		require.NoError(t, err)
+		_, err = newTestAuthyClient(server).Do(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("should time out hung attempts and retry", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-r.Context().Done()
+				return
+			}
+			_, _ = w.Write([]byte(authTestPublicIDJSON))
+		}))
+		defer server.Close()
+
+		resp, err := newTestAuthyClient(server).Do(context.Background(), newAuthyRequest(t, server.URL))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("should give up after the total timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		client := newTestAuthyClient(server)
+		client.AttemptTimeout = time.Minute
+		client.TotalTimeout = 50 * time.Millisecond
+
+		start := time.Now()
+		_, err := client.Do(context.Background(), newAuthyRequest(t, server.URL))
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("should not count calls the caller gave up on", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		client := newTestAuthyClient(server)
+		client.AttemptTimeout = time.Minute
+		for i := 0; i < 3; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			_, err := client.Do(ctx, newAuthyRequest(t, server.URL))
+			assert.ErrorIs(t, err, context.Canceled)
+
+			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err = client.Do(ctx, newAuthyRequest(t, server.URL))
+			cancel()
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+		assert.Equal(t, CircuitClosed, client.Breaker.State())
+	})
+
+	t.Run("should open the breaker after repeated failures", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := newTestAuthyClient(server)
+		client.MaxRetries = 0
+		for i := 0; i < 3; i++ {
+			_, err := client.Do(context.Background(), newAuthyRequest(t, server.URL))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, CircuitOpen, client.Breaker.State())
+
+		_, err := client.Do(context.Background(), newAuthyRequest(t, server.URL))
+		var open *CircuitOpenError
+		require.True(t, errors.As(err, &open))
+		assert.Greater(t, open.RetryAfter, time.Duration(0))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(2, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	t.Run("should stay closed below the threshold", func(t *testing.T) {
+		cb.Failure()
+		ok, _ := cb.Allow()
+		assert.True(t, ok)
+		cb.Success()
+		cb.Failure()
+		assert.Equal(t, CircuitClosed, cb.State())
+	})
+
+	t.Run("should reject calls while open", func(t *testing.T) {
+		cb.Failure()
+		assert.Equal(t, CircuitOpen, cb.State())
+
+		now = now.Add(20 * time.Second)
+		ok, retryAfter := cb.Allow()
+		assert.False(t, ok)
+		assert.Equal(t, 40*time.Second, retryAfter)
+	})
+
+	t.Run("should let a single probe through after the cooldown", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		ok, _ := cb.Allow()
+		assert.True(t, ok)
+		assert.Equal(t, CircuitHalfOpen, cb.State())
+
+		ok, _ = cb.Allow()
+		assert.False(t, ok)
+	})
+
+	t.Run("should reopen when the probe fails", func(t *testing.T) {
+		cb.Failure()
+		assert.Equal(t, CircuitOpen, cb.State())
+	})
+
+	t.Run("should let another probe through when one is abandoned", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		ok, _ := cb.Allow()
+		require.True(t, ok)
+		cb.Abandon()
+		assert.Equal(t, CircuitOpen, cb.State())
+
+		ok, _ = cb.Allow()
+		assert.True(t, ok)
+		assert.Equal(t, CircuitHalfOpen, cb.State())
+		cb.Failure()
+	})
+
+	t.Run("should close when a probe succeeds", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		ok, _ := cb.Allow()
+		require.True(t, ok)
+		cb.Success()
+		assert.Equal(t, CircuitClosed, cb.State())
+	})
+}
+
+func TestAuthMiddlewareAuthyUnhealthy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.Auth = NewAuthyVerifier(server.URL, newTestAuthyClient(server), &logger)
+
+	router := gin.New()
+	router.Use(app.AuthMiddleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("should answer 503 with Retry-After, not 401, once retries run out", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Access-Token", "some-token")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Equal(t, "5", resp.Header().Get("Retry-After"))
+		assert.Contains(t, resp.Body.String(), "Authentication service unavailable")
+	})
+
+	t.Run("should count the lookup as unavailable", func(t *testing.T) {
+		_, err := app.Auth.Verify(context.Background(), "some-token")
+		assert.ErrorIs(t, err, ErrAuthyUnavailable)
+		assert.Equal(t, "unavailable", authyOutcome(err))
+	})
+}
+
+func TestAuthMiddlewareAuthyUnreachable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	// closed straight away, so every attempt has its connection refused
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.Auth = NewAuthyVerifier(server.URL, newTestAuthyClient(server), &logger)
+
+	router := gin.New()
+	router.Use(app.AuthMiddleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("should answer 503 with Retry-After, not 401, while the breaker is closed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Access-Token", "some-token")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Equal(t, "5", resp.Header().Get("Retry-After"))
+		assert.Contains(t, resp.Body.String(), "Authentication service unavailable")
+	})
+}
+
+func TestAuthMiddlewareCircuitOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := newTestAuthyClient(server)
+	client.Breaker = NewCircuitBreaker(1, 30*time.Second)
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.Auth = NewAuthyVerifier(server.URL, client, &logger)
+
+	router := gin.New()
+	router.Use(app.AuthMiddleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Access-Token", "some-token")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should fail fast with 503 and Retry-After once the breaker opens", func(t *testing.T) {
+		// the first call reaches authy and trips the breaker
+		send()
+
+		resp := send()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+		assert.Contains(t, resp.Body.String(), "Authentication service unavailable")
+	})
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Configuration
+// settings come from the environment, then an optional .env file, then an
+// optional YAML or TOML file named by CONFIG_FILE - the first source to set
+// a key wins. Everything is read and checked once at startup into Config,
+// and every problem is reported together rather than one per restart. Each
+// subsystem is built from Config, and file settings are never copied into
+// the process environment
+
+// configSetting is a key the service reads. Secret values are redacted from
+// the startup summary
+type configSetting struct {
+	Key    string
+	Secret bool
+}
+
+// configSettings lists every key the service reads, so a config file can be
+// checked for typos and the startup summary knows what to show
+var configSettings = []configSetting{
+	{Key: "CONFIG_FILE"},
+	{Key: "PORT"},
+	{Key: "TRUSTED_PROXIES"},
+	{Key: "VERSION"},
+	{Key: "LOGLEVEL"},
+	{Key: "LOG_COMPONENT_LEVELS"},
+	{Key: "LOG_OUTPUT"},
+	{Key: "LOG_FORMAT"},
+	{Key: "LOGFILE"},
+	{Key: "LOG_MAX_SIZE_MB"},
+	{Key: "LOG_MAX_AGE_DAYS"},
+	{Key: "LOG_MAX_BACKUPS"},
+	{Key: "LOG_COMPRESS"},
+	{Key: "LOG_REDACTION_KEY", Secret: true},
+	{Key: "LOG_REDACT_FIELDS"},
+	{Key: "LOG_PSEUDONYMISE_FIELDS"},
+	{Key: "MONGO_URI", Secret: true},
+	{Key: "MONGO_DATABASE"},
+	{Key: "AUTH_MODE"},
+	{Key: "AUTHYURL"},
+	{Key: "AUTH_CACHE_SIZE"},
+	{Key: "AUTH_CACHE_TTL"},
+	{Key: "AUTH_CACHE_NEGATIVE_TTL"},
+	{Key: "AUTHY_ATTEMPT_TIMEOUT"},
+	{Key: "AUTHY_TOTAL_TIMEOUT"},
+	{Key: "AUTHY_MAX_RETRIES"},
+	{Key: "AUTHY_BACKOFF"},
+	{Key: "AUTHY_MAX_BACKOFF"},
+	{Key: "AUTHY_BREAKER_THRESHOLD"},
+	{Key: "AUTHY_BREAKER_COOLDOWN"},
+	{Key: "AUTHY_MAX_IDLE_CONNS"},
+	{Key: "JWT_SECRET", Secret: true},
+	{Key: "JWT_JWKS_FILE"},
+	{Key: "JWT_JWKS_URL"},
+	{Key: "JWT_JWKS_REFRESH"},
+	{Key: "JWT_PUBLIC_ID_CLAIM"},
+	{Key: "JWT_ROLES_CLAIM"},
+	{Key: "JWT_AUDIENCE"},
+	{Key: "JWT_ISSUER"},
+	{Key: "JWT_LEEWAY"},
+	{Key: "SERVICE_KEYS_FILE"},
+	{Key: "SERVICE_ONLY_LISTS"},
+	{Key: "ADMIN_ROLE"},
+	{Key: "AUDIT_RETENTION"},
+	{Key: "RATE_LIMIT_REQUESTS"},
+	{Key: "RATE_LIMIT_WINDOW"},
+	{Key: "RATE_LIMIT_ROUTES"},
+	{Key: "RATE_LIMIT_STORE"},
+	{Key: "RATE_LIMIT_ALGORITHM"},
+	{Key: "RATE_LIMIT_STORE_TIMEOUT"},
+	{Key: "CORS_ALLOWED_ORIGINS"},
+	{Key: "CORS_ALLOW_CREDENTIALS"},
+	{Key: "CORS_MAX_AGE"},
+	{Key: "CORS_ALLOWED_HEADERS"},
+	{Key: "CORS_EXPOSED_HEADERS"},
+	{Key: "WATCHER_COUNT_MIN"},
+	{Key: "WATCHER_COUNT_BUCKETS"},
+	{Key: "REQUEST_MAX_BODY_BYTES"},
+	{Key: "REQUEST_MAX_JSON_DEPTH"},
+	{Key: "DEFAULT_LIST_SIZE"},
+	{Key: "MAX_LIST_SIZE"},
+	{Key: "MAX_PAGE_SIZE"},
+	{Key: "OPENAPI_VALIDATION"},
+	{Key: "HEALTH_CHECK_TIMEOUT"},
+	{Key: "HEALTH_CACHE_TTL"},
+	{Key: "SHUTDOWN_DELAY"},
+	{Key: "SHUTDOWN_TIMEOUT"},
+	{Key: "METRICS_ENABLED"},
+	{Key: "METRICS_ADDR"},
+	{Key: "OTEL_TRACES_EXPORTER"},
+	{Key: "OTEL_TRACES_SAMPLER"},
+	{Key: "OTEL_TRACES_SAMPLER_ARG"},
+	{Key: "OTEL_SERVICE_NAME"},
+	{Key: "OTEL_EXPORTER_OTLP_ENDPOINT"},
+	{Key: "CURSOR_SECRET", Secret: true},
+	{Key: "ITEM_PROVIDER"},
+	{Key: "ITEMS_URL"},
+	{Key: "ITEMS_TIMEOUT"},
+	{Key: "ITEMS_BATCH_SIZE"},
+	{Key: "ITEMS_FILE"},
+}
+
+type Config struct {
+	Port           string
+	Version        string
+	MongoURI       string
+	MongoDatabase  string
+	TrustedProxies []string
+
+	AuthMode             string
+	AuthyURL             string
+	AuthCacheSize        int
+	AuthCacheTTL         time.Duration
+	AuthCacheNegativeTTL time.Duration
+	AdminRole            string
+
+	ServiceKeysFile  string
+	ServiceOnlyLists map[string]bool
+	CursorSecret     string
+
+	DefaultListSize int
+	MaxPageSize     int
+	MaxListSize     int
+	MaxBodyBytes    int64
+	MaxJSONDepth    int
+
+	MetricsEnabled    bool
+	MetricsAddr       string
+	OpenAPIValidation bool
+
+	Log           *LogConfig
+	Redaction     *RedactionConfig
+	Tracing       *TracingConfig
+	Authy         *AuthyClientConfig
+	JWT           *JWTConfig
+	RateLimit     *RateLimitConfig
+	Health        *HealthConfig
+	Items         *ItemsConfig
+	CORS          *CORSConfig
+	Audit         *AuditConfig
+	Shutdown      *ShutdownConfig
+	WatcherCounts *WatcherCountPolicy
+
+	// values holds the raw settings for the startup summary
+	values map[string]string
+
+	// envFile is the .env file LoadConfig read, so it can be read again
+	envFile string
+}
+
+// LoadConfig reads the settings from the environment, envFile and
+// CONFIG_FILE, then checks them. A missing envFile is fine. The config
+// returned on error still holds every setting that could be read
+func LoadConfig(envFile string) (*Config, error) {
+	settings, err := loadSettings(envFile)
+	config, cerr := NewConfig(settings)
+	config.envFile = envFile
+	return config, errors.Join(err, cerr)
+}
+
+// reloadSettings reads keys afresh from the same sources as LoadConfig, for
+// SIGHUP. A running process's environment doesn't change, so a key set
+// there still wins, but keys from .env or CONFIG_FILE are read from those
+// files again
+func (c *Config) reloadSettings(keys ...string) (map[string]string, error) {
+	settings, err := loadSettings(c.envFile)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		values[key] = settings.Get(key)
+	}
+	return values, nil
+}
+
+// NewConfig reads and checks every setting. Bad settings are left at their
+// defaults, or nil for the nested configs, and all of them are returned in
+// one error
+func NewConfig(s Settings) (*Config, error) {
+	r := &configReader{s: s}
+
+	config := &Config{
+		Port:           r.port("PORT", "8400"),
+		Version:        s.Get("VERSION"),
+		MongoURI:       r.required("MONGO_URI"),
+		MongoDatabase:  r.required("MONGO_DATABASE"),
+		TrustedProxies: r.addresses("TRUSTED_PROXIES"),
+
+		AuthMode:             r.oneOf("AUTH_MODE", "authy", "authy", "jwt"),
+		AuthCacheSize:        r.int("AUTH_CACHE_SIZE", 10000, 0),
+		AuthCacheTTL:         r.duration("AUTH_CACHE_TTL", 60*time.Second),
+		AuthCacheNegativeTTL: r.duration("AUTH_CACHE_NEGATIVE_TTL", 5*time.Second),
+		AdminRole:            s.GetOrDefault("ADMIN_ROLE", "admin"),
+
+		ServiceKeysFile: s.Get("SERVICE_KEYS_FILE"),
+		CursorSecret:    s.Get("CURSOR_SECRET"),
+
+		DefaultListSize: r.int("DEFAULT_LIST_SIZE", 25, 1),
+		MaxPageSize:     r.int("MAX_PAGE_SIZE", 50, 1),
+		MaxListSize:     r.int("MAX_LIST_SIZE", 50, 1),
+		MaxBodyBytes:    int64(r.int("REQUEST_MAX_BODY_BYTES", defaultMaxBodyBytes, 1)),
+		MaxJSONDepth:    r.int("REQUEST_MAX_JSON_DEPTH", defaultMaxJSONDepth, 1),
+
+		MetricsEnabled:    r.bool("METRICS_ENABLED", true),
+		MetricsAddr:       r.addr("METRICS_ADDR", ":9400"),
+		OpenAPIValidation: r.bool("OPENAPI_VALIDATION", false),
+	}
+	if _, port, _ := net.SplitHostPort(config.MetricsAddr); config.MetricsEnabled && port == config.Port {
+		r.fail(fmt.Errorf("METRICS_ADDR must not use the API's PORT"))
+	}
+	if config.DefaultListSize > config.MaxPageSize {
+		r.fail(fmt.Errorf("DEFAULT_LIST_SIZE must not be more than MAX_PAGE_SIZE"))
+	}
+
+	var err error
+	switch config.AuthMode {
+	case "authy":
+		config.AuthyURL = r.url("AUTHYURL")
+		config.Authy, err = NewAuthyClientConfig(s)
+		r.fail(err)
+	case "jwt":
+		config.JWT, err = NewJWTConfig(s)
+		r.fail(err)
+	}
+	config.ServiceOnlyLists, err = parseServiceOnlyLists(s.GetOrDefault("SERVICE_ONLY_LISTS", "bids,purchased"))
+	r.fail(err)
+
+	config.Log, err = NewLogConfig(s)
+	r.fail(err)
+	config.Redaction = NewRedactionConfig(s)
+	config.Tracing, err = NewTracingConfig(s)
+	r.fail(err)
+	config.RateLimit, err = NewRateLimitConfig(s)
+	r.fail(err)
+	config.Health, err = NewHealthConfig(s)
+	r.fail(err)
+	config.Items, err = NewItemsConfig(s)
+	r.fail(err)
+	config.CORS, err = NewCORSConfig(s)
+	r.fail(err)
+	config.Audit, err = NewAuditConfig(s)
+	r.fail(err)
+	config.Shutdown, err = NewShutdownConfig(s)
+	r.fail(err)
+	config.WatcherCounts, err = NewWatcherCountPolicy(s)
+	r.fail(err)
+
+	config.values = make(map[string]string)
+	for _, setting := range configSettings {
+		if value := s.Get(setting.Key); value != "" {
+			config.values[setting.Key] = value
+		}
+	}
+
+	return config, r.err()
+}
+
+// Summary returns the settings that are set, with secrets redacted, for
+// logging at startup
+func (c *Config) Summary() map[string]interface{} {
+	summary := make(map[string]interface{}, len(c.values))
+	for _, setting := range configSettings {
+		value, ok := c.values[setting.Key]
+		if !ok {
+			continue
+		}
+		if setting.Secret {
+			value = redactSetting(setting.Key, value)
+		}
+		summary[setting.Key] = value
+	}
+	return summary
+}
+
+// redactSetting hides a secret. A MongoDB URI only loses its password, so
+// the summary still says where the service connects
+func redactSetting(key, value string) string {
+	if key == "MONGO_URI" {
+		if u, err := url.Parse(value); err == nil && u.Host != "" {
+			return u.Redacted()
+		}
+	}
+	return redactedValue
+}
+
+// config returns the app's settings. Every app is built with one, so a
+// missing config is a programming error rather than something to paper over
+func (a *App) config() *Config {
+	if a.Config == nil {
+		panic("app has no Config - build one with LoadConfig or NewConfig")
+	}
+	return a.Config
+}
+
+//-----------------------------------------------------------------------------
+// Settings
+// the raw values from every source, merged by key. Constructors read these
+// rather than the environment, so a config can be built from anything
+
+type Settings map[string]string
+
+// EnvSettings returns the process environment as settings
+func EnvSettings() Settings {
+	s := Settings{}
+	for _, kv := range os.Environ() {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			s[key] = value
+		}
+	}
+	return s
+}
+
+// loadSettings merges the environment, envFile and the file named by
+// CONFIG_FILE, the first source to set a key winning. A missing envFile is
+// fine and an empty envFile isn't read. The settings returned on error hold
+// everything that could be read
+func loadSettings(envFile string) (Settings, error) {
+	var errs []error
+	s := EnvSettings()
+	if envFile != "" {
+		values, err := godotenv.Read(envFile)
+		switch {
+		case err == nil:
+			s.fill(values)
+		case !errors.Is(err, fs.ErrNotExist):
+			errs = append(errs, fmt.Errorf("reading %s: %w", envFile, err))
+		}
+	}
+	if path := s.Get("CONFIG_FILE"); path != "" {
+		values, err := readConfigFile(path)
+		s.fill(values)
+		errs = append(errs, err)
+	}
+	return s, errors.Join(errs...)
+}
+
+// fill sets the keys that aren't already set. As everywhere else, an empty
+// value counts as not set
+func (s Settings) fill(values map[string]string) {
+	for key, value := range values {
+		if s[key] == "" {
+			s[key] = value
+		}
+	}
+}
+
+// Get returns a setting, or "" if it isn't set
+func (s Settings) Get(key string) string {
+	return s[key]
+}
+
+// Lookup reports whether a setting is present at all, for the few settings
+// where empty means something
+func (s Settings) Lookup(key string) (string, bool) {
+	value, ok := s[key]
+	return value, ok
+}
+
+// GetOrDefault returns a setting, or def if it's empty
+func (s Settings) GetOrDefault(key, def string) string {
+	if value := s[key]; value != "" {
+		return value
+	}
+	return def
+}
+
+//-----------------------------------------------------------------------------
+// Config files
+// a config file sets the same keys as the environment. Keys can be written
+// as is or nested, so log: {output: file} sets LOG_OUTPUT, and lists are
+// joined with commas
+
+// readConfigFile reads the settings in a .yaml, .yml or .toml file. Unknown
+// keys are an error, but the known ones are still returned
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONFIG_FILE: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("CONFIG_FILE must be .yaml, .yml or .toml, not [%s]", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flattenConfig("", raw, values)
+
+	known := make(map[string]bool, len(configSettings))
+	for _, setting := range configSettings {
+		known[setting.Key] = true
+	}
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+			delete(values, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return values, fmt.Errorf("unknown settings in %s: %s", path, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// flattenConfig turns nested keys into environment variable names
+func flattenConfig(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flattenConfig(name, child, out)
+		}
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, configString(item))
+		}
+		out[prefix] = strings.Join(parts, ",")
+	default:
+		out[prefix] = configString(v)
+	}
+}
+
+func configString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Reading settings
+
+// configReader reads settings, collecting what's wrong with them instead of
+// stopping at the first
+type configReader struct {
+	s    Settings
+	errs []error
+}
+
+func (r *configReader) fail(err error) {
+	if err != nil {
+		r.errs = append(r.errs, err)
+	}
+}
+
+// err returns everything that was wrong, or nil
+func (r *configReader) err() error {
+	return errors.Join(r.errs...)
+}
+
+func (r *configReader) required(key string) string {
+	value := r.s.Get(key)
+	if value == "" {
+		r.fail(fmt.Errorf("%s must be set", key))
+	}
+	return value
+}
+
+func (r *configReader) url(key string) string {
+	value := r.required(key)
+	if value == "" {
+		return value
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		r.fail(fmt.Errorf("%s must be an absolute URL", key))
+	}
+	return value
+}
+
+// addresses reads a comma separated list of IP addresses and CIDR ranges
+func (r *configReader) addresses(key string) []string {
+	values := SplitCommaList(r.s.Get(key))
+	for _, v := range values {
+		if net.ParseIP(v) == nil {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				r.fail(fmt.Errorf("%s must be IP addresses or CIDR ranges, not [%s]", key, v))
+				return nil
+			}
+		}
+	}
+	return values
+}
+
+// addr reads a listen address such as :9400 or 127.0.0.1:9400
+func (r *configReader) addr(key, def string) string {
+	value := r.s.GetOrDefault(key, def)
+	_, port, err := net.SplitHostPort(value)
+	if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 1 || n > 65535 {
+		r.fail(fmt.Errorf("%s must be a listen address such as :9400", key))
+		return def
+	}
+	return value
+}
+
+func (r *configReader) port(key, def string) string {
+	value := r.s.GetOrDefault(key, def)
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		r.fail(fmt.Errorf("%s must be a port number", key))
+		return def
+	}
+	return value
+}
+
+func (r *configReader) oneOf(key, def string, allowed ...string) string {
+	value := strings.ToLower(r.s.GetOrDefault(key, def))
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	r.fail(fmt.Errorf("%s must be one of %s", key, strings.Join(allowed, ", ")))
+	return def
+}
+
+func (r *configReader) int(key string, def, min int) int {
+	raw := r.s.Get(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < min {
+		r.fail(fmt.Errorf("%s must be a whole number of at least %d", key, min))
+		return def
+	}
+	return n
+}
+
+func (r *configReader) bool(key string, def bool) bool {
+	raw := r.s.Get(key)
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.fail(fmt.Errorf("%s must be true or false", key))
+		return def
+	}
+	return b
+}
+
+func (r *configReader) duration(key string, def time.Duration) time.Duration {
+	raw := r.s.Get(key)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		r.fail(fmt.Errorf("%s must be a duration such as 30s", key))
+		return def
+	}
+	return d
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// CORS
+// origins come from CORS_ALLOWED_ORIGINS, comma separated. An entry is either
+// an exact origin, a wildcard subdomain such as https://*.poptape.club, or *
+// for any origin. Preflights are answered with the methods actually
+// registered for the requested path, and refused when the origin, method or
+// headers aren't allowed
+
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+const (
+	defaultCORSAllowedHeaders = "Content-Type, Authorization, X-Access-Token, X-Request-ID"
+	defaultCORSExposedHeaders = "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Request-ID"
+)
+
+// NewCORSConfig reads CORS_ALLOWED_ORIGINS, CORS_ALLOWED_HEADERS,
+// CORS_EXPOSED_HEADERS, CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE
+func NewCORSConfig(s Settings) (*CORSConfig, error) {
+	r := &configReader{s: s}
+	cfg := &CORSConfig{
+		AllowedOrigins:   SplitCommaList(s.GetOrDefault("CORS_ALLOWED_ORIGINS", "*")),
+		AllowedHeaders:   SplitCommaList(s.GetOrDefault("CORS_ALLOWED_HEADERS", defaultCORSAllowedHeaders)),
+		ExposedHeaders:   SplitCommaList(s.GetOrDefault("CORS_EXPOSED_HEADERS", defaultCORSExposedHeaders)),
+		AllowCredentials: r.bool("CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           r.duration("CORS_MAX_AGE", 10*time.Minute),
+	}
+	r.fail(cfg.Validate())
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks origin entries are well formed, and that credentials are
+// never offered to any origin at all
+func (cfg *CORSConfig) Validate() error {
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			if cfg.AllowCredentials {
+				return errors.New("CORS_ALLOW_CREDENTIALS can't be used with a * origin")
+			}
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+			return fmt.Errorf("invalid CORS origin [%s]", origin)
+		}
+		if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			return fmt.Errorf("invalid CORS origin [%s], wildcards must look like https://*.example.com", origin)
+		}
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether a request Origin is on the allowlist
+func (cfg *CORSConfig) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range cfg.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		prefix, suffix, ok := strings.Cut(allowed, "*")
+		if !ok || len(origin) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		// the wildcard covers one or more subdomain labels and nothing else
+		sub := origin[len(prefix) : len(origin)-len(suffix)]
+		if validSubdomain(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func validSubdomain(sub string) bool {
+	for _, label := range strings.Split(sub, ".") {
+		if label == "" {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (cfg *CORSConfig) anyOrigin() bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header named in a preflight's
+// Access-Control-Request-Headers is allowed
+func (cfg *CORSConfig) allowsHeaders(requested string) bool {
+	for _, header := range SplitCommaList(requested) {
+		found := false
+		for _, allowed := range cfg.AllowedHeaders {
+			if strings.EqualFold(header, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// Route methods
+// which methods are registered for a path, from gin's route table
+
+type routeMethods struct {
+	patterns []string
+	methods  map[string][]string
+}
+
+func newRouteMethods(routes gin.RoutesInfo) *routeMethods {
+	rm := &routeMethods{methods: map[string][]string{}}
+	for _, route := range routes {
+		if _, ok := rm.methods[route.Path]; !ok {
+			rm.patterns = append(rm.patterns, route.Path)
+		}
+		rm.methods[route.Path] = append(rm.methods[route.Path], route.Method)
+	}
+	return rm
+}
+
+// For returns the methods registered for a request path, sorted
+func (rm *routeMethods) For(path string) []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, pattern := range rm.patterns {
+		if !matchRoute(pattern, path) {
+			continue
+		}
+		for _, method := range rm.methods[pattern] {
+			if !seen[method] {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// matchRoute matches a path against a gin pattern with :param and *catchall
+// segments
+func matchRoute(pattern, path string) bool {
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(got) {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != got[i] {
+			return false
+		}
+		if strings.HasPrefix(segment, ":") && got[i] == "" {
+			return false
+		}
+	}
+	return len(got) == len(want)
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// Middleware
+
+// initialiseCORS reads the CORS settings, refusing to start with bad ones
+func (a *App) initialiseCORS() {
+	cfg := a.config().CORS
+	if cfg == nil {
+		a.Log.Fatal().Msg("Invalid CORS settings")
+	}
+	a.cors = cfg
+	a.Log.Info().Strs("origins", cfg.AllowedOrigins).Bool("credentials", cfg.AllowCredentials).Msg("CORS configured")
+}
+
+// corsConfig returns the app's CORS settings, taking them from the config
+// on first use
+func (a *App) corsConfig() *CORSConfig {
+	a.corsOnce.Do(func() {
+		if a.cors != nil {
+			return
+		}
+		cfg := a.config().CORS
+		if cfg == nil {
+			// no cross origin access is safer than guessing
+			a.Log.Error().Msg("Invalid CORS settings, cross origin requests disabled")
+			cfg = &CORSConfig{}
+		}
+		a.cors = cfg
+	})
+	return a.cors
+}
+
+// CORSMiddleware answers preflights and adds CORS headers to requests from
+// allowed origins. Allowed methods come from the routes registered on
+// a.Router, read on the first request once every route is in place
+func (a *App) CORSMiddleware() gin.HandlerFunc {
+	cfg := a.corsConfig()
+	allowHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge / time.Second))
+
+	var routesOnce sync.Once
+	var routes *routeMethods
+	methodsFor := func(path string) []string {
+		routesOnce.Do(func() {
+			var info gin.RoutesInfo
+			if a.Router != nil {
+				info = a.Router.Routes()
+			}
+			routes = newRouteMethods(info)
+		})
+		return routes.For(path)
+	}
+
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Origin")
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if c.Request.Method == http.MethodOptions && !preflight {
+			// a plain OPTIONS just lists what the path supports
+			methods := methodsFor(c.Request.URL.Path)
+			if len(methods) == 0 {
+				c.Next()
+				return
+			}
+			c.Header("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if origin == "" {
+			c.Next()
+			return
+		}
+		if !cfg.AllowsOrigin(origin) {
+			if preflight {
+				a.requestLog(c).Warn().Str("origin", origin).Msg("CORS preflight from disallowed origin")
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Origin not allowed"})
+				return
+			}
+			// without CORS headers the browser won't hand the response over
+			c.Next()
+			return
+		}
+
+		if cfg.anyOrigin() && !cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				c.Header("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		methods := methodsFor(c.Request.URL.Path)
+		if len(methods) == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Resource not found"})
+			return
+		}
+		method := strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))
+		if !containsMethod(methods, method) {
+			c.Header("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("Method %s is not allowed for this resource", method)})
+			return
+		}
+		if !cfg.allowsHeaders(c.GetHeader("Access-Control-Request-Headers")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Request headers not allowed"})
+			return
+		}
+
+		c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		c.Header("Access-Control-Allow-Headers", allowHeaders)
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSConfig(t *testing.T) {
+	t.Run("should read settings from the environment", func(t *testing.T) {
+		t.Setenv("CORS_ALLOWED_ORIGINS", "https://poptape.club, https://*.poptape.club")
+		t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+		t.Setenv("CORS_MAX_AGE", "1h")
+
+		cfg, err := NewCORSConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://poptape.club", "https://*.poptape.club"}, cfg.AllowedOrigins)
+		assert.True(t, cfg.AllowCredentials)
+		assert.Equal(t, time.Hour, cfg.MaxAge)
+	})
+
+	t.Run("should refuse credentials for any origin", func(t *testing.T) {
+		t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+		t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+		_, err := NewCORSConfig(EnvSettings())
+		assert.Error(t, err)
+	})
+
+	t.Run("should refuse malformed origins", func(t *testing.T) {
+		for _, origin := range []string{"poptape.club", "https://", "https://poptape.club/path", "https://api.*.poptape.club", "https://*.*.poptape.club"} {
+			cfg := &CORSConfig{AllowedOrigins: []string{origin}}
+			assert.Error(t, cfg.Validate(), origin)
+		}
+	})
+
+	t.Run("should match exact and wildcard origins", func(t *testing.T) {
+		cfg := &CORSConfig{AllowedOrigins: []string{"https://poptape.club", "https://*.poptape.club"}}
+
+		assert.True(t, cfg.AllowsOrigin("https://poptape.club"))
+		assert.True(t, cfg.AllowsOrigin("https://www.poptape.club"))
+		assert.True(t, cfg.AllowsOrigin("https://a.b.poptape.club"))
+		assert.True(t, cfg.AllowsOrigin("HTTPS://WWW.POPTAPE.CLUB"))
+
+		assert.False(t, cfg.AllowsOrigin("http://www.poptape.club"))
+		assert.False(t, cfg.AllowsOrigin("https://evilpoptape.club"))
+		assert.False(t, cfg.AllowsOrigin("https://poptape.club.evil.com"))
+		assert.False(t, cfg.AllowsOrigin("https://www.poptape.club:8443"))
+		assert.False(t, cfg.AllowsOrigin("https://.poptape.club"))
+	})
+}
+
+func TestMatchRoute(t *testing.T) {
+	assert.True(t, matchRoute("/list/watchlist", "/list/watchlist"))
+	assert.True(t, matchRoute("/list/watchlist/:itemId", "/list/watchlist/abc"))
+	assert.True(t, matchRoute("/static/*filepath", "/static/css/site.css"))
+	assert.False(t, matchRoute("/list/watchlist/:itemId", "/list/watchlist"))
+	assert.False(t, matchRoute("/list/watchlist", "/list/watchlist/abc"))
+	assert.False(t, matchRoute("/list/watchlist", "/list/favourites"))
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	newRouter := func(cfg *CORSConfig) *gin.Engine {
+		router := gin.New()
+		app := &App{Log: &logger, Config: testConfig(t), Router: router, cors: cfg}
+		router.Use(app.CORSMiddleware())
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		router.GET("/list/watchlist", ok)
+		router.POST("/list/watchlist", ok)
+		router.DELETE("/list/watchlist/:itemId", ok)
+		return router
+	}
+
+	preflight := func(router *gin.Engine, path, origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	cfg := &CORSConfig{
+		AllowedOrigins:   []string{"https://*.poptape.club"},
+		AllowedHeaders:   []string{"Content-Type", "X-Access-Token"},
+		ExposedHeaders:   []string{"Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	t.Run("should answer allowed preflights with the route's methods", func(t *testing.T) {
+		resp := preflight(newRouter(cfg), "/list/watchlist", "https://www.poptape.club", "POST", "content-type, x-access-token")
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "https://www.poptape.club", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "GET, POST", resp.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type, X-Access-Token", resp.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", resp.Header().Get("Access-Control-Max-Age"))
+		assert.Contains(t, resp.Header().Values("Vary"), "Origin")
+
+		resp = preflight(newRouter(cfg), "/list/watchlist/123", "https://www.poptape.club", "DELETE", "")
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "DELETE", resp.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("should reject preflights from other origins", func(t *testing.T) {
+		resp := preflight(newRouter(cfg), "/list/watchlist", "https://evil.com", "GET", "")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("should reject preflights for methods the route doesn't have", func(t *testing.T) {
+		resp := preflight(newRouter(cfg), "/list/watchlist", "https://www.poptape.club", "PATCH", "")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Equal(t, "GET, POST, OPTIONS", resp.Header().Get("Allow"))
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("should reject preflights asking for other headers", func(t *testing.T) {
+		resp := preflight(newRouter(cfg), "/list/watchlist", "https://www.poptape.club", "GET", "X-Service-Key")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("should answer preflights for unknown paths with 404", func(t *testing.T) {
+		resp := preflight(newRouter(cfg), "/list/shopping", "https://www.poptape.club", "GET", "")
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("should expose headers on actual requests from allowed origins", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/list/watchlist", nil)
+		req.Header.Set("Origin", "https://www.poptape.club")
+		resp := httptest.NewRecorder()
+		newRouter(cfg).ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "https://www.poptape.club", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Retry-After", resp.Header().Get("Access-Control-Expose-Headers"))
+	})
+
+	t.Run("should leave out CORS headers for other origins", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/list/watchlist", nil)
+		req.Header.Set("Origin", "https://evil.com")
+		resp := httptest.NewRecorder()
+		newRouter(cfg).ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+	})
+
+	t.Run("should list methods for plain OPTIONS requests", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "/list/watchlist", nil)
+		resp := httptest.NewRecorder()
+		newRouter(cfg).ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "GET, POST, OPTIONS", resp.Header().Get("Allow"))
+	})
+}
@@ -2,36 +2,83 @@ package main
 
 import (
 	"context"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"os"
 	"time"
 )
 
 func (a *App) initialiseDatabase() {
 
-	a.Log.Info().Msg("Initialising database connection")
+	log := a.componentLog(componentDB)
+	log.Info().Msg("Initialising database connection")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	mongoURI := os.Getenv("MONGO_URI")
-	clientOptions := options.Client().ApplyURI(mongoURI)
+	monitor := combineMonitors(a.appMetrics().MongoMonitor(), TracingMonitor(), CommandLogMonitor(log))
+	clientOptions := options.Client().ApplyURI(a.config().MongoURI).SetMonitor(monitor)
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		a.Log.Fatal().Err(err).Msg("Failed to connect to MongoDB")
+		log.Fatal().Err(err).Msg("Failed to connect to MongoDB")
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		a.Log.Fatal().Err(err).Msg("Failed to ping MongoDB")
+		log.Fatal().Err(err).Msg("Failed to ping MongoDB")
 	}
 
-	a.Log.Info().Msg("Successfully connected to MongoDB")
+	log.Info().Msg("Successfully connected to MongoDB")
 
 	a.Client = client
-	a.DB = client.Database(os.Getenv("MONGO_DATABASE"))
+	a.DB = client.Database(a.config().MongoDatabase)
+}
+
+// combineMonitors lets more than one command monitor see each command, as
+// the driver only takes one
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				m.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				m.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				m.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+// CommandLogMonitor logs each MongoDB command at debug level, so turning
+// the db component down to debug shows the queries a request makes
+func CommandLogMonitor(log *zerolog.Logger) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(context.Context, *event.CommandStartedEvent) {},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			tagRequest(ctx, log).Debug().
+				Str("command", e.CommandName).
+				Str("database", e.DatabaseName).
+				Dur("duration", e.Duration).
+				Msg("MongoDB command")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			tagRequest(ctx, log).Debug().
+				Str("command", e.CommandName).
+				Str("database", e.DatabaseName).
+				Dur("duration", e.Duration).
+				Str("failure", e.Failure).
+				Msg("MongoDB command failed")
+		},
+	}
 }
 
 func (a *App) GetCollection(listType string) *mongo.Collection {
@@ -43,7 +90,7 @@ func (a *App) Cleanup() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := a.Client.Disconnect(ctx); err != nil {
-			a.Log.Error().Err(err).Msg("Error disconnecting from MongoDB")
+			a.componentLog(componentDB).Error().Err(err).Msg("Error disconnecting from MongoDB")
 		}
 	}
 }
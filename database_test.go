@@ -43,14 +43,15 @@ func (suite *DatabaseTestSuite) SetupSuite() {
 
 	// Create app instance
 	suite.app = &App{
-		Log: &logger,
+		Log:    &logger,
+		Config: testConfig(suite.T()),
 	}
 
 	// Initialize database connection
 	suite.app.initialiseDatabase()
 
 	// Track collections for cleanup
-	suite.cleanup = []string{"watchlist", "favourites", "viewed", "bids", "purchased", "test_collection"}
+	suite.cleanup = []string{"watchlist", "favourites", "viewed", "bids", "purchased", "test_collection", rateLimitCollection}
 }
 
 // TearDownSuite cleans up after all tests
@@ -298,12 +299,12 @@ func (suite *DatabaseTestSuite) TestComplexListOperations() {
 
 		// Add items one by one using the app's method
 		for _, item := range items {
-			err := suite.app.addToList(suite.testUserID, "watchlist", item)
+			_, err := suite.app.addToList(context.Background(), suite.testUserID, "watchlist", item)
 			require.NoError(suite.T(), err)
 		}
 
 		// Verify all items are in the list (in reverse order due to prepending)
-		document, err := suite.app.getListDocument(suite.testUserID, "watchlist")
+		document, err := suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		require.NoError(suite.T(), err)
 
 		expected := []string{items[2], items[1], items[0]}
@@ -328,11 +329,11 @@ func (suite *DatabaseTestSuite) TestComplexListOperations() {
 		require.NoError(suite.T(), err)
 
 		// Remove middle item
-		err = suite.app.removeFromList(suite.testUserID, "watchlist", items[1])
+		_, err = suite.app.removeFromList(context.Background(), suite.testUserID, "watchlist", items[1])
 		require.NoError(suite.T(), err)
 
 		// Verify item was removed
-		document, err := suite.app.getListDocument(suite.testUserID, "watchlist")
+		document, err := suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		require.NoError(suite.T(), err)
 
 		expected := []string{items[0], items[2]}
@@ -357,11 +358,11 @@ func (suite *DatabaseTestSuite) TestComplexListOperations() {
 		require.NoError(suite.T(), err)
 
 		// Remove the only item
-		err = suite.app.removeFromList(suite.testUserID, "watchlist", items[0])
+		_, err = suite.app.removeFromList(context.Background(), suite.testUserID, "watchlist", items[0])
 		require.NoError(suite.T(), err)
 
 		// Verify document was deleted
-		_, err = suite.app.getListDocument(suite.testUserID, "watchlist")
+		_, err = suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		assert.Equal(suite.T(), mongo.ErrNoDocuments, err)
 	})
 
@@ -383,11 +384,12 @@ func (suite *DatabaseTestSuite) TestComplexListOperations() {
 		require.NoError(suite.T(), err)
 
 		// Remove all items (empty string means remove all)
-		err = suite.app.removeFromList(suite.testUserID, "watchlist", "")
+		change, err := suite.app.removeFromList(context.Background(), suite.testUserID, "watchlist", "")
 		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), initialDocument.ItemIds, change.Removed)
 
 		// Verify document was deleted
-		_, err = suite.app.getListDocument(suite.testUserID, "watchlist")
+		_, err = suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		assert.Equal(suite.T(), mongo.ErrNoDocuments, err)
 	})
 }
@@ -417,11 +419,12 @@ func (suite *DatabaseTestSuite) TestEdgeCases() {
 
 		// Add one more item - should maintain limit of 50
 		newItem := uuid.New().String()
-		err = suite.app.addToList(suite.testUserID, "watchlist", newItem)
+		change, err := suite.app.addToList(context.Background(), suite.testUserID, "watchlist", newItem)
 		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), &ListChange{Added: []string{newItem}, Removed: []string{items[49]}, Size: 50}, change)
 
 		// Verify list still has 50 items with new item at front
-		document, err := suite.app.getListDocument(suite.testUserID, "watchlist")
+		document, err := suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		require.NoError(suite.T(), err)
 
 		assert.Len(suite.T(), document.ItemIds, 50)
@@ -433,14 +436,14 @@ func (suite *DatabaseTestSuite) TestEdgeCases() {
 		item := uuid.New().String()
 
 		// Add same item twice
-		err := suite.app.addToList(suite.testUserID, "watchlist", item)
+		_, err := suite.app.addToList(context.Background(), suite.testUserID, "watchlist", item)
 		require.NoError(suite.T(), err)
 
-		err = suite.app.addToList(suite.testUserID, "watchlist", item)
+		_, err = suite.app.addToList(context.Background(), suite.testUserID, "watchlist", item)
 		require.NoError(suite.T(), err)
 
 		// Verify only one instance exists
-		document, err := suite.app.getListDocument(suite.testUserID, "watchlist")
+		document, err := suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		require.NoError(suite.T(), err)
 
 		assert.Len(suite.T(), document.ItemIds, 1)
@@ -451,11 +454,11 @@ func (suite *DatabaseTestSuite) TestEdgeCases() {
 		nonExistentUser := uuid.New().String()
 
 		// Try to get list for non-existent user
-		_, err := suite.app.getListDocument(nonExistentUser, "watchlist")
+		_, err := suite.app.getListDocument(context.Background(), nonExistentUser, "watchlist")
 		assert.Equal(suite.T(), mongo.ErrNoDocuments, err)
 
 		// Try to remove from non-existent user's list
-		err = suite.app.removeFromList(nonExistentUser, "watchlist", uuid.New().String())
+		_, err = suite.app.removeFromList(context.Background(), nonExistentUser, "watchlist", uuid.New().String())
 		assert.NoError(suite.T(), err) // Should not error, just be a no-op
 	})
 }
@@ -539,7 +542,7 @@ func (suite *DatabaseTestSuite) TestConcurrentOperations() {
 
 				for j := 0; j < itemsPerGoroutine; j++ {
 					item := uuid.New().String()
-					err := suite.app.addToList(suite.testUserID, "watchlist", item)
+					_, err := suite.app.addToList(context.Background(), suite.testUserID, "watchlist", item)
 					assert.NoError(suite.T(), err, "Goroutine %d, item %d failed", goroutineID, j)
 				}
 			}(i)
@@ -551,7 +554,7 @@ func (suite *DatabaseTestSuite) TestConcurrentOperations() {
 		}
 
 		// Verify the final state
-		document, err := suite.app.getListDocument(suite.testUserID, "watchlist")
+		document, err := suite.app.getListDocument(context.Background(), suite.testUserID, "watchlist")
 		require.NoError(suite.T(), err)
 
 		// Should have at most 50 items (due to limit)
@@ -566,6 +569,63 @@ func (suite *DatabaseTestSuite) TestConcurrentOperations() {
 	})
 }
 
+// Test rate limit counters shared between replicas
+func (suite *DatabaseTestSuite) TestMongoRateLimitStore() {
+	ctx := context.Background()
+	rule := RateLimitRule{Requests: 3, Window: time.Minute}
+	now := time.Now().Truncate(time.Minute).Add(30 * time.Second)
+
+	newStore := func(sliding bool, clock *time.Time) *MongoRateLimitStore {
+		store := NewMongoRateLimitStore(suite.app.GetCollection(rateLimitCollection), sliding, time.Second)
+		store.now = func() time.Time { return *clock }
+		return store
+	}
+
+	suite.Run("should share a fixed window between replicas", func() {
+		clock := now
+		first, second := newStore(false, &clock), newStore(false, &clock)
+		require.NoError(suite.T(), first.EnsureIndexes(ctx))
+
+		for i, store := range []*MongoRateLimitStore{first, second, first} {
+			d, err := store.Take(ctx, "fixed", rule)
+			require.NoError(suite.T(), err)
+			assert.True(suite.T(), d.Allowed)
+			assert.Equal(suite.T(), 2-i, d.Remaining)
+		}
+
+		d, err := second.Take(ctx, "fixed", rule)
+		require.NoError(suite.T(), err)
+		assert.False(suite.T(), d.Allowed)
+		assert.Equal(suite.T(), 30*time.Second, d.RetryAfter)
+
+		// a new window starts afresh
+		clock = clock.Add(time.Minute)
+		d, err = first.Take(ctx, "fixed", rule)
+		require.NoError(suite.T(), err)
+		assert.True(suite.T(), d.Allowed)
+	})
+
+	suite.Run("should weight the previous window when sliding", func() {
+		clock := now
+		store := newStore(true, &clock)
+		for i := 0; i < 3; i++ {
+			_, err := store.Take(ctx, "sliding", rule)
+			require.NoError(suite.T(), err)
+		}
+
+		// half way into the next window half of the old count still applies
+		clock = clock.Add(time.Minute)
+		d, err := store.Take(ctx, "sliding", rule)
+		require.NoError(suite.T(), err)
+		assert.True(suite.T(), d.Allowed)
+		assert.Equal(suite.T(), 0, d.Remaining)
+
+		d, err = store.Take(ctx, "sliding", rule)
+		require.NoError(suite.T(), err)
+		assert.False(suite.T(), d.Allowed)
+	})
+}
+
 // Run the test suite
 func TestDatabaseTestSuite(t *testing.T) {
 	suite.Run(t, new(DatabaseTestSuite))
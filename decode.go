@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// Request decoding
+// every JSON body goes through DecodeJSON. Bodies over REQUEST_MAX_BODY_BYTES
+// get a 413. Bodies nested deeper than REQUEST_MAX_JSON_DEPTH, with fields the
+// target doesn't have, or with anything after the JSON value get a 400. Once
+// decoded, targets implementing RequestValidator are checked field by field
+
+const (
+	defaultMaxBodyBytes  = 64 << 10
+	defaultMaxJSONDepth  = 10
+	malformedJSONMessage = "Check ya inputs mate. Yer not valid, Jason"
+)
+
+// FieldError is one problem with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// RequestValidator is implemented by request bodies with rules beyond their
+// JSON types
+type RequestValidator interface {
+	Validate() []FieldError
+}
+
+// DecodeError is why a request body was refused
+type DecodeError struct {
+	Status  int
+	Message string
+	Detail  string
+	Fields  []FieldError
+}
+
+func (e *DecodeError) Error() string {
+	if e.Detail != "" {
+		return e.Message + ": " + e.Detail
+	}
+	return e.Message
+}
+
+// Body is the JSON response for the error, in the same shape as
+// NewValidationError with every failing field listed
+func (e *DecodeError) Body() gin.H {
+	body := gin.H{"message": e.Message}
+	if e.Detail != "" {
+		body["error"] = e.Detail
+	}
+	if len(e.Fields) > 0 {
+		body["error"] = fmt.Sprintf("%s: %s", e.Fields[0].Field, e.Fields[0].Message)
+		body["fields"] = e.Fields
+	}
+	return body
+}
+
+func fieldErrors(fields ...FieldError) *DecodeError {
+	return &DecodeError{Status: http.StatusBadRequest, Message: "Validation error", Fields: fields}
+}
+
+// DecodeStrict decodes exactly one JSON value from r into dst
+func DecodeStrict(r io.Reader, dst interface{}, maxBytes int64, maxDepth int) *DecodeError {
+	tooLarge := &DecodeError{Status: http.StatusRequestEntityTooLarge, Message: "Request body too large",
+		Detail: fmt.Sprintf("limit is %d bytes", maxBytes)}
+	body, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return tooLarge
+		}
+		return &DecodeError{Status: http.StatusBadRequest, Message: "Could not read request body"}
+	}
+	if int64(len(body)) > maxBytes {
+		return tooLarge
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage, Detail: "request body is empty"}
+	}
+
+	// walk the tokens first so deep nesting is refused before it's decoded
+	if err := checkJSONDepth(body, maxDepth); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeFailure(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage,
+			Detail: "unexpected data after the JSON body"}
+	}
+
+	if v, ok := dst.(RequestValidator); ok {
+		if fields := v.Validate(); len(fields) > 0 {
+			return fieldErrors(fields...)
+		}
+	}
+	return nil
+}
+
+// checkJSONDepth refuses bodies nested deeper than maxDepth objects and
+// arrays, and bodies that aren't valid JSON
+func checkJSONDepth(body []byte, maxDepth int) *DecodeError {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return decodeFailure(err)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage,
+					Detail: fmt.Sprintf("nested deeper than %d levels", maxDepth)}
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// decodeFailure turns encoding/json errors into field errors where they
+// name a field
+func decodeFailure(err error) *DecodeError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage,
+			Detail: fmt.Sprintf("%s at offset %d", syntaxErr.Error(), syntaxErr.Offset)}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage, Detail: "body ends part way through"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage,
+				Detail: "body must be " + jsonKind(typeErr.Type.Kind().String())}
+		}
+		return fieldErrors(FieldError{Field: typeErr.Field, Message: "must be " + jsonKind(typeErr.Type.Kind().String())})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return fieldErrors(FieldError{Field: field, Message: "is not a known field"})
+	}
+	return &DecodeError{Status: http.StatusBadRequest, Message: malformedJSONMessage, Detail: err.Error()}
+}
+
+// jsonKind names a Go kind the way a client sending JSON would
+func jsonKind(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "a boolean"
+	case "slice", "array":
+		return "an array"
+	case "struct", "map":
+		return "an object"
+	}
+	return "a number"
+}
+
+// DecodeJSON strictly decodes the request body into dst, answering the
+// request itself and returning false when the body is refused
+func (a *App) DecodeJSON(c *gin.Context, dst interface{}) bool {
+	maxBytes := a.config().MaxBodyBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+	if err := DecodeStrict(c.Request.Body, dst, maxBytes, a.config().MaxJSONDepth); err != nil {
+		a.requestLog(c).Info().Err(err).Int("status", err.Status).Str("path", c.FullPath()).Msg("Request body refused")
+		c.AbortWithStatusJSON(err.Status, err.Body())
+		return false
+	}
+	return true
+}
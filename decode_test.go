@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const decodeTestItemID = "123e4567-e89b-12d3-a456-426614174000"
+
+type decodeTestRequest struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Tags  []string          `json:"tags"`
+	Meta  map[string]string `json:"meta"`
+}
+
+func TestDecodeStrict(t *testing.T) {
+	decode := func(body string, maxBytes int64, maxDepth int) (*decodeTestRequest, *DecodeError) {
+		var dst decodeTestRequest
+		err := DecodeStrict(strings.NewReader(body), &dst, maxBytes, maxDepth)
+		return &dst, err
+	}
+
+	t.Run("should decode a valid body", func(t *testing.T) {
+		dst, err := decode(`{"name": "a", "count": 2, "tags": ["x"]}`+"\n", 1024, 5)
+		require.Nil(t, err)
+		assert.Equal(t, "a", dst.Name)
+		assert.Equal(t, 2, dst.Count)
+	})
+
+	t.Run("should refuse bodies over the size limit", func(t *testing.T) {
+		_, err := decode(`{"name": "`+strings.Repeat("a", 100)+`"}`, 64, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, err.Status)
+	})
+
+	t.Run("should name unknown fields", func(t *testing.T) {
+		_, err := decode(`{"name": "a", "colour": "red"}`, 1024, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, http.StatusBadRequest, err.Status)
+		assert.Equal(t, []FieldError{{Field: "colour", Message: "is not a known field"}}, err.Fields)
+	})
+
+	t.Run("should name fields of the wrong type", func(t *testing.T) {
+		_, err := decode(`{"count": "two"}`, 1024, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, []FieldError{{Field: "count", Message: "must be a number"}}, err.Fields)
+
+		_, err = decode(`{"tags": "x"}`, 1024, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, []FieldError{{Field: "tags", Message: "must be an array"}}, err.Fields)
+	})
+
+	t.Run("should refuse trailing data", func(t *testing.T) {
+		for _, body := range []string{`{"name": "a"}{"name": "b"}`, `{"name": "a"} x`, `{"name": "a"}]`} {
+			_, err := decode(body, 1024, 5)
+			require.NotNil(t, err, body)
+			assert.Equal(t, http.StatusBadRequest, err.Status, body)
+		}
+	})
+
+	t.Run("should refuse deep nesting", func(t *testing.T) {
+		body := `{"meta": {"a": "b"}, "tags": ` + strings.Repeat("[", 6) + strings.Repeat("]", 6) + `}`
+		_, err := decode(body, 1024, 5)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Detail, "nested deeper than 5 levels")
+
+		_, err = decode(`{"meta": {"a": "b"}}`, 1024, 2)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should refuse malformed and empty bodies", func(t *testing.T) {
+		for _, body := range []string{"", "   ", "{invalid json", `{"name": "a"`, `["a"]`} {
+			_, err := decode(body, 1024, 5)
+			require.NotNil(t, err, body)
+			assert.Equal(t, malformedJSONMessage, err.Message, body)
+		}
+	})
+
+	t.Run("should run request validation", func(t *testing.T) {
+		err := DecodeStrict(strings.NewReader(`{"uuid": "not-a-uuid"}`), &UUIDRequest{}, 1024, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, "uuid: must be a UUID", err.Body()["error"])
+
+		err = DecodeStrict(strings.NewReader(`{}`), &UUIDRequest{}, 1024, 5)
+		require.NotNil(t, err)
+		assert.Equal(t, []FieldError{{Field: "uuid", Message: "is required"}}, err.Fields)
+	})
+}
+
+func TestJSONBodyLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("REQUEST_MAX_BODY_BYTES", "64")
+
+	app := &App{Log: &logger, Config: testConfig(t)}
+	router := gin.New()
+	router.Use(app.JSONOnlyMiddleware())
+	router.POST("/test", func(c *gin.Context) {
+		var req UUIDRequest
+		if app.DecodeJSON(c, &req) {
+			c.Status(http.StatusCreated)
+		}
+	})
+
+	send := func(body, contentType string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/test", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if chunked {
+			req.ContentLength = -1
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should accept small JSON bodies", func(t *testing.T) {
+		resp := send(`{"uuid": "`+decodeTestItemID+`"}`, "application/json; charset=utf-8", false)
+		assert.Equal(t, http.StatusCreated, resp.Code)
+	})
+
+	t.Run("should refuse other content types", func(t *testing.T) {
+		resp := send(`{"uuid": "`+decodeTestItemID+`"}`, "application/jsonp", false)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("should refuse large bodies up front and while reading", func(t *testing.T) {
+		body := `{"uuid": "` + decodeTestItemID + `", "padding": "` + strings.Repeat("a", 64) + `"}`
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send(body, "application/json", false).Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send(body, "application/json", true).Code)
+	})
+}
+
+func TestStrictDecodingOnAllWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	// services can write every list, including the service-only ones
+	keys, err := ParseServiceKeys([]byte(`{"services": [{"name": "writer", "key_sha256": "` + HashToken("writer-key") + `", "scopes": ["*"]}]}`))
+	require.NoError(t, err)
+
+	app := &App{
+		Log:    &logger,
+		Config: testConfig(t),
+		Auth: roleVerifier{
+			"admin-token": {PublicID: adminTestAdminID, Roles: []string{"admin"}},
+			"user-token":  {PublicID: adminTestUserID},
+		},
+		ServiceKeys: keys,
+	}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	paths := map[string]string{
+		"/list/admin/users/:public_id/:list_type": "/list/admin/users/" + adminTestUserID + "/watchlist",
+	}
+	// routes services are refused on whatever their scopes
+	userOnly := map[string]bool{
+		"/list/settings": true,
+		"/list/shares":   true,
+	}
+	for _, route := range app.Router.Routes() {
+		if route.Method != http.MethodPost && route.Method != http.MethodPatch && route.Method != http.MethodPut {
+			continue
+		}
+		path, ok := paths[route.Path]
+		if !ok {
+			path = route.Path
+		}
+		t.Run(route.Method+" "+route.Path, func(t *testing.T) {
+			require.NotContains(t, path, ":", "add an example path for %s", route.Path)
+
+			body, _ := json.Marshal(map[string]string{"uuid": decodeTestItemID, "unexpected": "x"})
+			req := httptest.NewRequest(route.Method, path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if strings.HasPrefix(route.Path, "/list/admin/") {
+				req.Header.Set("X-Access-Token", "admin-token")
+			} else if userOnly[route.Path] {
+				req.Header.Set("X-Access-Token", "user-token")
+			} else {
+				req.Header.Set("X-Service-Key", "writer-key")
+				req.Header.Set("X-Public-ID", adminTestUserID)
+			}
+			resp := httptest.NewRecorder()
+			app.Router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			assert.Equal(t, "unexpected: is not a known field", response["error"])
+		})
+	}
+}
@@ -14,42 +14,107 @@ import (
 // General handlers
 
 func (a *App) GetAllFromList(c *gin.Context, listType string) {
+	query, err := ParseListQuery(c, a.config().DefaultListSize, a.config().MaxPageSize)
+	if err != nil {
+		qe := err.(*ListQueryError)
+		c.JSON(http.StatusBadRequest, NewValidationError(qe.Field, qe.Message))
+		return
+	}
+
+	expand, err := wantsItemExpansion(c)
+	if err != nil {
+		qe := err.(*ListQueryError)
+		c.JSON(http.StatusBadRequest, NewValidationError(qe.Field, qe.Message))
+		return
+	}
+	if expand && a.Items == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "Item expansion is not available"})
+		return
+	}
+
+	if query.Cursor != "" {
+		after, err := a.cursorCodec().Decode(query.Cursor)
+		if err != nil || after.ListType != listType || after.Ascending != query.Ascending {
+			c.JSON(http.StatusBadRequest, NewValidationError("cursor", "is not valid for this list"))
+			return
+		}
+		query.After = after
+	}
+
 	publicID, _ := c.Get("public_id")
-	document, err := a.getListDocument(publicID.(string), listType)
+	document, err := a.getListDocument(c.Request.Context(), publicID.(string), listType)
 	m := "Could not find any " + listType + " for current user"
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": m})
 		return
 	}
 
-	listOfItemIds := make([]string, len(document.ItemIds))
-	for i, pId := range document.ItemIds {
-		listOfItemIds[i] = pId
+	response := gin.H{}
+	var listOfItemIds []string
+
+	if query.IsEmpty() {
+		listOfItemIds = make([]string, len(document.ItemIds))
+		for i, pId := range document.ItemIds {
+			listOfItemIds[i] = pId
+		}
+	} else {
+		results, err := a.getListEntries(c.Request.Context(), publicID.(string), listType, query)
+		if err != nil {
+			a.requestLog(c).Error().Err(err).Msg("Error querying list entries")
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		if query.Paginated() && len(results) > query.Limit {
+			results = results[:query.Limit]
+			last := results[len(results)-1]
+			next, err := a.cursorCodec().Encode(ListCursor{
+				ListType:  listType,
+				AddedAt:   last.AddedAt,
+				Position:  last.Position,
+				Ascending: query.Ascending,
+			})
+			if err != nil {
+				a.requestLog(c).Error().Err(err).Msg("Error encoding cursor")
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+				return
+			}
+			response["next_cursor"] = next
+		}
+
+		listOfItemIds = make([]string, len(results))
+		entries := make([]ListEntry, len(results))
+		for i, result := range results {
+			listOfItemIds[i] = result.ItemID
+			entries[i] = result.ListEntry
+		}
+		response["entries"] = entries
 	}
 
-	c.JSON(http.StatusOK, gin.H{listType: listOfItemIds})
+	response[listType] = listOfItemIds
+	if expand {
+		response["items"] = a.expandItems(c.Request.Context(), listOfItemIds)
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (a *App) AddToList(c *gin.Context, listType string) {
 	//TODO: Change to accept array of items?
 	var req UUIDRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Check ya inputs mate. Yer not valid, Jason"})
-		return
-	}
-
-	if !IsValidUUID(req.UUID) {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid UUID format"})
+	if !a.DecodeJSON(c, &req) {
 		return
 	}
 
 	publicId, _ := c.Get("public_id")
-	err := a.addToList(publicId.(string), listType, req.UUID)
+	change, err := a.addToList(c.Request.Context(), publicId.(string), listType, req.UUID)
 	if err != nil {
-		a.Log.Error().Err(err).Msg("Error adding to favourites")
+		a.requestLog(c).Error().Err(err).Msg("Error adding to favourites")
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
 		return
 	}
+	a.auditListChange(c, "add", listType, change)
+	a.appMetrics().ObserveListSize(listType, change.Size)
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Created"})
 }
@@ -58,16 +123,18 @@ func (a *App) RemoveItemFromList(c *gin.Context, listType string) {
 	itemId, err := uuid.Parse(c.Param("itemId"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		a.requestLog(c).Info().Msgf("Not a uuid string: [%s]", err.Error())
 		return
 	}
 
 	publicID, _ := c.Get("public_id")
-	err = a.removeFromList(publicID.(string), listType, itemId.String())
+	change, err := a.removeFromList(c.Request.Context(), publicID.(string), listType, itemId.String())
 	if err != nil {
 		c.JSON(http.StatusNoContent, gin.H{})
 		return
 	}
+	a.auditListChange(c, "remove", listType, change)
+	a.appMetrics().ObserveListSize(listType, change.Size)
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
@@ -75,74 +142,89 @@ func (a *App) RemoveItemFromList(c *gin.Context, listType string) {
 func (a *App) RemoveAllFromList(c *gin.Context, listType string) {
 
 	publicID, _ := c.Get("public_id")
-	err := a.removeFromList(publicID.(string), listType, "")
+	change, err := a.removeFromList(c.Request.Context(), publicID.(string), listType, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	a.auditListChange(c, "clear", listType, change)
+	a.appMetrics().ObserveListSize(listType, change.Size)
 
 	c.JSON(http.StatusGone, gin.H{})
 }
 
 //-----------------------------------------------------------------------------
-// Watching count handler (public - no auth required)
-
-func (a *App) GetWatchingCount(c *gin.Context) {
-	itemID := c.Param("item_id")
-
-	// Strong validation using github.com/google/uuid
-	parsedID, err := uuid.Parse(itemID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid item ID format"})
-		return
-	}
-
-	// Only use the canonical string form provided by google/uuid
-	safeItemID := parsedID.String()
+// Helper functions
 
-	// Count how many users have this item in their watchlist
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (a *App) getListDocument(ctx context.Context, publicID, listType string) (*UserList, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	collection := a.GetCollection("watchlist")
-	filter := bson.M{"item_ids": safeItemID}
+	collection := a.GetCollection(listType)
+	filter := bson.M{"_id": publicID}
 
-	count, err := collection.CountDocuments(ctx, filter)
+	var document UserList
+	err := collection.FindOne(ctx, filter).Decode(&document)
 	if err != nil {
-		a.Log.Error().Err(err).Msg("Error counting watching users")
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-		return
+		return nil, err
 	}
 
-	response := WatchingResponse{PeopleWatching: int(count)}
-	c.JSON(http.StatusOK, response)
+	return &document, nil
 }
 
-//-----------------------------------------------------------------------------
-// Helper functions
-
-func (a *App) getListDocument(publicID, listType string) (*UserList, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (a *App) getListEntries(ctx context.Context, publicID, listType string, query ListQuery) ([]listEntryResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	collection := a.GetCollection(listType)
-	filter := bson.M{"_id": publicID}
-
-	var document UserList
-	err := collection.FindOne(ctx, filter).Decode(&document)
+	cursor, err := collection.Aggregate(ctx, buildListEntriesPipeline(publicID, query))
 	if err != nil {
 		return nil, err
 	}
 
-	return &document, nil
+	results := make([]listEntryResult, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// entriesFor returns the document's entries, building them from ItemIds for
+// documents written before entries were stored
+func entriesFor(document *UserList) []ListEntry {
+	if len(document.Entries) == len(document.ItemIds) {
+		return document.Entries
+	}
+
+	entries := make([]ListEntry, len(document.ItemIds))
+	for i, itemId := range document.ItemIds {
+		entries[i] = ListEntry{ItemID: itemId, AddedAt: document.UpdatedAt}
+		for _, existing := range document.Entries {
+			if existing.ItemID == itemId {
+				entries[i].AddedAt = existing.AddedAt
+				break
+			}
+		}
+	}
+	return entries
+}
+
+// ListChange is what a mutation did to a list. Removed includes items
+// pushed off the end of a full list by an add, and Size is the number of
+// items left afterwards
+type ListChange struct {
+	Added   []string
+	Removed []string
+	Size    int
 }
 
-func (a *App) addToList(publicID, listType, uuid string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (a *App) addToList(ctx context.Context, publicID, listType, uuid string) (*ListChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	collection := a.GetCollection(listType)
-	document, err := a.getListDocument(publicID, listType)
+	document, err := a.getListDocument(ctx, publicID, listType)
 
 	now := time.Now()
 
@@ -150,45 +232,59 @@ func (a *App) addToList(publicID, listType, uuid string) error {
 		newDocument := UserList{
 			ID:        publicID,
 			ItemIds:   []string{uuid},
+			Entries:   []ListEntry{{ItemID: uuid, AddedAt: now}},
 			CreatedAt: now,
 			UpdatedAt: now,
 		}
 
-		listId, er2 := collection.InsertOne(ctx, newDocument)
-		a.Log.Info().Interface("listId", listId).Send()
-		return er2
+		if _, er2 := collection.InsertOne(ctx, newDocument); er2 != nil {
+			return nil, er2
+		}
+		// public_id as its own field so the redactor pseudonymises it
+		loggerFromContext(ctx, a.Log).Info().Str("public_id", publicID).Str("list_type", listType).Msg("List created")
+		return &ListChange{Added: []string{uuid}, Size: 1}, nil
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, existingUUID := range document.ItemIds {
 		if existingUUID == uuid {
-			return nil
+			return &ListChange{Size: len(document.ItemIds)}, nil
 		}
 	}
 
+	entries := entriesFor(document)
 	document.ItemIds = append([]string{uuid}, document.ItemIds...)
-
-	if len(document.ItemIds) > 50 {
-		document.ItemIds = document.ItemIds[:50]
+	document.Entries = append([]ListEntry{{ItemID: uuid, AddedAt: now}}, entries...)
+
+	// the oldest items drop off the end once the list is full
+	change := &ListChange{Added: []string{uuid}}
+	if size := a.config().MaxListSize; len(document.ItemIds) > size {
+		change.Removed = append(change.Removed, document.ItemIds[size:]...)
+		document.ItemIds = document.ItemIds[:size]
+		document.Entries = document.Entries[:size]
 	}
 
 	document.UpdatedAt = now
+	change.Size = len(document.ItemIds)
 
 	filter := bson.M{"_id": publicID}
 	update := bson.M{
 		"$set": bson.M{
 			"item_ids":   document.ItemIds,
+			"entries":    document.Entries,
 			"updated_at": document.UpdatedAt,
 		},
 	}
 
-	_, err = collection.UpdateOne(ctx, filter, update)
-	return err
+	if _, err = collection.UpdateOne(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return change, nil
 }
 
-func (a *App) removeFromList(publicID, listType, itemId string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (a *App) removeFromList(ctx context.Context, publicID, listType, itemId string) (*ListChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	collection := a.GetCollection(listType)
@@ -196,41 +292,60 @@ func (a *App) removeFromList(publicID, listType, itemId string) error {
 
 	if itemId == "" {
 
-		// delete all of listType for the current user
-		_, err := collection.DeleteOne(ctx, filter)
-		return err
+		// delete all of listType for the current user, keeping what was
+		// in it for the audit record
+		var document UserList
+		err := collection.FindOneAndDelete(ctx, filter).Decode(&document)
+		if err == mongo.ErrNoDocuments {
+			return &ListChange{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return &ListChange{Removed: document.ItemIds}, nil
 
 	} else {
 
-		document, err := a.getListDocument(publicID, listType)
+		document, err := a.getListDocument(ctx, publicID, listType)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
+		change := &ListChange{}
 		newItems := make([]string, 0, len(document.ItemIds))
-		for _, existingUUID := range document.ItemIds {
-			if existingUUID != itemId {
-				newItems = append(newItems, existingUUID)
+		newEntries := make([]ListEntry, 0, len(document.ItemIds))
+		for _, entry := range entriesFor(document) {
+			if entry.ItemID != itemId {
+				newItems = append(newItems, entry.ItemID)
+				newEntries = append(newEntries, entry)
+			} else {
+				change.Removed = append(change.Removed, itemId)
 			}
 		}
 
 		if len(newItems) == 0 {
 			// if no more items delete whole record
-			_, err = collection.DeleteOne(ctx, filter)
-			return err
+			if _, err = collection.DeleteOne(ctx, filter); err != nil {
+				return nil, err
+			}
+			return change, nil
 		}
 
 		document.ItemIds = newItems
+		document.Entries = newEntries
 		document.UpdatedAt = time.Now()
+		change.Size = len(newItems)
 
 		update := bson.M{
 			"$set": bson.M{
 				"item_ids":   document.ItemIds,
+				"entries":    document.Entries,
 				"updated_at": document.UpdatedAt,
 			},
 		}
 
-		_, err = collection.UpdateOne(ctx, filter, update)
-		return err
+		if _, err = collection.UpdateOne(ctx, filter, update); err != nil {
+			return nil, err
+		}
+		return change, nil
 	}
 }
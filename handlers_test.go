@@ -20,6 +20,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
 // HandlerTestSuite provides integration tests for all handlers using real MongoDB
@@ -54,6 +55,10 @@ func (suite *HandlerTestSuite) SetupSuite() {
 	os.Setenv("AUTHYURL", authServiceURL)
 	os.Setenv("VERSION", "test-1.0.0")
 	os.Setenv("MAX_LIST_SIZE", "50")
+	// the suite shares one app, so keep it clear of the rate limiter
+	os.Setenv("RATE_LIMIT_REQUESTS", "0")
+	// exact watcher counts, the privacy floor has its own test
+	os.Setenv("WATCHER_COUNT_MIN", "0")
 
 	gin.SetMode(gin.TestMode)
 
@@ -65,7 +70,8 @@ func (suite *HandlerTestSuite) SetupSuite() {
 
 	// Create app instance
 	suite.app = &App{
-		Log: &logger,
+		Log:    &logger,
+		Config: testConfig(suite.T()),
 	}
 
 	// Initialize database connection
@@ -78,7 +84,7 @@ func (suite *HandlerTestSuite) SetupSuite() {
 	suite.router = suite.app.Router
 
 	// Track collections for cleanup
-	suite.cleanup = []string{"watchlist", "favourites", "viewed", "bids", "purchased"}
+	suite.cleanup = []string{"watchlist", "favourites", "viewed", "bids", "purchased", userSettingsCollection, sharesCollection, auditCollection}
 }
 
 // TearDownSuite cleans up after all tests
@@ -231,6 +237,147 @@ func (suite *HandlerTestSuite) TestGetAllFromList() {
 	})
 }
 
+// Helper function to create test data with per-item timestamps
+func (suite *HandlerTestSuite) createTestListWithEntries(userID, listType string, entries []ListEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := suite.app.GetCollection(listType)
+	now := time.Now()
+
+	itemIds := make([]string, len(entries))
+	for i, entry := range entries {
+		itemIds[i] = entry.ItemID
+	}
+
+	document := UserList{
+		ID:        userID,
+		ItemIds:   itemIds,
+		Entries:   entries,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err := collection.InsertOne(ctx, document)
+	require.NoError(suite.T(), err)
+}
+
+// Test time range filtering and sorting in GetAllFromList
+func (suite *HandlerTestSuite) TestGetAllFromListFiltering() {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	entries := []ListEntry{
+		{ItemID: testItemID3, AddedAt: base.Add(48 * time.Hour)},
+		{ItemID: testItemID2, AddedAt: base.Add(24 * time.Hour)},
+		{ItemID: testItemID1, AddedAt: base},
+	}
+
+	getItems := func(url string) []string {
+		resp := suite.makeRequest("GET", url, "valid-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+
+		var response struct {
+			Viewed  []string    `json:"viewed"`
+			Entries []ListEntry `json:"entries"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &response)
+		require.NoError(suite.T(), err)
+		assert.Len(suite.T(), response.Entries, len(response.Viewed))
+		return response.Viewed
+	}
+
+	suite.Run("should filter by since", func() {
+		suite.createTestListWithEntries(testUserID1, "viewed", entries)
+		defer suite.cleanupTestData()
+
+		items := getItems("/list/viewed?since=2024-06-02T00:00:00Z")
+		assert.Equal(suite.T(), []string{testItemID3, testItemID2}, items)
+	})
+
+	suite.Run("should filter by since and until", func() {
+		suite.createTestListWithEntries(testUserID1, "viewed", entries)
+		defer suite.cleanupTestData()
+
+		items := getItems("/list/viewed?since=2024-06-02T00:00:00Z&until=2024-06-03T00:00:00Z")
+		assert.Equal(suite.T(), []string{testItemID2}, items)
+	})
+
+	suite.Run("should sort oldest first", func() {
+		suite.createTestListWithEntries(testUserID1, "viewed", entries)
+		defer suite.cleanupTestData()
+
+		items := getItems("/list/viewed?sort=added_at")
+		assert.Equal(suite.T(), []string{testItemID1, testItemID2, testItemID3}, items)
+
+		items = getItems("/list/viewed?order=asc")
+		assert.Equal(suite.T(), []string{testItemID1, testItemID2, testItemID3}, items)
+	})
+
+	suite.Run("should reverse legacy lists without entries when sorted oldest first", func() {
+		suite.createTestList(testUserID1, "viewed", []string{testItemID1, testItemID2})
+		defer suite.cleanupTestData()
+
+		items := getItems("/list/viewed?sort=added_at")
+		assert.Equal(suite.T(), []string{testItemID2, testItemID1}, items)
+	})
+
+	suite.Run("should reject invalid parameters", func() {
+		resp := suite.makeRequest("GET", "/list/viewed?since=last-week", "valid-token", nil)
+		assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
+	})
+
+	suite.Run("should page through the list with a cursor while items are added", func() {
+		suite.createTestListWithEntries(testUserID1, "viewed", entries)
+		defer suite.cleanupTestData()
+
+		type page struct {
+			Viewed     []string `json:"viewed"`
+			NextCursor string   `json:"next_cursor"`
+		}
+		getPage := func(url string) page {
+			resp := suite.makeRequest("GET", url, "valid-token", nil)
+			require.Equal(suite.T(), http.StatusOK, resp.Code)
+			var p page
+			require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &p))
+			return p
+		}
+
+		first := getPage("/list/viewed?limit=2")
+		assert.Equal(suite.T(), []string{testItemID3, testItemID2}, first.Viewed)
+		require.NotEmpty(suite.T(), first.NextCursor)
+
+		// a new item is prepended between pages
+		suite.makeRequest("POST", "/list/viewed", "valid-token", UUIDRequest{UUID: uuid.New().String()})
+
+		second := getPage("/list/viewed?limit=2&cursor=" + first.NextCursor)
+		assert.Equal(suite.T(), []string{testItemID1}, second.Viewed)
+		assert.Empty(suite.T(), second.NextCursor)
+	})
+
+	suite.Run("should reject a cursor from another list", func() {
+		suite.createTestListWithEntries(testUserID1, "viewed", entries)
+		defer suite.cleanupTestData()
+
+		next, err := suite.app.cursorCodec().Encode(ListCursor{ListType: "watchlist"})
+		require.NoError(suite.T(), err)
+
+		resp := suite.makeRequest("GET", "/list/viewed?cursor="+next, "valid-token", nil)
+		assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
+	})
+
+	suite.Run("should record entries when adding items", func() {
+		defer suite.cleanupTestData()
+
+		suite.makeRequest("POST", "/list/viewed", "valid-token", UUIDRequest{UUID: testItemID1})
+		suite.makeRequest("POST", "/list/viewed", "valid-token", UUIDRequest{UUID: testItemID2})
+
+		document, err := suite.app.getListDocument(context.Background(), testUserID1, "viewed")
+		require.NoError(suite.T(), err)
+		require.Len(suite.T(), document.Entries, 2)
+		assert.Equal(suite.T(), testItemID2, document.Entries[0].ItemID)
+		assert.Equal(suite.T(), testItemID1, document.Entries[1].ItemID)
+	})
+}
+
 // Test AddToList handler
 func (suite *HandlerTestSuite) TestAddToList() {
 	suite.Run("should create new list when none exists", func() {
@@ -322,10 +469,11 @@ func (suite *HandlerTestSuite) TestAddToList() {
 
 		assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
 
-		var response map[string]string
+		var response map[string]interface{}
 		err := json.Unmarshal(resp.Body.Bytes(), &response)
 		require.NoError(suite.T(), err)
-		assert.Equal(suite.T(), "Invalid UUID format", response["message"])
+		assert.Equal(suite.T(), "Validation error", response["message"])
+		assert.Equal(suite.T(), "uuid: must be a UUID", response["error"])
 	})
 
 	suite.Run("should reject malformed JSON", func() {
@@ -495,6 +643,236 @@ func (suite *HandlerTestSuite) TestGetWatchingCount() {
 	})
 }
 
+// Test watcher count privacy
+func (suite *HandlerTestSuite) TestWatcherCountPrivacy() {
+	watching := func() WatchingResponse {
+		resp := suite.makeRequest("GET", "/list/watching/"+testItemID1, "", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		var response WatchingResponse
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		return response
+	}
+
+	suite.Run("should leave out users who hide themselves", func() {
+		suite.createTestList(testUserID1, "watchlist", []string{testItemID1})
+		suite.createTestList(testUserID2, "watchlist", []string{testItemID1})
+		assert.Equal(suite.T(), 2, watching().PeopleWatching)
+
+		resp := suite.makeRequest("PATCH", "/list/settings", "valid-token", map[string]bool{"hide_from_watcher_counts": true})
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		assert.Equal(suite.T(), 1, watching().PeopleWatching)
+
+		resp = suite.makeRequest("GET", "/list/settings", "valid-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		var settings map[string]interface{}
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &settings))
+		assert.Equal(suite.T(), true, settings["hide_from_watcher_counts"])
+
+		suite.makeRequest("PATCH", "/list/settings", "valid-token", map[string]bool{"hide_from_watcher_counts": false})
+		assert.Equal(suite.T(), 2, watching().PeopleWatching)
+	})
+
+	suite.Run("should hide counts below the privacy floor", func() {
+		policy := suite.app.watcherCounts
+		suite.app.watcherCounts = &WatcherCountPolicy{MinCount: 3}
+		defer func() { suite.app.watcherCounts = policy }()
+
+		response := watching()
+		assert.Equal(suite.T(), 0, response.PeopleWatching)
+		assert.Equal(suite.T(), "fewer than 3", response.Display)
+		assert.False(suite.T(), response.Exact)
+	})
+
+	suite.Run("should require a setting in updates", func() {
+		resp := suite.makeRequest("PATCH", "/list/settings", "valid-token", map[string]string{})
+		assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
+	})
+}
+
+// Test share links
+func (suite *HandlerTestSuite) TestShares() {
+	ctx := context.Background()
+	require.NoError(suite.T(), EnsureShareIndexes(ctx, suite.app.GetCollection(sharesCollection)))
+
+	createShare := func(body interface{}) NewShareResponse {
+		resp := suite.makeRequest("POST", "/list/shares", "valid-token", body)
+		require.Equal(suite.T(), http.StatusCreated, resp.Code)
+		var share NewShareResponse
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &share))
+		return share
+	}
+	listShares := func() []Share {
+		resp := suite.makeRequest("GET", "/list/shares", "valid-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		var response map[string][]Share
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		return response["shares"]
+	}
+
+	suite.Run("should let anyone with the token read the list", func() {
+		suite.createTestList(testUserID1, "watchlist", []string{testItemID1, testItemID2})
+		suite.createTestList(testUserID1, "favourites", []string{testItemID3})
+		share := createShare(map[string]string{"list_type": "watchlist"})
+		assert.Equal(suite.T(), "/list/shared/"+share.Token, share.Path)
+		assert.Nil(suite.T(), share.ExpiresAt)
+
+		for i := 0; i < 2; i++ {
+			resp := suite.makeRequest("GET", share.Path, "", nil)
+			require.Equal(suite.T(), http.StatusOK, resp.Code)
+			var response map[string]interface{}
+			require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+			assert.Equal(suite.T(), "watchlist", response["list_type"])
+			assert.Equal(suite.T(), []interface{}{testItemID1, testItemID2}, response["watchlist"])
+			assert.NotContains(suite.T(), response, "favourites")
+			assert.NotContains(suite.T(), resp.Body.String(), testUserID1)
+		}
+
+		shares := listShares()
+		require.Len(suite.T(), shares, 1)
+		assert.Equal(suite.T(), share.ID, shares[0].ID)
+		assert.Equal(suite.T(), int64(2), shares[0].ViewCount)
+		assert.NotNil(suite.T(), shares[0].LastViewedAt)
+		assert.NotContains(suite.T(), suite.makeRequest("GET", "/list/shares", "valid-token", nil).Body.String(), share.Token)
+	})
+
+	suite.Run("should stop working once revoked", func() {
+		share := createShare(map[string]string{"list_type": "favourites"})
+
+		resp := suite.makeRequest("DELETE", "/list/shares/"+share.ID, "valid-token", nil)
+		assert.Equal(suite.T(), http.StatusNoContent, resp.Code)
+
+		assert.Equal(suite.T(), http.StatusNotFound, suite.makeRequest("GET", share.Path, "", nil).Code)
+		assert.Empty(suite.T(), listShares())
+		assert.Equal(suite.T(), http.StatusNotFound, suite.makeRequest("DELETE", "/list/shares/"+share.ID, "valid-token", nil).Code)
+	})
+
+	suite.Run("should stop working once expired", func() {
+		share := createShare(map[string]string{"list_type": "watchlist", "expires_at": time.Now().Add(time.Hour).Format(time.RFC3339)})
+		require.NotNil(suite.T(), share.ExpiresAt)
+		assert.Equal(suite.T(), http.StatusOK, suite.makeRequest("GET", share.Path, "", nil).Code)
+
+		_, err := suite.app.GetCollection(sharesCollection).UpdateOne(ctx, bson.M{"_id": share.ID},
+			bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+		require.NoError(suite.T(), err)
+
+		assert.Equal(suite.T(), http.StatusNotFound, suite.makeRequest("GET", share.Path, "", nil).Code)
+		assert.Empty(suite.T(), listShares())
+	})
+
+	suite.Run("should share an empty list", func() {
+		suite.cleanupTestData()
+		share := createShare(map[string]string{"list_type": "favourites"})
+
+		resp := suite.makeRequest("GET", share.Path, "", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		assert.Contains(suite.T(), resp.Body.String(), `"favourites":[]`)
+	})
+
+	suite.Run("should not let users revoke each other's shares", func() {
+		token, err := NewShareToken()
+		require.NoError(suite.T(), err)
+		other := Share{ID: uuid.New().String(), TokenHash: HashToken(token), PublicID: testUserID2, ListType: "watchlist", CreatedAt: time.Now()}
+		_, err = suite.app.GetCollection(sharesCollection).InsertOne(ctx, other)
+		require.NoError(suite.T(), err)
+
+		resp := suite.makeRequest("DELETE", "/list/shares/"+other.ID, "valid-token", nil)
+		assert.Equal(suite.T(), http.StatusNotFound, resp.Code)
+		assert.Equal(suite.T(), http.StatusOK, suite.makeRequest("GET", "/list/shared/"+token, "", nil).Code)
+	})
+}
+
+// Test the readiness checks against a real database
+func (suite *HandlerTestSuite) TestReadinessChecks() {
+	ctx := context.Background()
+
+	detail, err := suite.app.checkMongo(ctx)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "ping ok", detail)
+
+	// the suite doesn't create the startup indexes itself
+	suite.app.initialiseShares()
+	suite.app.initialiseAudit()
+	detail, err = suite.app.checkMigrations(ctx)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "indexes in place", detail)
+
+	_, err = suite.app.GetCollection(sharesCollection).Indexes().DropAll(ctx)
+	require.NoError(suite.T(), err)
+	_, err = suite.app.checkMigrations(ctx)
+	assert.EqualError(suite.T(), err, "shares index token_hash_unique missing")
+	suite.app.initialiseShares()
+}
+
+// Test the list change audit log
+func (suite *HandlerTestSuite) TestAuditLog() {
+	const adminID = "aaaaaaaa-bbbb-4ccc-8ddd-eeeeeeeeeeee"
+
+	suite.Run("should record every change to a list", func() {
+		resp := suite.makeRequest("POST", "/list/watchlist", "valid-token", UUIDRequest{UUID: testItemID1})
+		require.Equal(suite.T(), http.StatusCreated, resp.Code)
+		firstRequestID := resp.Header().Get("X-Request-ID")
+		suite.makeRequest("POST", "/list/watchlist", "valid-token", UUIDRequest{UUID: testItemID2})
+		suite.makeRequest("DELETE", "/list/watchlist/"+testItemID1, "valid-token", nil)
+		suite.makeRequest("DELETE", "/list/watchlist", "valid-token", nil)
+
+		// read them back as an admin
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", authServiceURL,
+			httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+				"public_id": adminID,
+				"roles":     []string{"admin"},
+			}))
+
+		resp = suite.makeRequest("GET", "/list/admin/audit?public_id="+testUserID1, "admin-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		var response struct {
+			Records []AuditRecord `json:"records"`
+			HasMore bool          `json:"has_more"`
+		}
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		require.Len(suite.T(), response.Records, 4)
+		assert.False(suite.T(), response.HasMore)
+
+		// newest first
+		operations := make([]string, len(response.Records))
+		for i, record := range response.Records {
+			operations[i] = record.Operation
+			assert.Equal(suite.T(), "user", record.ActorType)
+			assert.Equal(suite.T(), testUserID1, record.ActorID)
+			assert.Equal(suite.T(), "watchlist", record.ListType)
+		}
+		assert.Equal(suite.T(), []string{"clear", "remove", "add", "add"}, operations)
+		assert.Equal(suite.T(), []string{testItemID2}, response.Records[0].ItemIDs)
+		assert.Equal(suite.T(), []string{testItemID1}, response.Records[1].ItemIDs)
+		// each record carries the ID of the request that made it
+		assert.NotEmpty(suite.T(), firstRequestID)
+		assert.Equal(suite.T(), firstRequestID, response.Records[3].RequestID)
+
+		resp = suite.makeRequest("GET", "/list/admin/audit?public_id="+testUserID1+"&operation=add&limit=1", "admin-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		require.Len(suite.T(), response.Records, 1)
+		assert.True(suite.T(), response.HasMore)
+		assert.Equal(suite.T(), []string{testItemID2}, response.Records[0].ItemIDs)
+	})
+
+	suite.Run("should record admins as the actor", func() {
+		resp := suite.makeRequest("POST", "/list/admin/users/"+testUserID2+"/favourites", "admin-token", UUIDRequest{UUID: testItemID3})
+		require.Equal(suite.T(), http.StatusCreated, resp.Code)
+
+		resp = suite.makeRequest("GET", "/list/admin/audit?public_id="+testUserID2, "admin-token", nil)
+		require.Equal(suite.T(), http.StatusOK, resp.Code)
+		var response struct {
+			Records []AuditRecord `json:"records"`
+		}
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		require.Len(suite.T(), response.Records, 1)
+		assert.Equal(suite.T(), "admin", response.Records[0].ActorType)
+		assert.Equal(suite.T(), adminID, response.Records[0].ActorID)
+		assert.Equal(suite.T(), testUserID2, response.Records[0].PublicID)
+	})
+}
+
 // Test database error scenarios
 func (suite *HandlerTestSuite) TestDatabaseErrorHandling() {
 	suite.Run("should handle database connection issues gracefully", func() {
@@ -545,7 +923,155 @@ func (suite *HandlerTestSuite) TestAuthenticationEdgeCases() {
 	})
 }
 
+// Test admin access to other users' lists
+func (suite *HandlerTestSuite) TestAdminRoutes() {
+	const adminID = "aaaaaaaa-bbbb-4ccc-8ddd-eeeeeeeeeeee"
+	suite.cleanup = append(suite.cleanup, adminActionsCollection)
+
+	httpmock.Reset()
+	httpmock.RegisterResponder("GET", authServiceURL,
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+			"public_id": adminID,
+			"roles":     []string{"admin"},
+		}))
+
+	userPath := "/list/admin/users/" + testUserID2 + "/watchlist"
+
+	suite.Run("should read another user's list", func() {
+		suite.createTestList(testUserID2, "watchlist", []string{testItemID1})
+
+		resp := suite.makeRequest("GET", userPath, "admin-token", nil)
+		assert.Equal(suite.T(), http.StatusOK, resp.Code)
+
+		var response map[string][]string
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(suite.T(), []string{testItemID1}, response["watchlist"])
+	})
+
+	suite.Run("should add to and remove from another user's list", func() {
+		resp := suite.makeRequest("POST", userPath, "admin-token", UUIDRequest{UUID: testItemID3})
+		assert.Equal(suite.T(), http.StatusCreated, resp.Code)
+
+		document, err := suite.app.getListDocument(context.Background(), testUserID2, "watchlist")
+		require.NoError(suite.T(), err)
+		assert.Contains(suite.T(), document.ItemIds, testItemID3)
+
+		resp = suite.makeRequest("DELETE", userPath+"/"+testItemID3, "admin-token", nil)
+		assert.Equal(suite.T(), http.StatusNoContent, resp.Code)
+
+		document, err = suite.app.getListDocument(context.Background(), testUserID2, "watchlist")
+		require.NoError(suite.T(), err)
+		assert.NotContains(suite.T(), document.ItemIds, testItemID3)
+	})
+
+	suite.Run("should clear another user's list", func() {
+		resp := suite.makeRequest("DELETE", userPath, "admin-token", nil)
+		assert.Equal(suite.T(), http.StatusGone, resp.Code)
+
+		_, err := suite.app.getListDocument(context.Background(), testUserID2, "watchlist")
+		assert.Error(suite.T(), err)
+	})
+
+	suite.Run("should find the users holding an item", func() {
+		suite.createTestList(testUserID1, "watchlist", []string{testItemID1})
+		suite.createTestList(testUserID2, "favourites", []string{testItemID1})
+
+		resp := suite.makeRequest("GET", "/list/admin/items/"+testItemID1, "admin-token", nil)
+		assert.Equal(suite.T(), http.StatusOK, resp.Code)
+
+		var response ItemHoldersResponse
+		require.NoError(suite.T(), json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(suite.T(), []string{testUserID1}, response.Lists["watchlist"])
+		assert.Equal(suite.T(), []string{testUserID2}, response.Lists["favourites"])
+		assert.Empty(suite.T(), response.Lists["bids"])
+	})
+
+	suite.Run("should record every admin action", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var actions []AdminAction
+		cursor, err := suite.app.GetCollection(adminActionsCollection).Find(ctx, bson.M{"admin_id": adminID})
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), cursor.All(ctx, &actions))
+
+		recorded := map[string]bool{}
+		for _, action := range actions {
+			recorded[action.Action] = true
+		}
+		for _, action := range []string{"add_item", "remove_item", "find_item"} {
+			assert.True(suite.T(), recorded[action], action)
+		}
+	})
+
+	suite.Run("should refuse users without the admin role", func() {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", authServiceURL,
+			httpmock.NewJsonResponderOrPanic(200, map[string]string{
+				"public_id": testUserID1,
+			}))
+
+		resp := suite.makeRequest("GET", userPath, "valid-token", nil)
+		assert.Equal(suite.T(), http.StatusForbidden, resp.Code)
+	})
+}
+
 // Run the test suite
 func TestHandlerTestSuite(t *testing.T) {
 	suite.Run(t, new(HandlerTestSuite))
-}
\ No newline at end of file
+}
+
+func TestAddToListTruncates(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("should drop the oldest items past MAX_LIST_SIZE", func(mt *mtest.T) {
+		t.Setenv("MAX_LIST_SIZE", "3")
+		logger := zerolog.Nop()
+		app := &App{Log: &logger, Config: testConfig(t), DB: mt.DB}
+
+		existing := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
+		newItem := uuid.New().String()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "poptape_lister.watchlist", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: adminTestUserID},
+				{Key: "item_ids", Value: existing},
+			}),
+			mtest.CreateSuccessResponse(),
+		)
+		change, err := app.addToList(context.Background(), adminTestUserID, "watchlist", newItem)
+		require.NoError(mt, err)
+		assert.Equal(mt, 3, change.Size)
+		assert.Equal(mt, []string{newItem}, change.Added)
+		assert.Equal(mt, existing[2:], change.Removed)
+	})
+}
+
+func TestAddToListDuplicate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("should not audit adding an item already on the list", func(mt *mtest.T) {
+		gin.SetMode(gin.TestMode)
+		var logs bytes.Buffer
+		logger := zerolog.New(&logs)
+		app := &App{Log: &logger, Config: testConfig(t), DB: mt.DB}
+
+		// the list is read and nothing is written, so an audit insert
+		// would find no response left and log an error
+		itemID := uuid.New().String()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "poptape_lister.watchlist", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: adminTestUserID},
+			{Key: "item_ids", Value: []string{itemID}},
+		}))
+
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request = httptest.NewRequest("POST", "/list/watchlist", bytes.NewBufferString(`{"uuid": "`+itemID+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("public_id", adminTestUserID)
+		app.AddToList(c, "watchlist")
+
+		assert.Equal(mt, http.StatusCreated, resp.Code)
+		assert.NotContains(mt, logs.String(), "List changed")
+		assert.NotContains(mt, logs.String(), "Error recording list change")
+	})
+}
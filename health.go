@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+//-----------------------------------------------------------------------------
+// Health probes
+// /list/health/live says the process is up and serving. /list/health/ready
+// says it can do useful work: MongoDB answers a ping, the token verifier can
+// reach authy or holds fresh JWKS keys, and the indexes made at startup are
+// in place. Readiness results are cached for HEALTH_CACHE_TTL so a busy
+// orchestrator doesn't turn probes into load, and each check gets
+// HEALTH_CHECK_TIMEOUT to answer
+
+const (
+	defaultHealthCacheTTL     = 5 * time.Second
+	defaultHealthCheckTimeout = 2 * time.Second
+)
+
+// schemaIndexes are the indexes created at startup, by collection. A
+// missing one means startup didn't finish or someone dropped it
+var schemaIndexes = map[string][]string{
+	sharesCollection: {"token_hash_unique", "public_id_created_at", "expires_at_ttl"},
+	auditCollection:  {"public_id_at", "at", "expires_at_ttl"},
+}
+
+// HealthCheck is one readiness dependency. Check returns a short
+// description of the dependency's state, or an error if it isn't usable
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) (string, error)
+}
+
+type HealthCheckResult struct {
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type HealthReport struct {
+	Status    string                       `json:"status"`
+	CheckedAt time.Time                    `json:"checked_at"`
+	Checks    map[string]HealthCheckResult `json:"checks"`
+}
+
+// Ready says whether every check passed
+func (r *HealthReport) Ready() bool {
+	return r.Status == "ready"
+}
+
+// HealthChecker runs the readiness checks, sharing one run between callers
+// until the cached report is CacheTTL old
+type HealthChecker struct {
+	Checks   []HealthCheck
+	CacheTTL time.Duration
+	Timeout  time.Duration
+
+	mu     sync.Mutex
+	report *HealthReport
+	now    func() time.Time
+}
+
+func NewHealthChecker(cacheTTL, timeout time.Duration, checks ...HealthCheck) *HealthChecker {
+	return &HealthChecker{Checks: checks, CacheTTL: cacheTTL, Timeout: timeout, now: time.Now}
+}
+
+// HealthConfig holds the readiness check settings
+type HealthConfig struct {
+	CacheTTL time.Duration
+	Timeout  time.Duration
+}
+
+// NewHealthConfig reads HEALTH_CACHE_TTL and HEALTH_CHECK_TIMEOUT
+func NewHealthConfig(s Settings) (*HealthConfig, error) {
+	cacheTTL, err := time.ParseDuration(s.GetOrDefault("HEALTH_CACHE_TTL", defaultHealthCacheTTL.String()))
+	if err != nil || cacheTTL < 0 {
+		return nil, fmt.Errorf("invalid HEALTH_CACHE_TTL")
+	}
+	timeout, err := time.ParseDuration(s.GetOrDefault("HEALTH_CHECK_TIMEOUT", defaultHealthCheckTimeout.String()))
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid HEALTH_CHECK_TIMEOUT")
+	}
+	return &HealthConfig{CacheTTL: cacheTTL, Timeout: timeout}, nil
+}
+
+// Report returns the cached report, running the checks again once it's
+// stale. Checks run in parallel, each under its own timeout
+func (hc *HealthChecker) Report(ctx context.Context) *HealthReport {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	now := hc.now()
+	if hc.report != nil && now.Sub(hc.report.CheckedAt) < hc.CacheTTL {
+		return hc.report
+	}
+
+	// a probe that gives up shouldn't leave a half-run report cached
+	ctx = context.WithoutCancel(ctx)
+	results := make([]HealthCheckResult, len(hc.Checks))
+	var wg sync.WaitGroup
+	for i, check := range hc.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = hc.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := &HealthReport{Status: "ready", CheckedAt: now, Checks: map[string]HealthCheckResult{}}
+	for i, check := range hc.Checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != "up" {
+			report.Status = "not_ready"
+		}
+	}
+	hc.report = report
+	return report
+}
+
+func (hc *HealthChecker) run(ctx context.Context, check HealthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		detail, err := check.Check(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		// checks should honour ctx, but one that doesn't can't hold up the probe
+		o.err = errors.New("timed out")
+	}
+
+	result := HealthCheckResult{Status: "up", Detail: o.detail, LatencyMS: time.Since(start).Milliseconds()}
+	if o.err != nil {
+		result.Status = "down"
+		result.Error = o.err.Error()
+		if errors.Is(o.err, context.DeadlineExceeded) {
+			result.Error = "timed out"
+		}
+	}
+	return result
+}
+
+//-----------------------------------------------------------------------------
+// Checks
+// errors returned here are shown on an unauthenticated endpoint, so they
+// describe the problem without hostnames or driver detail. The full error
+// is logged
+
+func (a *App) checkMongo(ctx context.Context) (string, error) {
+	if a.Client == nil {
+		return "", errors.New("not connected")
+	}
+	if err := a.Client.Ping(ctx, readpref.Primary()); err != nil {
+		a.Log.Warn().Err(err).Msg("Readiness check: MongoDB ping failed")
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		}
+		return "", errors.New("ping failed")
+	}
+	return "ping ok", nil
+}
+
+// checkAuth checks whatever the configured verifier depends on
+func (a *App) checkAuth(ctx context.Context) (string, error) {
+	verifier := a.tokenVerifier()
+	if caching, ok := verifier.(*CachingVerifier); ok {
+		verifier = caching.Next
+	}
+
+	switch v := verifier.(type) {
+	case *AuthyVerifier:
+		return a.checkAuthy(ctx, v)
+	case *JWTVerifier:
+		return checkJWKS(v, time.Now())
+	}
+	return "no external dependency", nil
+}
+
+// checkAuthy asks authy for an empty token. Any answer that isn't a server
+// error means it's up. The probe goes round the circuit breaker so it
+// neither trips nor resets it
+func (a *App) checkAuthy(ctx context.Context, v *AuthyVerifier) (string, error) {
+	if v.client.Breaker.State() == CircuitOpen {
+		return "", errors.New("circuit open")
+	}
+
+	if v.url == "" {
+		return "", errors.New("AUTHYURL not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", v.url, nil)
+	if err != nil {
+		return "", errors.New("invalid AUTHYURL")
+	}
+	resp, err := v.client.HTTP.Do(req)
+	if err != nil {
+		a.Log.Warn().Err(err).Msg("Readiness check: authy unreachable")
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		}
+		return "", errors.New("unreachable")
+	}
+	resp.Body.Close()
+
+	if retryable(resp.StatusCode) {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return fmt.Sprintf("reachable, circuit %s", v.client.Breaker.State()), nil
+}
+
+// checkJWKS fails once the keys have missed a few refreshes in a row, as
+// rotated keys won't have been picked up
+func checkJWKS(v *JWTVerifier, now time.Time) (string, error) {
+	if v.Keys == nil {
+		return "shared secret", nil
+	}
+	lastRefresh := v.Keys.LastRefresh()
+	if lastRefresh.IsZero() {
+		return "", errors.New("keys never loaded")
+	}
+	age := now.Sub(lastRefresh).Round(time.Second)
+	if v.Keys.Interval > 0 && age > 3*v.Keys.Interval {
+		return "", fmt.Errorf("keys last refreshed %s ago", age)
+	}
+	return fmt.Sprintf("keys refreshed %s ago", age), nil
+}
+
+// checkMigrations checks the startup indexes exist
+func (a *App) checkMigrations(ctx context.Context) (string, error) {
+	if a.DB == nil {
+		return "", errors.New("not connected")
+	}
+
+	collections := make([]string, 0, len(schemaIndexes))
+	for collection := range schemaIndexes {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	for _, collection := range collections {
+		specs, err := a.GetCollection(collection).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			a.Log.Warn().Err(err).Str("collection", collection).Msg("Readiness check: listing indexes failed")
+			if errors.Is(err, context.DeadlineExceeded) {
+				return "", err
+			}
+			return "", fmt.Errorf("can't list %s indexes", collection)
+		}
+		existing := map[string]bool{}
+		for _, spec := range specs {
+			existing[spec.Name] = true
+		}
+		for _, name := range schemaIndexes[collection] {
+			if !existing[name] {
+				return "", fmt.Errorf("%s index %s missing", collection, name)
+			}
+		}
+	}
+	return "indexes in place", nil
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+func (a *App) readinessChecks() []HealthCheck {
+	return []HealthCheck{
+		{Name: "mongodb", Check: a.checkMongo},
+		{Name: "auth", Check: a.checkAuth},
+		{Name: "migrations", Check: a.checkMigrations},
+	}
+}
+
+// initialiseHealth reads the readiness probe settings
+func (a *App) initialiseHealth() {
+	config := a.config().Health
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid health check settings")
+	}
+	checker := NewHealthChecker(config.CacheTTL, config.Timeout, a.readinessChecks()...)
+	a.health = checker
+	a.Log.Info().Dur("cache_ttl", checker.CacheTTL).Dur("timeout", checker.Timeout).Msg("Readiness checks configured")
+}
+
+// healthChecker returns the app's readiness checker, taking its settings
+// from the config on first use
+func (a *App) healthChecker() *HealthChecker {
+	a.healthOnce.Do(func() {
+		if a.health != nil {
+			return
+		}
+		config := a.config().Health
+		if config == nil {
+			a.Log.Error().Msg("Invalid health check settings, using defaults")
+			config = &HealthConfig{CacheTTL: defaultHealthCacheTTL, Timeout: defaultHealthCheckTimeout}
+		}
+		a.health = NewHealthChecker(config.CacheTTL, config.Timeout, a.readinessChecks()...)
+	})
+	return a.health
+}
+
+//-----------------------------------------------------------------------------
+// Handlers
+
+// GetLiveness only says the process is serving requests. It doesn't look
+// at dependencies, so an outage doesn't get every pod restarted
+func (a *App) GetLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive", "version": a.config().Version})
+}
+
+// GetReadiness reports each dependency, with 503 if any is down. Once the
+// app is shutting down it fails straight away without running the checks
+func (a *App) GetReadiness(c *gin.Context) {
+	if a.Draining() {
+		c.JSON(http.StatusServiceUnavailable, &HealthReport{
+			Status:    "draining",
+			CheckedAt: time.Now().UTC(),
+			Checks:    map[string]HealthCheckResult{},
+		})
+		return
+	}
+
+	report := a.healthChecker().Report(c.Request.Context())
+	if !report.Ready() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
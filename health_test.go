@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker(t *testing.T) {
+	up := HealthCheck{Name: "up", Check: func(context.Context) (string, error) { return "fine", nil }}
+	down := HealthCheck{Name: "down", Check: func(context.Context) (string, error) { return "", errors.New("broken") }}
+
+	t.Run("should report each dependency", func(t *testing.T) {
+		report := NewHealthChecker(0, time.Second, up, down).Report(context.Background())
+		assert.False(t, report.Ready())
+		assert.Equal(t, "not_ready", report.Status)
+		assert.Equal(t, HealthCheckResult{Status: "up", Detail: "fine"}, withoutLatency(report.Checks["up"]))
+		assert.Equal(t, HealthCheckResult{Status: "down", Error: "broken"}, withoutLatency(report.Checks["down"]))
+
+		report = NewHealthChecker(0, time.Second, up).Report(context.Background())
+		assert.True(t, report.Ready())
+	})
+
+	t.Run("should cache the report", func(t *testing.T) {
+		var runs atomic.Int32
+		counted := HealthCheck{Name: "counted", Check: func(context.Context) (string, error) {
+			runs.Add(1)
+			return "", nil
+		}}
+		now := time.Now()
+		checker := NewHealthChecker(5*time.Second, time.Second, counted)
+		checker.now = func() time.Time { return now }
+
+		checker.Report(context.Background())
+		checker.Report(context.Background())
+		assert.Equal(t, int32(1), runs.Load())
+
+		now = now.Add(5 * time.Second)
+		checker.Report(context.Background())
+		assert.Equal(t, int32(2), runs.Load())
+	})
+
+	t.Run("should time out slow checks", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		stuck := HealthCheck{Name: "stuck", Check: func(context.Context) (string, error) {
+			// ignores ctx, as a badly behaved check might
+			<-release
+			return "", nil
+		}}
+		slow := HealthCheck{Name: "slow", Check: func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}}
+
+		start := time.Now()
+		report := NewHealthChecker(0, 20*time.Millisecond, stuck, slow, up).Report(context.Background())
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, "timed out", report.Checks["stuck"].Error)
+		assert.Equal(t, "timed out", report.Checks["slow"].Error)
+		assert.Equal(t, "up", report.Checks["up"].Status)
+	})
+
+	t.Run("should reject bad settings", func(t *testing.T) {
+		for key, raw := range map[string]string{"HEALTH_CACHE_TTL": "-1s", "HEALTH_CHECK_TIMEOUT": "0"} {
+			t.Setenv(key, raw)
+			_, err := NewHealthConfig(EnvSettings())
+			assert.Error(t, err, key)
+			t.Setenv(key, "")
+		}
+	})
+}
+
+func withoutLatency(r HealthCheckResult) HealthCheckResult {
+	r.LatencyMS = 0
+	return r
+}
+
+func TestAuthHealthChecks(t *testing.T) {
+	logger := zerolog.Nop()
+
+	t.Run("should check authy answers", func(t *testing.T) {
+		status := http.StatusUnauthorized
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		client := newTestAuthyClient(server)
+		app := &App{Log: &logger, Config: testConfig(t), Auth: NewCachingVerifier(NewAuthyVerifier(server.URL, client, &logger), NewTokenCache(10), time.Minute, time.Second)}
+
+		detail, err := app.checkAuth(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "reachable, circuit closed", detail)
+
+		status = http.StatusServiceUnavailable
+		_, err = app.checkAuth(context.Background())
+		assert.EqualError(t, err, "status 503")
+		// the probe doesn't count towards the breaker
+		assert.Equal(t, CircuitClosed, client.Breaker.State())
+
+		for i := 0; i < 3; i++ {
+			client.Breaker.Failure()
+		}
+		_, err = app.checkAuth(context.Background())
+		assert.EqualError(t, err, "circuit open")
+	})
+
+	t.Run("should check JWKS keys are fresh", func(t *testing.T) {
+		now := time.Now()
+		keys := NewJWKSKeySet("keys.json", 15*time.Minute, &logger)
+
+		_, err := checkJWKS(&JWTVerifier{Keys: keys}, now)
+		assert.EqualError(t, err, "keys never loaded")
+
+		keys.lastRefresh = now.Add(-20 * time.Minute)
+		detail, err := checkJWKS(&JWTVerifier{Keys: keys}, now)
+		require.NoError(t, err)
+		assert.Equal(t, "keys refreshed 20m0s ago", detail)
+
+		keys.lastRefresh = now.Add(-time.Hour)
+		_, err = checkJWKS(&JWTVerifier{Keys: keys}, now)
+		assert.EqualError(t, err, "keys last refreshed 1h0m0s ago")
+
+		detail, err = checkJWKS(&JWTVerifier{Secret: []byte("secret")}, now)
+		require.NoError(t, err)
+		assert.Equal(t, "shared secret", detail)
+	})
+}
+
+func TestHealthRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "1")
+	t.Setenv("VERSION", "test-1.0.0")
+
+	app := &App{Log: &logger, Config: testConfig(t), Auth: roleVerifier{}}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		return resp
+	}
+
+	t.Run("should be alive without dependencies", func(t *testing.T) {
+		resp := get("/list/health/live")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"status": "alive", "version": "test-1.0.0"}`, resp.Body.String())
+	})
+
+	t.Run("should not be ready without a database", func(t *testing.T) {
+		resp := get("/list/health/ready")
+		require.Equal(t, http.StatusServiceUnavailable, resp.Code)
+
+		var report HealthReport
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &report))
+		assert.Equal(t, "not_ready", report.Status)
+		assert.Equal(t, "not connected", report.Checks["mongodb"].Error)
+		assert.Equal(t, "not connected", report.Checks["migrations"].Error)
+		assert.Equal(t, "up", report.Checks["auth"].Status)
+	})
+
+	t.Run("should not be rate limited", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, get("/list/health/live").Code)
+		}
+		get("/list/status")
+		assert.Equal(t, http.StatusTooManyRequests, get("/list/status").Code)
+	})
+}
@@ -173,6 +173,45 @@ func GetValidListTypes() []string {
 	}
 }
 
+// GetListRouteTypes returns the list types that have routes under /list
+func GetListRouteTypes() []string {
+	return []string{
+		"watchlist",
+		"favourites",
+		"viewed",
+		"bids",
+		"purchased",
+	}
+}
+
+// IsListRouteType checks if a list type has routes under /list
+func IsListRouteType(listType string) bool {
+	for _, routeType := range GetListRouteTypes() {
+		if routeType == listType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetShareableListTypes returns the list types owners can share
+func GetShareableListTypes() []string {
+	return []string{
+		"watchlist",
+		"favourites",
+	}
+}
+
+// IsShareableListType checks if a list type can be shared
+func IsShareableListType(listType string) bool {
+	for _, shareable := range GetShareableListTypes() {
+		if shareable == listType {
+			return true
+		}
+	}
+	return false
+}
+
 // IsValidListType checks if a list type is supported
 func IsValidListType(listType string) bool {
 	validTypes := GetValidListTypes()
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// Item details
+// lists only store item UUIDs; an ItemProvider looks up the details the
+// frontend needs to render them
+
+// ItemDetails is the item as returned by the provider
+type ItemDetails map[string]interface{}
+
+// ItemProvider fetches details for a set of items. Items that couldn't be
+// fetched are reported in the error map rather than failing the whole call
+type ItemProvider interface {
+	GetItems(ctx context.Context, itemIDs []string) (map[string]ItemDetails, map[string]error)
+}
+
+var ErrItemNotFound = errors.New("item not found")
+
+// ExpandedItem is a list entry merged with its details or the reason they
+// could not be fetched
+type ExpandedItem struct {
+	ItemID  string      `json:"item_id"`
+	Details ItemDetails `json:"details,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+//-----------------------------------------------------------------------------
+// HTTP provider
+// POSTs {"item_ids": [...]} to URL and expects {"items": [{"item_id": ...}]}
+// back, splitting large lists into batches fetched in parallel
+
+// maxItemsResponseBytes caps what is read from one batch's response, so a
+// misbehaving items service can't run the lister out of memory
+const maxItemsResponseBytes = 4 << 20
+
+type HTTPItemProvider struct {
+	URL       string
+	BatchSize int
+	Client    *http.Client
+	// MaxResponseBytes caps each batch's response body. A larger response
+	// fails the batch
+	MaxResponseBytes int64
+}
+
+func NewHTTPItemProvider(url string, timeout time.Duration, batchSize int) *HTTPItemProvider {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &HTTPItemProvider{
+		URL:              url,
+		BatchSize:        batchSize,
+		Client:           &http.Client{Timeout: timeout},
+		MaxResponseBytes: maxItemsResponseBytes,
+	}
+}
+
+func (p *HTTPItemProvider) GetItems(ctx context.Context, itemIDs []string) (map[string]ItemDetails, map[string]error) {
+	found := make(map[string]ItemDetails)
+	failed := make(map[string]error)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, batch := range ChunkStrings(itemIDs, p.BatchSize) {
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			items, err := p.fetchBatch(ctx, batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range batch {
+				switch {
+				case err != nil:
+					failed[id] = err
+				case items[id] == nil:
+					failed[id] = ErrItemNotFound
+				default:
+					found[id] = items[id]
+				}
+			}
+		}(batch)
+	}
+
+	wg.Wait()
+	return found, failed
+}
+
+func (p *HTTPItemProvider) fetchBatch(ctx context.Context, batch []string) (map[string]ItemDetails, error) {
+	body, err := json.Marshal(map[string][]string{"item_ids": batch})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setRequestIDHeader(ctx, req)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("items service unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("items service returned status %d", resp.StatusCode)
+	}
+
+	// one byte past the cap is enough to tell the response is too large
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, p.MaxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("items service response error: %w", err)
+	}
+	if int64(len(raw)) > p.MaxResponseBytes {
+		return nil, fmt.Errorf("items service response larger than %d bytes", p.MaxResponseBytes)
+	}
+
+	var payload struct {
+		Items []ItemDetails `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("items service response error: %w", err)
+	}
+
+	items := make(map[string]ItemDetails, len(payload.Items))
+	for _, item := range payload.Items {
+		if id, ok := item["item_id"].(string); ok {
+			items[id] = item
+		}
+	}
+	return items, nil
+}
+
+//-----------------------------------------------------------------------------
+// Static provider
+// serves details from a JSON file of {"<item_id>": {...}} for local
+// development without the items service
+
+type StaticItemProvider struct {
+	items map[string]ItemDetails
+}
+
+func NewStaticItemProvider(path string) (*StaticItemProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var items map[string]ItemDetails
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("invalid items file %s: %w", path, err)
+	}
+	return &StaticItemProvider{items: items}, nil
+}
+
+func (p *StaticItemProvider) GetItems(_ context.Context, itemIDs []string) (map[string]ItemDetails, map[string]error) {
+	found := make(map[string]ItemDetails)
+	failed := make(map[string]error)
+	for _, id := range itemIDs {
+		if item, ok := p.items[id]; ok {
+			found[id] = item
+		} else {
+			failed[id] = ErrItemNotFound
+		}
+	}
+	return found, failed
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+// ItemsConfig holds the ITEM_PROVIDER and ITEMS_* settings
+type ItemsConfig struct {
+	Provider  string
+	URL       string
+	Timeout   time.Duration
+	BatchSize int
+	File      string
+}
+
+// NewItemsConfig reads ITEM_PROVIDER and the settings the chosen provider
+// needs
+func NewItemsConfig(s Settings) (*ItemsConfig, error) {
+	r := &configReader{s: s}
+	config := &ItemsConfig{
+		Provider:  strings.ToLower(s.Get("ITEM_PROVIDER")),
+		URL:       s.Get("ITEMS_URL"),
+		BatchSize: r.int("ITEMS_BATCH_SIZE", 20, 1),
+		Timeout:   r.duration("ITEMS_TIMEOUT", 2*time.Second),
+		File:      s.Get("ITEMS_FILE"),
+	}
+	if config.Timeout == 0 {
+		r.fail(fmt.Errorf("ITEMS_TIMEOUT must be more than 0"))
+	}
+
+	switch config.Provider {
+	case "":
+	case "http":
+		r.url("ITEMS_URL")
+	case "static":
+		r.required("ITEMS_FILE")
+	default:
+		r.fail(fmt.Errorf("ITEM_PROVIDER must be one of http, static"))
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// initialiseItemProvider sets up the provider selected by ITEM_PROVIDER;
+// with none configured ?expand=item is refused
+func (a *App) initialiseItemProvider() {
+	config := a.config().Items
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid item provider settings")
+	}
+	switch config.Provider {
+	case "":
+		a.Log.Info().Msg("No item provider configured")
+	case "http":
+		a.Items = NewHTTPItemProvider(config.URL, config.Timeout, config.BatchSize)
+		a.Log.Info().Msg("Using HTTP item provider")
+	case "static":
+		p, err := NewStaticItemProvider(config.File)
+		if err != nil {
+			a.Log.Fatal().Err(err).Msg("Failed to load static item provider")
+		}
+		a.Items = p
+		a.Log.Info().Msg("Using static item provider")
+	}
+}
+
+// wantsItemExpansion checks the expand query parameter, which accepts a comma
+// separated list of expansions - currently only item
+func wantsItemExpansion(c *gin.Context) (bool, error) {
+	raw := c.Query("expand")
+	if raw == "" {
+		return false, nil
+	}
+	expand := false
+	for _, e := range FilterEmptyStrings(strings.Split(raw, ",")) {
+		if strings.TrimSpace(e) != "item" {
+			return false, &ListQueryError{Field: "expand", Message: "must be item"}
+		}
+		expand = true
+	}
+	return expand, nil
+}
+
+// expandItems looks up details for the list's items, keeping list order
+func (a *App) expandItems(ctx context.Context, itemIDs []string) []ExpandedItem {
+	expanded := make([]ExpandedItem, len(itemIDs))
+	if len(itemIDs) == 0 {
+		return expanded
+	}
+
+	found, failed := a.Items.GetItems(ctx, UniqueStrings(itemIDs))
+	for i, id := range itemIDs {
+		expanded[i].ItemID = id
+		if details, ok := found[id]; ok {
+			expanded[i].Details = details
+			continue
+		}
+		err := failed[id]
+		if err == nil {
+			err = ErrItemNotFound
+		}
+		loggerFromContext(ctx, a.Log).Debug().Err(err).Str("item_id", id).Msg("Could not expand item")
+		if errors.Is(err, ErrItemNotFound) {
+			expanded[i].Error = ErrItemNotFound.Error()
+		} else {
+			expanded[i].Error = "item details unavailable"
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	itemTestID1 = "987fcdeb-51a2-43d7-890e-123456789abc"
+	itemTestID2 = "111e2222-3333-4444-5555-666677778888"
+	itemTestID3 = "2a99371f-4188-49b8-a628-85e946540364"
+)
+
+// fakeItemsService answers bulk item requests, leaving out itemTestID3
+func fakeItemsService(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		var req struct {
+			ItemIDs []string `json:"item_ids"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		items := make([]map[string]string, 0)
+		for _, id := range req.ItemIDs {
+			if id != itemTestID3 {
+				items = append(items, map[string]string{"item_id": id, "title": "Item " + id[:8]})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	}))
+}
+
+func TestHTTPItemProvider(t *testing.T) {
+	t.Run("should fetch items in batches", func(t *testing.T) {
+		var calls int32
+		server := fakeItemsService(t, &calls)
+		defer server.Close()
+
+		provider := NewHTTPItemProvider(server.URL, time.Second, 2)
+		found, failed := provider.GetItems(context.Background(), []string{itemTestID1, itemTestID2, itemTestID3})
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		assert.Len(t, found, 2)
+		assert.Equal(t, "Item 987fcdeb", found[itemTestID1]["title"])
+		assert.ErrorIs(t, failed[itemTestID3], ErrItemNotFound)
+	})
+
+	t.Run("should report every item in a failed batch", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		provider := NewHTTPItemProvider(server.URL, time.Second, 10)
+		found, failed := provider.GetItems(context.Background(), []string{itemTestID1, itemTestID2})
+
+		assert.Empty(t, found)
+		assert.Len(t, failed, 2)
+		assert.Contains(t, failed[itemTestID1].Error(), "502")
+	})
+
+	t.Run("should fail a batch whose response is too large", func(t *testing.T) {
+		var calls int32
+		server := fakeItemsService(t, &calls)
+		defer server.Close()
+
+		provider := NewHTTPItemProvider(server.URL, time.Second, 10)
+		provider.MaxResponseBytes = 64
+		found, failed := provider.GetItems(context.Background(), []string{itemTestID1, itemTestID2})
+
+		assert.Empty(t, found)
+		assert.Len(t, failed, 2)
+		assert.Contains(t, failed[itemTestID1].Error(), "larger than 64 bytes")
+	})
+
+	t.Run("should time out slow responses", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		provider := NewHTTPItemProvider(server.URL, 20*time.Millisecond, 10)
+		_, failed := provider.GetItems(context.Background(), []string{itemTestID1})
+
+		require.Contains(t, failed, itemTestID1)
+		assert.Contains(t, failed[itemTestID1].Error(), "items service unavailable")
+	})
+}
+
+func TestStaticItemProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	err := os.WriteFile(path, []byte(`{"`+itemTestID1+`": {"title": "Teapot"}}`), 0644)
+	require.NoError(t, err)
+
+	t.Run("should serve items from the file", func(t *testing.T) {
+		provider, err := NewStaticItemProvider(path)
+		require.NoError(t, err)
+
+		found, failed := provider.GetItems(context.Background(), []string{itemTestID1, itemTestID2})
+		assert.Equal(t, "Teapot", found[itemTestID1]["title"])
+		assert.ErrorIs(t, failed[itemTestID2], ErrItemNotFound)
+	})
+
+	t.Run("should fail on a missing file", func(t *testing.T) {
+		_, err := NewStaticItemProvider(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+}
+
+func TestExpandItems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	var calls int32
+	server := fakeItemsService(t, &calls)
+	defer server.Close()
+
+	app := &App{Log: &logger, Config: testConfig(t), Items: NewHTTPItemProvider(server.URL, time.Second, 20)}
+
+	t.Run("should merge details in list order and report missing items", func(t *testing.T) {
+		expanded := app.expandItems(context.Background(), []string{itemTestID3, itemTestID1})
+
+		require.Len(t, expanded, 2)
+		assert.Equal(t, itemTestID3, expanded[0].ItemID)
+		assert.Nil(t, expanded[0].Details)
+		assert.Equal(t, ErrItemNotFound.Error(), expanded[0].Error)
+
+		assert.Equal(t, itemTestID1, expanded[1].ItemID)
+		assert.Equal(t, "Item 987fcdeb", expanded[1].Details["title"])
+		assert.Empty(t, expanded[1].Error)
+	})
+
+	t.Run("should parse the expand parameter", func(t *testing.T) {
+		parse := func(url string) (bool, error) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", url, nil)
+			return wantsItemExpansion(c)
+		}
+
+		expand, err := parse("/list/watchlist?expand=item")
+		require.NoError(t, err)
+		assert.True(t, expand)
+
+		expand, err = parse("/list/watchlist")
+		require.NoError(t, err)
+		assert.False(t, expand)
+
+		_, err = parse("/list/watchlist?expand=seller")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/singleflight"
+)
+
+//-----------------------------------------------------------------------------
+// JWT verifier
+// verifies access tokens locally instead of calling authy. HS256 tokens are
+// checked with a shared secret and RS256/ES256 tokens with keys from a JWKS
+
+type JWTVerifier struct {
+	Secret        []byte
+	Keys          *JWKSKeySet
+	PublicIDClaim string
+	RolesClaim    string
+	Audience      string
+	Issuer        string
+	Leeway        time.Duration
+	log           *zerolog.Logger
+}
+
+// JWTConfig holds the JWT_* settings
+type JWTConfig struct {
+	Secret        string
+	JWKSURL       string
+	JWKSFile      string
+	JWKSRefresh   time.Duration
+	PublicIDClaim string
+	RolesClaim    string
+	Audience      string
+	Issuer        string
+	Leeway        time.Duration
+}
+
+// NewJWTConfig reads the JWT_* settings. At least one of JWT_SECRET,
+// JWT_JWKS_FILE or JWT_JWKS_URL must be set, and only one of the two key
+// sources
+func NewJWTConfig(s Settings) (*JWTConfig, error) {
+	r := &configReader{s: s}
+	config := &JWTConfig{
+		Secret:        s.Get("JWT_SECRET"),
+		JWKSURL:       s.Get("JWT_JWKS_URL"),
+		JWKSFile:      s.Get("JWT_JWKS_FILE"),
+		JWKSRefresh:   r.duration("JWT_JWKS_REFRESH", 15*time.Minute),
+		PublicIDClaim: s.GetOrDefault("JWT_PUBLIC_ID_CLAIM", "public_id"),
+		RolesClaim:    s.GetOrDefault("JWT_ROLES_CLAIM", "roles"),
+		Audience:      s.Get("JWT_AUDIENCE"),
+		Issuer:        s.Get("JWT_ISSUER"),
+		Leeway:        r.duration("JWT_LEEWAY", 30*time.Second),
+	}
+	if config.Secret == "" && config.JWKSURL == "" && config.JWKSFile == "" {
+		r.fail(errors.New("AUTH_MODE jwt needs JWT_SECRET, JWT_JWKS_FILE or JWT_JWKS_URL"))
+	}
+	if config.JWKSURL != "" && config.JWKSFile != "" {
+		r.fail(errors.New("JWT_JWKS_URL and JWT_JWKS_FILE must not both be set"))
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewJWTVerifier builds a verifier from the JWT settings, loading the JWKS
+// once before returning so a bad key source fails at startup
+func NewJWTVerifier(config *JWTConfig, log *zerolog.Logger) (*JWTVerifier, error) {
+	v := &JWTVerifier{
+		Secret:        []byte(config.Secret),
+		PublicIDClaim: config.PublicIDClaim,
+		RolesClaim:    config.RolesClaim,
+		Audience:      config.Audience,
+		Issuer:        config.Issuer,
+		Leeway:        config.Leeway,
+		log:           log,
+	}
+
+	source := config.JWKSURL
+	if source == "" {
+		source = config.JWKSFile
+	}
+	if source != "" {
+		v.Keys = NewJWKSKeySet(source, config.JWKSRefresh, log)
+		if err := v.Keys.Refresh(context.Background()); err != nil {
+			return nil, err
+		}
+		v.Keys.Start()
+	}
+
+	if len(v.Secret) == 0 && v.Keys == nil {
+		return nil, errors.New("JWT mode needs JWT_SECRET, JWT_JWKS_FILE or JWT_JWKS_URL")
+	}
+
+	return v, nil
+}
+
+// methods returns the signing algorithms this verifier has keys for
+func (v *JWTVerifier) methods() []string {
+	var methods []string
+	if len(v.Secret) > 0 {
+		methods = append(methods, "HS256")
+	}
+	if v.Keys != nil {
+		methods = append(methods, "RS256", "ES256")
+	}
+	return methods
+}
+
+// keyFunc looks up the key for a token. ctx bounds any JWKS refetch an
+// unknown key causes
+func (v *JWTVerifier) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.Alg() {
+		case "HS256":
+			return v.Secret, nil
+		case "RS256", "ES256":
+			kid, _ := token.Header["kid"].(string)
+			return v.Keys.Key(ctx, kid)
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+func (v *JWTVerifier) Verify(ctx context.Context, accessToken string) (*Identity, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.Leeway),
+	}
+	if v.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.Audience))
+	}
+	if v.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.Issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(accessToken, claims, v.keyFunc(ctx), opts...); err != nil {
+		v.log.Info().Err(err).Msg("JWT verification failed")
+		return nil, errInvalidToken(err)
+	}
+
+	publicID, _ := claims[v.PublicIDClaim].(string)
+	if !IsValidUUID(publicID) {
+		v.log.Info().Str("claim", v.PublicIDClaim).Msg("JWT has missing or invalid public_id claim")
+		return nil, errInvalidToken(nil)
+	}
+
+	identity := &Identity{PublicID: publicID}
+	if v.RolesClaim != "" {
+		identity.Roles = parseRoles(claims[v.RolesClaim])
+	}
+	return identity, nil
+}
+
+//-----------------------------------------------------------------------------
+// JWKS key set
+// loads RSA and EC public keys from a JWKS file or URL and refreshes them
+// periodically so rotated keys are picked up without a restart
+
+type JWKSKeySet struct {
+	Source   string
+	Interval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+	lastAttempt time.Time
+
+	refetch singleflight.Group
+	client  *http.Client
+	stop    chan struct{}
+	log     *zerolog.Logger
+}
+
+// minJWKSRefetch stops unknown key IDs from triggering a refetch on every
+// request
+const minJWKSRefetch = 30 * time.Second
+
+// jwksRefetchTimeout bounds the refetch an unknown key causes, so a slow key
+// source holds up the request for at most this long
+const jwksRefetchTimeout = 3 * time.Second
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+func NewJWKSKeySet(source string, interval time.Duration, log *zerolog.Logger) *JWKSKeySet {
+	return &JWKSKeySet{
+		Source:   source,
+		Interval: interval,
+		keys:     map[string]crypto.PublicKey{},
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+	}
+}
+
+// Key returns the public key for kid, refetching the set once if the key is
+// unknown in case it has just been rotated in. Concurrent lookups of unknown
+// keys share one refetch
+func (ks *JWKSKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	_, err, _ := ks.refetch.Do("refetch", func() (interface{}, error) {
+		if !ks.claimRefetch() {
+			return nil, nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, jwksRefetchTimeout)
+		defer cancel()
+		return nil, ks.load(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (ks *JWKSKeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		// tokens without a kid can only mean the one key we have
+		for _, key = range ks.keys {
+			ok = true
+		}
+	}
+	return key, ok
+}
+
+// claimRefetch reports whether an unknown key may cause a refetch now,
+// recording the attempt if so
+func (ks *JWKSKeySet) claimRefetch() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if time.Since(ks.lastAttempt) < minJWKSRefetch {
+		return false
+	}
+	ks.lastAttempt = time.Now()
+	return true
+}
+
+// LastRefresh returns when the keys were last loaded successfully
+func (ks *JWKSKeySet) LastRefresh() time.Time {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.lastRefresh
+}
+
+// Refresh reloads the key set from its source
+func (ks *JWKSKeySet) Refresh(ctx context.Context) error {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+	return ks.load(ctx)
+}
+
+func (ks *JWKSKeySet) load(ctx context.Context) error {
+	b, err := ks.read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS from %s: %w", ks.Source, err)
+	}
+
+	keys, err := ParseJWKS(b)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.lastRefresh = time.Now()
+	ks.mu.Unlock()
+
+	ks.log.Info().Int("keys", len(keys)).Msg("Loaded JWKS")
+	return nil
+}
+
+func (ks *JWKSKeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.Source, "http://") && !strings.HasPrefix(ks.Source, "https://") {
+		return os.ReadFile(ks.Source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Start refreshes the key set every Interval until Stop is called
+func (ks *JWKSKeySet) Start() {
+	if ks.Interval <= 0 || ks.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	ks.stop = stop
+	go func() {
+		ticker := time.NewTicker(ks.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.Refresh(context.Background()); err != nil {
+					ks.log.Error().Err(err).Msg("JWKS refresh failed - keeping previous keys")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ks *JWKSKeySet) Stop() {
+	if ks.stop != nil {
+		close(ks.stop)
+		ks.stop = nil
+	}
+}
+
+//-----------------------------------------------------------------------------
+// JWKS parsing
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the signing keys in a JWKS document keyed by kid. Keys
+// of unsupported types are skipped
+func ParseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaKey()
+		case "EC":
+			key, err = jwk.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %s: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (jwk jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (jwk jsonWebKey) ecKey() (*ecdsa.PublicKey, error) {
+	if jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+	}
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	jwtTestSecret   = "jwt-test-secret"
+	jwtTestPublicID = "123e4567-e89b-12d3-a456-426614174000"
+	jwtTestAudience = "poptape-lister"
+)
+
+func b64BigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// writeTestJWKS writes a JWKS holding an RSA and an EC key and returns its path
+func writeTestJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	t.Helper()
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa-1",
+				"use": "sig",
+				"n":   b64BigInt(rsaKey.N),
+				"e":   b64BigInt(big.NewInt(int64(rsaKey.E))),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec-1",
+				"crv": "P-256",
+				"x":   b64BigInt(ecKey.X),
+				"y":   b64BigInt(ecKey.Y),
+			},
+		},
+	}
+	b, err := json.Marshal(jwks)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	return path
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"public_id": jwtTestPublicID,
+		"aud":       jwtTestAudience,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keys := NewJWKSKeySet(writeTestJWKS(t, rsaKey, ecKey), 0, &logger)
+	require.NoError(t, keys.Refresh(context.Background()))
+
+	verifier := &JWTVerifier{
+		Secret:        []byte(jwtTestSecret),
+		Keys:          keys,
+		PublicIDClaim: "public_id",
+		Audience:      jwtTestAudience,
+		log:           &logger,
+	}
+
+	assertRejected := func(t *testing.T, token string) {
+		t.Helper()
+		_, err := verifier.Verify(context.Background(), token)
+		require.Error(t, err)
+		var authErr *AuthError
+		require.True(t, errors.As(err, &authErr))
+		assert.Equal(t, http.StatusUnauthorized, authErr.Status)
+	}
+
+	t.Run("should accept HS256 tokens signed with the shared secret", func(t *testing.T) {
+		token := signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), validClaims())
+		identity, err := verifier.Verify(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, jwtTestPublicID, identity.PublicID)
+	})
+
+	t.Run("should accept RS256 and ES256 tokens signed with JWKS keys", func(t *testing.T) {
+		token := signTestToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims())
+		identity, err := verifier.Verify(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, jwtTestPublicID, identity.PublicID)
+
+		token = signTestToken(t, jwt.SigningMethodES256, "ec-1", ecKey, validClaims())
+		identity, err = verifier.Verify(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, jwtTestPublicID, identity.PublicID)
+	})
+
+	t.Run("should reject tokens signed with the wrong secret", func(t *testing.T) {
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte("wrong"), validClaims()))
+	})
+
+	t.Run("should reject tokens with an unknown key id", func(t *testing.T) {
+		assertRejected(t, signTestToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, validClaims()))
+	})
+
+	t.Run("should reject unsupported algorithms", func(t *testing.T) {
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS512, "", []byte(jwtTestSecret), validClaims()))
+		assertRejected(t, signTestToken(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, validClaims()))
+	})
+
+	t.Run("should reject expired and not yet valid tokens", func(t *testing.T) {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+
+		claims = validClaims()
+		claims["nbf"] = time.Now().Add(time.Hour).Unix()
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+
+		claims = validClaims()
+		delete(claims, "exp")
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+	})
+
+	t.Run("should reject the wrong audience", func(t *testing.T) {
+		claims := validClaims()
+		claims["aud"] = "someone-else"
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+	})
+
+	t.Run("should reject missing or invalid public_id claims", func(t *testing.T) {
+		claims := validClaims()
+		delete(claims, "public_id")
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+
+		claims = validClaims()
+		claims["public_id"] = "not-a-uuid"
+		assertRejected(t, signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+	})
+
+	t.Run("should read public_id from a configured claim", func(t *testing.T) {
+		v := *verifier
+		v.PublicIDClaim = "sub"
+		claims := validClaims()
+		delete(claims, "public_id")
+		claims["sub"] = jwtTestPublicID
+
+		identity, err := v.Verify(context.Background(), signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), claims))
+		require.NoError(t, err)
+		assert.Equal(t, jwtTestPublicID, identity.PublicID)
+	})
+}
+
+func TestJWKSKeySet(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	path := writeTestJWKS(t, rsaKey, ecKey)
+
+	t.Run("should load keys from a URL", func(t *testing.T) {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(b)
+		}))
+		defer server.Close()
+
+		keys := NewJWKSKeySet(server.URL, 0, &logger)
+		require.NoError(t, keys.Refresh(context.Background()))
+
+		key, err := keys.Key(context.Background(), "rsa-1")
+		require.NoError(t, err)
+		assert.Equal(t, rsaKey.N, key.(*rsa.PublicKey).N)
+		assert.False(t, keys.LastRefresh().IsZero())
+	})
+
+	t.Run("should share one refetch between lookups of unknown keys", func(t *testing.T) {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(20 * time.Millisecond)
+			_, _ = w.Write(b)
+		}))
+		defer server.Close()
+
+		keys := NewJWKSKeySet(server.URL, 0, &logger)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := keys.Key(context.Background(), "rotated-in")
+				assert.ErrorIs(t, err, ErrUnknownKey)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+		// and not again straight after
+		_, err = keys.Key(context.Background(), "rotated-in")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("should give up the refetch with the request", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		keys := NewJWKSKeySet(server.URL, 0, &logger)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := keys.Key(ctx, "rotated-in")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("should refresh in the background until stopped", func(t *testing.T) {
+		keys := NewJWKSKeySet(path, 10*time.Millisecond, &logger)
+		keys.Start()
+		defer keys.Stop()
+
+		assert.Eventually(t, func() bool {
+			return !keys.LastRefresh().IsZero()
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should reject invalid documents", func(t *testing.T) {
+		_, err := ParseJWKS([]byte(`{"keys": [{"kty": "RSA", "kid": "bad", "n": "!!", "e": "AQAB"}]}`))
+		assert.Error(t, err)
+
+		_, err = ParseJWKS([]byte(`not json`))
+		assert.Error(t, err)
+	})
+}
+
+func TestAuthMiddlewareWithJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.Auth = &JWTVerifier{Secret: []byte(jwtTestSecret), PublicIDClaim: "public_id", log: &logger}
+
+	router := gin.New()
+	router.Use(app.AuthMiddleware())
+	router.GET("/test", func(c *gin.Context) {
+		publicID, _ := c.Get("public_id")
+		c.JSON(http.StatusOK, gin.H{"public_id": publicID})
+	})
+
+	t.Run("should authenticate without calling authy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Access-Token", signTestToken(t, jwt.SigningMethodHS256, "", []byte(jwtTestSecret), validClaims()))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), jwtTestPublicID)
+	})
+
+	t.Run("should reject invalid tokens with 401", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Access-Token", "not.a.jwt")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Invalid or expired token")
+	})
+}
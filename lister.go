@@ -1,72 +1,48 @@
 package main
 
 import (
-	"fmt"
-	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"log"
 	"os"
-	"strings"
-	"time"
 )
 
 func main() {
 
-	var err error
-	err = godotenv.Load()
+	// read every setting up front and report everything that's wrong at once
+	config, err := LoadConfig(".env")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	// setup logging - for some reason we have to do this here
-	// when abstracted into another method it doesn't seem to work
-	var logFile *os.File
-
-	filePathName := os.Getenv("LOGFILE")
-	logFile, err = os.OpenFile(filePathName, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
+	// mask tokens and pseudonymise public_ids before anything is written
+	redactor, generatedKey, err := NewRedactorFromConfig(config.Redaction)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func(logFile *os.File) {
-		err := logFile.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}(logFile)
 
-	// format logline
-	cw := zerolog.ConsoleWriter{Out: logFile, NoColor: true, TimeFormat: time.RFC3339}
-	cw.FormatLevel = func(i interface{}) string {
-		return strings.ToUpper(fmt.Sprintf("[ %-6s]", i))
-	}
-	cw.TimeFormat = "[" + time.RFC3339 + "] - "
-	cw.FormatCaller = func(i interface{}) string {
-		str, _ := i.(string)
-		return fmt.Sprintf("['%s']", str)
-	}
-	cw.PartsOrder = []string{
-		zerolog.LevelFieldName,
-		zerolog.TimestampFieldName,
-		zerolog.MessageFieldName,
-		zerolog.CallerFieldName,
-	}
+	zerolog.SetGlobalLevel(config.Log.Level)
 
 	// create log
-	logger := zerolog.New(cw).With().Timestamp().Caller().Logger()
+	logger, logCloser, warning := NewLogger(config.Log, os.Stdout, redactor)
+	defer func() {
+		if err := logCloser.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 	logger.Info().Msg("-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-")
-	logger.Info().Msg("Logging setup successfully")
-	if os.Getenv("LOGLEVEL") == "debug" {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else if os.Getenv("LOGLEVEL") == "info" {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	logger.Info().Str("output", config.Log.Output).Str("format", config.Log.Format).Msg("Logging setup successfully")
+	if warning != "" {
+		logger.Warn().Msg(warning)
+	}
+	if generatedKey {
+		logger.Warn().Msg("LOG_REDACTION_KEY not set - public_id pseudonyms will change on restart")
 	}
+	logger.Info().Fields(config.Summary()).Msg("Configuration loaded")
 
 	// create and initialise app
-	a := App{}
+	a := App{Config: config}
 	a.Log = &logger
 	a.InitialiseApp()
-	a.Run(":" + os.Getenv("PORT"))
+	a.Run(":" + config.Port)
 
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//-----------------------------------------------------------------------------
+// ListQuery holds the optional time range, sort and paging parameters
+// accepted by list reads. A zero ListQuery means the list is returned as
+// stored
+
+type ListQuery struct {
+	Since     *time.Time
+	Until     *time.Time
+	Sorted    bool
+	Ascending bool
+	Limit     int
+	Cursor    string
+	After     *ListCursor
+}
+
+// IsEmpty reports whether no filtering, sorting or paging was requested
+func (q ListQuery) IsEmpty() bool {
+	return q.Since == nil && q.Until == nil && !q.Sorted && !q.Paginated()
+}
+
+// Paginated reports whether the client asked for a page of the list
+func (q ListQuery) Paginated() bool {
+	return q.Limit > 0 || q.Cursor != ""
+}
+
+// ListQueryError describes a query parameter that could not be used
+type ListQueryError struct {
+	Field   string
+	Message string
+}
+
+func (e *ListQueryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ParseListQuery reads since, until, sort, order, limit and cursor from the
+// query string. sort accepts added_at (oldest first) or -added_at (newest
+// first) and order accepts asc or desc, overriding the direction given by
+// sort. limit defaults to defaultLimit and is capped at maxLimit. The cursor
+// is kept opaque here and decoded by the handler
+func ParseListQuery(c *gin.Context, defaultLimit, maxLimit int) (ListQuery, error) {
+	var q ListQuery
+
+	for _, field := range []string{"since", "until"} {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+		t, err := ParseRFC3339(raw)
+		if err != nil {
+			return q, &ListQueryError{Field: field, Message: "must be an RFC3339 timestamp"}
+		}
+		if field == "since" {
+			q.Since = &t
+		} else {
+			q.Until = &t
+		}
+	}
+
+	if q.Since != nil && q.Until != nil && !q.Since.Before(*q.Until) {
+		return q, &ListQueryError{Field: "until", Message: "must be after since"}
+	}
+
+	switch sort := c.Query("sort"); sort {
+	case "":
+	case "added_at":
+		q.Sorted = true
+		q.Ascending = true
+	case "-added_at":
+		q.Sorted = true
+	default:
+		return q, &ListQueryError{Field: "sort", Message: "must be added_at or -added_at"}
+	}
+
+	switch order := c.Query("order"); order {
+	case "":
+	case "asc", "desc":
+		q.Sorted = true
+		q.Ascending = order == "asc"
+	default:
+		return q, &ListQueryError{Field: "order", Message: "must be asc or desc"}
+	}
+
+	q.Cursor = c.Query("cursor")
+	if limitStr, ok := c.GetQuery("limit"); ok || q.Cursor != "" {
+		limit, err := ValidateLimit(limitStr, defaultLimit, maxLimit)
+		if err != nil {
+			return q, &ListQueryError{Field: "limit", Message: "must be a positive integer"}
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
+
+//-----------------------------------------------------------------------------
+// Aggregation over a user's list array
+
+// listEntryResult is a ListEntry as returned by the aggregation, along with
+// its position counted from the oldest end of the (filtered) list
+type listEntryResult struct {
+	ListEntry `bson:",inline"`
+	Position  int `bson:"position"`
+}
+
+// buildListEntriesPipeline returns a pipeline producing one entry per item in
+// the user's list that matches the query. Entries are ordered by added_at,
+// newest first unless ascending, with position breaking ties so lists
+// without per-item timestamps keep their stored order
+func buildListEntriesPipeline(publicID string, q ListQuery) mongo.Pipeline {
+
+	// documents written before entries existed fall back to updated_at
+	entries := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$entries", bson.A{}}}}, 0}},
+		"$entries",
+		bson.M{"$map": bson.M{
+			"input": "$item_ids",
+			"as":    "id",
+			"in":    bson.M{"item_id": "$$id", "added_at": "$updated_at"},
+		}},
+	}}
+
+	conditions := bson.A{}
+	if q.Since != nil {
+		conditions = append(conditions, bson.M{"$gte": bson.A{"$$entry.added_at", *q.Since}})
+	}
+	if q.Until != nil {
+		conditions = append(conditions, bson.M{"$lt": bson.A{"$$entry.added_at", *q.Until}})
+	}
+
+	var selected interface{} = entries
+	if len(conditions) > 0 {
+		selected = bson.M{"$filter": bson.M{
+			"input": entries,
+			"as":    "entry",
+			"cond":  bson.M{"$and": conditions},
+		}}
+	}
+
+	direction := -1
+	if q.Ascending {
+		direction = 1
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": publicID}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "entries": selected}}},
+		{{Key: "$addFields", Value: bson.M{"count": bson.M{"$size": "$entries"}}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$entries", "includeArrayIndex": "index"}}},
+		{{Key: "$project", Value: bson.M{
+			"item_id":  "$entries.item_id",
+			"added_at": "$entries.added_at",
+			"position": bson.M{"$subtract": bson.A{"$count", bson.M{"$add": bson.A{"$index", 1}}}},
+		}}},
+	}
+
+	if q.After != nil {
+		op := "$lt"
+		if q.Ascending {
+			op = "$gt"
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"added_at": bson.M{op: q.After.AddedAt}},
+			bson.M{"added_at": q.After.AddedAt, "position": bson.M{op: q.After.Position}},
+		}}}})
+	}
+
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{
+		{Key: "added_at", Value: direction},
+		{Key: "position", Value: direction},
+	}}})
+
+	// fetch one extra entry to find out whether there is another page
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit + 1}})
+	}
+
+	return pipeline
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func parseQueryString(t *testing.T, qs string) (ListQuery, error) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/list/watchlist?"+qs, nil)
+	return ParseListQuery(c, 25, 50)
+}
+
+func TestParseListQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("should return an empty query with no parameters", func(t *testing.T) {
+		q, err := parseQueryString(t, "")
+		require.NoError(t, err)
+		assert.True(t, q.IsEmpty())
+	})
+
+	t.Run("should parse since and until", func(t *testing.T) {
+		q, err := parseQueryString(t, "since=2024-01-01T00:00:00Z&until=2024-02-01T00:00:00Z")
+		require.NoError(t, err)
+		require.NotNil(t, q.Since)
+		require.NotNil(t, q.Until)
+		assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), q.Since.UTC())
+		assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), q.Until.UTC())
+		assert.False(t, q.IsEmpty())
+	})
+
+	t.Run("should reject invalid timestamps", func(t *testing.T) {
+		_, err := parseQueryString(t, "since=yesterday")
+		require.Error(t, err)
+		assert.Equal(t, "since", err.(*ListQueryError).Field)
+	})
+
+	t.Run("should reject until before since", func(t *testing.T) {
+		_, err := parseQueryString(t, "since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z")
+		require.Error(t, err)
+		assert.Equal(t, "until", err.(*ListQueryError).Field)
+	})
+
+	t.Run("should parse sort directions", func(t *testing.T) {
+		q, err := parseQueryString(t, "sort=added_at")
+		require.NoError(t, err)
+		assert.True(t, q.Sorted)
+		assert.True(t, q.Ascending)
+
+		q, err = parseQueryString(t, "sort=-added_at")
+		require.NoError(t, err)
+		assert.True(t, q.Sorted)
+		assert.False(t, q.Ascending)
+	})
+
+	t.Run("should let order override sort direction", func(t *testing.T) {
+		q, err := parseQueryString(t, "sort=-added_at&order=asc")
+		require.NoError(t, err)
+		assert.True(t, q.Ascending)
+
+		q, err = parseQueryString(t, "order=desc")
+		require.NoError(t, err)
+		assert.True(t, q.Sorted)
+		assert.False(t, q.Ascending)
+	})
+
+	t.Run("should parse limit and cursor", func(t *testing.T) {
+		q, err := parseQueryString(t, "limit=10")
+		require.NoError(t, err)
+		assert.Equal(t, 10, q.Limit)
+		assert.True(t, q.Paginated())
+
+		q, err = parseQueryString(t, "cursor=abc")
+		require.NoError(t, err)
+		assert.Equal(t, "abc", q.Cursor)
+		assert.Equal(t, 25, q.Limit)
+
+		q, err = parseQueryString(t, "limit=1000")
+		require.NoError(t, err)
+		assert.Equal(t, 50, q.Limit)
+
+		_, err = parseQueryString(t, "limit=0")
+		require.Error(t, err)
+		assert.Equal(t, "limit", err.(*ListQueryError).Field)
+	})
+
+	t.Run("should reject unknown sort and order values", func(t *testing.T) {
+		_, err := parseQueryString(t, "sort=name")
+		require.Error(t, err)
+		assert.Equal(t, "sort", err.(*ListQueryError).Field)
+
+		_, err = parseQueryString(t, "order=sideways")
+		require.Error(t, err)
+		assert.Equal(t, "order", err.(*ListQueryError).Field)
+	})
+}
+
+func TestBuildListEntriesPipeline(t *testing.T) {
+	publicID := "123e4567-e89b-12d3-a456-426614174000"
+
+	stage := func(pipeline mongo.Pipeline, name string) interface{} {
+		for _, st := range pipeline {
+			if st[0].Key == name {
+				return st[0].Value
+			}
+		}
+		return nil
+	}
+
+	t.Run("should match the user and order newest first by default", func(t *testing.T) {
+		pipeline := buildListEntriesPipeline(publicID, ListQuery{})
+
+		assert.Equal(t, "$match", pipeline[0][0].Key)
+		assert.Equal(t, bson.M{"_id": publicID}, pipeline[0][0].Value)
+		assert.Equal(t, bson.D{
+			{Key: "added_at", Value: -1},
+			{Key: "position", Value: -1},
+		}, stage(pipeline, "$sort"))
+		assert.Nil(t, stage(pipeline, "$limit"))
+	})
+
+	t.Run("should filter entries by time range", func(t *testing.T) {
+		since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		pipeline := buildListEntriesPipeline(publicID, ListQuery{Since: &since})
+
+		project := pipeline[1][0].Value.(bson.M)
+		filter, ok := project["entries"].(bson.M)["$filter"].(bson.M)
+		require.True(t, ok)
+		assert.Equal(t, bson.M{"$and": bson.A{bson.M{"$gte": bson.A{"$$entry.added_at", since}}}}, filter["cond"])
+	})
+
+	t.Run("should sort oldest first when ascending", func(t *testing.T) {
+		pipeline := buildListEntriesPipeline(publicID, ListQuery{Sorted: true, Ascending: true})
+		assert.Equal(t, bson.D{
+			{Key: "added_at", Value: 1},
+			{Key: "position", Value: 1},
+		}, stage(pipeline, "$sort"))
+	})
+
+	t.Run("should start after the cursor and fetch one extra entry", func(t *testing.T) {
+		after := &ListCursor{AddedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Position: 7}
+		pipeline := buildListEntriesPipeline(publicID, ListQuery{Limit: 10, After: after})
+
+		assert.Equal(t, 11, stage(pipeline, "$limit"))
+
+		var match bson.M
+		for _, st := range pipeline[1:] {
+			if st[0].Key == "$match" {
+				match = st[0].Value.(bson.M)
+			}
+		}
+		require.NotNil(t, match)
+		assert.Equal(t, bson.A{
+			bson.M{"added_at": bson.M{"$lt": after.AddedAt}},
+			bson.M{"added_at": after.AddedAt, "position": bson.M{"$lt": 7}},
+		}, match["$or"])
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+//-----------------------------------------------------------------------------
+// Log output
+// LOG_OUTPUT sends logs to stdout, a file or both, in LOG_FORMAT json (the
+// default) or console. File output goes to LOGFILE and is rotated by size
+// and age. Whatever the sinks, every event passes through the redactor
+// first
+
+const (
+	defaultLogMaxSizeMB  = 100
+	defaultLogMaxAgeDays = 14
+	defaultLogMaxBackups = 5
+)
+
+type LogConfig struct {
+	Output          string
+	Format          string
+	Level           zerolog.Level
+	ComponentLevels map[string]zerolog.Level
+	File            string
+	MaxSizeMB       int
+	MaxAgeDays      int
+	MaxBackups      int
+	Compress        bool
+}
+
+// NewLogConfig reads LOG_OUTPUT, LOG_FORMAT, LOGLEVEL,
+// LOG_COMPONENT_LEVELS, LOGFILE and the LOG_MAX_* rotation settings
+func NewLogConfig(s Settings) (*LogConfig, error) {
+	r := &configReader{s: s}
+	config := &LogConfig{
+		Output:     r.oneOf("LOG_OUTPUT", "stdout", "stdout", "file", "both"),
+		Format:     r.oneOf("LOG_FORMAT", "json", "json", "console"),
+		File:       s.Get("LOGFILE"),
+		MaxSizeMB:  r.int("LOG_MAX_SIZE_MB", defaultLogMaxSizeMB, 1),
+		MaxAgeDays: r.int("LOG_MAX_AGE_DAYS", defaultLogMaxAgeDays, 0),
+		MaxBackups: r.int("LOG_MAX_BACKUPS", defaultLogMaxBackups, 0),
+		Compress:   r.bool("LOG_COMPRESS", true),
+	}
+
+	level, err := parseLogLevel(s.Get("LOGLEVEL"))
+	if err != nil {
+		r.fail(fmt.Errorf("invalid LOGLEVEL: %w", err))
+	}
+	config.Level = level
+	config.ComponentLevels, err = ParseComponentLevels(s.Get("LOG_COMPONENT_LEVELS"))
+	if err != nil {
+		r.fail(fmt.Errorf("invalid LOG_COMPONENT_LEVELS: %w", err))
+	}
+
+	if config.Output != "stdout" && config.File == "" {
+		r.fail(fmt.Errorf("LOG_OUTPUT %s needs LOGFILE", config.Output))
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewLogger builds the app logger writing to stdout and/or the log file.
+// A log file that can't be opened falls back to stdout rather than
+// stopping the service; the returned warning says why. The closer closes
+// the log file
+func NewLogger(config *LogConfig, stdout io.Writer, redactor *Redactor) (zerolog.Logger, io.Closer, string) {
+	var sinks []io.Writer
+	closer := io.Closer(nopCloser{})
+	warning := ""
+
+	if config.Output != "stdout" {
+		if err := checkLogFile(config.File); err != nil {
+			warning = fmt.Sprintf("Can't write to log file %s, logging to stdout instead: %v", config.File, err)
+		} else {
+			file := &lumberjack.Logger{
+				Filename:   config.File,
+				MaxSize:    config.MaxSizeMB,
+				MaxAge:     config.MaxAgeDays,
+				MaxBackups: config.MaxBackups,
+				Compress:   config.Compress,
+			}
+			sinks = append(sinks, formatLogSink(config.Format, file))
+			closer = file
+		}
+	}
+	if config.Output != "file" || warning != "" {
+		sinks = append(sinks, formatLogSink(config.Format, stdout))
+	}
+
+	out := io.Writer(sinks[0])
+	if len(sinks) > 1 {
+		out = io.MultiWriter(sinks...)
+	}
+	logger := zerolog.New(NewRedactingWriter(out, redactor)).With().Timestamp().Caller().Logger()
+	return logger, closer, warning
+}
+
+// checkLogFile makes sure the log file can be opened for appending before
+// the rotator is given it, as the rotator only opens it on first write
+func checkLogFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// formatLogSink writes zerolog's JSON as is, or in the console layout the
+// service has always used for its log file
+func formatLogSink(format string, out io.Writer) io.Writer {
+	if format != "console" {
+		return out
+	}
+
+	cw := zerolog.ConsoleWriter{Out: out, NoColor: true}
+	cw.FormatLevel = func(i interface{}) string {
+		return strings.ToUpper(fmt.Sprintf("[ %-6s]", i))
+	}
+	cw.TimeFormat = "[" + time.RFC3339 + "] - "
+	cw.FormatCaller = func(i interface{}) string {
+		str, _ := i.(string)
+		return fmt.Sprintf("['%s']", str)
+	}
+	cw.PartsOrder = []string{
+		zerolog.LevelFieldName,
+		zerolog.TimestampFieldName,
+		zerolog.MessageFieldName,
+		zerolog.CallerFieldName,
+	}
+	return cw
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
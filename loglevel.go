@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+//-----------------------------------------------------------------------------
+// Log levels
+// the level set by LOGLEVEL can be overridden while the service runs, for
+// everything or for one component - auth, db or http - and optionally only
+// for a while. LOG_COMPONENT_LEVELS sets standing component levels. Both
+// settings are re-read on SIGHUP, and admins can set overrides through
+// /list/admin/log-level. Overrides belong to the replica that took them
+//
+// each component logger carries a sampler that checks its level, while
+// zerolog's global level is kept at the lowest level in use so that it lets
+// those events through
+
+const (
+	componentAuth = "auth"
+	componentDB   = "db"
+	componentHTTP = "http"
+
+	// maxLogLevelRevert stops a forgotten debug override running for days
+	maxLogLevelRevert = 24 * time.Hour
+)
+
+var logComponents = []string{componentAuth, componentDB, componentHTTP}
+
+// ParseComponentLevels parses comma separated component=level pairs, such
+// as auth=debug,db=warn
+func ParseComponentLevels(raw string) (map[string]zerolog.Level, error) {
+	var levels map[string]zerolog.Level
+	for _, pair := range SplitCommaList(raw) {
+		component, name, ok := strings.Cut(pair, "=")
+		component = strings.TrimSpace(component)
+		if !ok || !isLogComponent(component) {
+			return nil, fmt.Errorf("%q must be component=level with a component of %s", pair, strings.Join(logComponents, ", "))
+		}
+		level, err := parseLogLevel(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if levels == nil {
+			levels = map[string]zerolog.Level{}
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// parseLogLevel accepts zerolog's level names in any case. An empty name
+// is the error level the service has always defaulted to
+func parseLogLevel(name string) (zerolog.Level, error) {
+	if name == "" {
+		return zerolog.ErrorLevel, nil
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(name))
+	if err != nil || level == zerolog.NoLevel {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level [%s]", name)
+	}
+	return level, nil
+}
+
+func isLogComponent(component string) bool {
+	for _, c := range logComponents {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// levelOverride is a level set while running. A zero ExpiresAt never
+// expires
+type levelOverride struct {
+	Level     zerolog.Level
+	ExpiresAt time.Time
+	timer     *time.Timer
+}
+
+// LogLevels holds the configured levels and any overrides. The component
+// "" is everything that isn't one of the named components
+type LogLevels struct {
+	mu         sync.Mutex
+	configured zerolog.Level
+	components map[string]zerolog.Level
+	overrides  map[string]*levelOverride
+	log        *zerolog.Logger
+
+	// effective levels are read on every log call, so they are kept where
+	// the samplers can read them without the lock
+	effective map[string]*atomic.Int32
+}
+
+func NewLogLevels(level zerolog.Level, components map[string]zerolog.Level, log *zerolog.Logger) *LogLevels {
+	l := &LogLevels{
+		configured: level,
+		components: components,
+		overrides:  map[string]*levelOverride{},
+		log:        log,
+		effective:  map[string]*atomic.Int32{"": {}},
+	}
+	for _, c := range logComponents {
+		l.effective[c] = &atomic.Int32{}
+	}
+	l.mu.Lock()
+	l.update()
+	l.mu.Unlock()
+	return l
+}
+
+// Level returns the level in force for a component
+func (l *LogLevels) Level(component string) zerolog.Level {
+	effective, ok := l.effective[component]
+	if !ok {
+		effective = l.effective[""]
+	}
+	return zerolog.Level(effective.Load())
+}
+
+// Sampler returns a sampler that drops events below the component's level
+func (l *LogLevels) Sampler(component string) zerolog.Sampler {
+	return componentSampler{levels: l, component: component}
+}
+
+type componentSampler struct {
+	levels    *LogLevels
+	component string
+}
+
+func (s componentSampler) Sample(level zerolog.Level) bool {
+	return level >= s.levels.Level(s.component)
+}
+
+// Configure replaces the configured levels, as on SIGHUP. Overrides stay
+// until they're reverted or expire
+func (l *LogLevels) Configure(level zerolog.Level, components map[string]zerolog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configured = level
+	l.components = components
+	l.apply()
+}
+
+// Override sets the level for a component, or for everything when
+// component is "". With revertAfter the override removes itself once that
+// long has passed
+func (l *LogLevels) Override(component string, level zerolog.Level, revertAfter time.Duration) error {
+	if component != "" && !isLogComponent(component) {
+		return fmt.Errorf("component must be one of %s", strings.Join(logComponents, ", "))
+	}
+	if revertAfter < 0 || revertAfter > maxLogLevelRevert {
+		return fmt.Errorf("revert_after must be between 0 and %s", maxLogLevelRevert)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stop(component)
+	override := &levelOverride{Level: level}
+	if revertAfter > 0 {
+		override.ExpiresAt = time.Now().Add(revertAfter)
+		override.timer = time.AfterFunc(revertAfter, func() {
+			l.expire(component, override)
+		})
+	}
+	l.overrides[component] = override
+	l.apply()
+	return nil
+}
+
+// Revert removes the override for a component, or every override when
+// component is ""
+func (l *LogLevels) Revert(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if component == "" {
+		for c := range l.overrides {
+			l.stop(c)
+		}
+		l.overrides = map[string]*levelOverride{}
+	} else {
+		l.stop(component)
+		delete(l.overrides, component)
+	}
+	l.apply()
+}
+
+// Stop cancels the revert timers, for shutdown
+func (l *LogLevels) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.overrides {
+		l.stop(c)
+	}
+}
+
+func (l *LogLevels) expire(component string, override *levelOverride) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// a newer override for the component has its own timer
+	if l.overrides[component] != override {
+		return
+	}
+	delete(l.overrides, component)
+	l.apply()
+	l.log.Log().Str("component", component).Str("level", l.Level(component).String()).Msg("Log level override expired")
+}
+
+func (l *LogLevels) stop(component string) {
+	if o, ok := l.overrides[component]; ok && o.timer != nil {
+		o.timer.Stop()
+	}
+}
+
+// apply updates the effective levels and moves the global level to the
+// lowest of them. Call with the lock held
+func (l *LogLevels) apply() {
+	zerolog.SetGlobalLevel(l.update())
+}
+
+// update recomputes the effective levels, returning the lowest. An
+// override for a component beats one for everything, which beats the
+// configured component level, which beats LOGLEVEL. Call with the lock held
+func (l *LogLevels) update() zerolog.Level {
+	global := l.configured
+	if o, ok := l.overrides[""]; ok {
+		global = o.Level
+	}
+	l.effective[""].Store(int32(global))
+	lowest := global
+
+	for _, c := range logComponents {
+		level := global
+		if o, ok := l.overrides[c]; ok {
+			level = o.Level
+		} else if configured, ok := l.components[c]; ok && l.overrides[""] == nil {
+			level = configured
+		}
+		l.effective[c].Store(int32(level))
+		if level < lowest {
+			lowest = level
+		}
+	}
+	return lowest
+}
+
+// Snapshot describes the levels in force
+func (l *LogLevels) Snapshot() LogLevelsResponse {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	response := LogLevelsResponse{
+		Level:      l.Level("").String(),
+		Configured: l.configured.String(),
+		Components: map[string]ComponentLogLevel{},
+	}
+	if o, ok := l.overrides[""]; ok {
+		response.ExpiresAt = expiry(o)
+	}
+	for _, c := range logComponents {
+		level := ComponentLogLevel{Level: l.Level(c).String()}
+		if o, ok := l.overrides[c]; ok {
+			level.Overridden = true
+			level.ExpiresAt = expiry(o)
+		}
+		response.Components[c] = level
+	}
+	return response
+}
+
+func expiry(o *levelOverride) *time.Time {
+	if o.ExpiresAt.IsZero() {
+		return nil
+	}
+	at := o.ExpiresAt
+	return &at
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+// initialiseLogLevels applies LOGLEVEL and LOG_COMPONENT_LEVELS and puts
+// the app logger under their control. It runs before anything else takes a
+// copy of the logger
+func (a *App) initialiseLogLevels() {
+	config := a.config().Log
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid log settings")
+	}
+	// the global level only moves once levels are configured
+	a.levels = NewLogLevels(zerolog.GlobalLevel(), nil, a.Log)
+	a.levels.Configure(config.Level, config.ComponentLevels)
+
+	logger := a.Log.Sample(a.levels.Sampler(""))
+	a.Log = &logger
+	a.Log.Info().Str("level", config.Level.String()).Interface("components", config.ComponentLevels).Msg("Log levels configured")
+}
+
+// logLevels returns the app's log levels. Apps that haven't been through
+// InitialiseApp get levels that leave logging as it is
+func (a *App) logLevels() *LogLevels {
+	a.levelsOnce.Do(func() {
+		if a.levels == nil {
+			a.levels = NewLogLevels(zerolog.GlobalLevel(), nil, a.Log)
+		}
+	})
+	return a.levels
+}
+
+// componentLog returns the logger for one of the named components
+func (a *App) componentLog(component string) *zerolog.Logger {
+	logger := a.Log.With().Str("component", component).Logger().Sample(a.logLevels().Sampler(component))
+	return &logger
+}
+
+// tagRequest adds the request ID in ctx, if there is one, to logger
+func tagRequest(ctx context.Context, logger *zerolog.Logger) *zerolog.Logger {
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		return logger
+	}
+	tagged := logger.With().Str("request_id", id).Logger()
+	return &tagged
+}
+
+// authLog returns the auth component's logger for a request
+func (a *App) authLog(c *gin.Context) *zerolog.Logger {
+	return tagRequest(c.Request.Context(), a.componentLog(componentAuth))
+}
+
+// watchReloads re-reads the log levels each time a signal arrives, until
+// ctx is done
+func (a *App) watchReloads(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			a.reloadLogLevels()
+		}
+	}
+}
+
+// reloadLogLevels reads LOGLEVEL and LOG_COMPONENT_LEVELS from the config
+// sources again. Bad settings leave the levels as they were. Other settings
+// still need a restart
+func (a *App) reloadLogLevels() {
+	values, err := a.config().reloadSettings("LOGLEVEL", "LOG_COMPONENT_LEVELS")
+	if err != nil {
+		a.Log.Error().Err(err).Msg("Failed to reload log levels")
+		return
+	}
+	level, err := parseLogLevel(values["LOGLEVEL"])
+	if err != nil {
+		a.Log.Error().Err(err).Msg("Failed to reload log levels")
+		return
+	}
+	components, err := ParseComponentLevels(values["LOG_COMPONENT_LEVELS"])
+	if err != nil {
+		a.Log.Error().Err(err).Msg("Failed to reload log levels")
+		return
+	}
+
+	a.logLevels().Configure(level, components)
+	// logged without a level so it's written whatever the new level is
+	a.Log.Log().Str("level", level.String()).Interface("components", components).Msg("Log levels reloaded")
+}
+
+//-----------------------------------------------------------------------------
+// Admin handlers
+
+// GetLogLevels reports the levels in force on this replica
+func (a *App) GetLogLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, a.logLevels().Snapshot())
+}
+
+// SetLogLevel overrides the level for everything or for one component
+func (a *App) SetLogLevel(c *gin.Context) {
+	var req LogLevelRequest
+	if !a.DecodeJSON(c, &req) {
+		return
+	}
+
+	level, err := parseLogLevel(req.Level)
+	if err != nil || level == zerolog.Disabled {
+		c.JSON(http.StatusBadRequest, NewValidationError("level", "must be trace, debug, info, warn, error, fatal or panic"))
+		return
+	}
+	if req.Component != "" && !isLogComponent(req.Component) {
+		c.JSON(http.StatusBadRequest, NewValidationError("component", "must be one of "+strings.Join(logComponents, ", ")))
+		return
+	}
+	var revertAfter time.Duration
+	if req.RevertAfter != "" {
+		revertAfter, err = time.ParseDuration(req.RevertAfter)
+		if err != nil || revertAfter <= 0 || revertAfter > maxLogLevelRevert {
+			c.JSON(http.StatusBadRequest, NewValidationError("revert_after", "must be a duration such as 15m, of at most 24h"))
+			return
+		}
+	}
+	if err := a.logLevels().Override(req.Component, level, revertAfter); err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Failed to override log level")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to set log level"})
+		return
+	}
+
+	a.requestLog(c).Log().
+		Str("component", req.Component).
+		Str("level", level.String()).
+		Dur("revert_after", revertAfter).
+		Msg("Log level overridden")
+	c.JSON(http.StatusOK, a.logLevels().Snapshot())
+}
+
+// RevertLogLevels removes the override for ?component, or every override
+func (a *App) RevertLogLevels(c *gin.Context) {
+	component := c.Query("component")
+	if component != "" && !isLogComponent(component) {
+		c.JSON(http.StatusBadRequest, NewValidationError("component", "must be one of "+strings.Join(logComponents, ", ")))
+		return
+	}
+	a.logLevels().Revert(component)
+	a.requestLog(c).Log().Str("component", component).Msg("Log level overrides reverted")
+	c.JSON(http.StatusOK, a.logLevels().Snapshot())
+}
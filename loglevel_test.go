@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// keepGlobalLevel puts the global log level back after the test
+func keepGlobalLevel(t *testing.T) {
+	t.Helper()
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+}
+
+func TestParseComponentLevels(t *testing.T) {
+	levels, err := ParseComponentLevels(" auth=debug, db=WARN ")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]zerolog.Level{"auth": zerolog.DebugLevel, "db": zerolog.WarnLevel}, levels)
+
+	levels, err = ParseComponentLevels("")
+	require.NoError(t, err)
+	assert.Nil(t, levels)
+
+	_, err = ParseComponentLevels("cache=debug")
+	assert.ErrorContains(t, err, "component of auth, db, http")
+	_, err = ParseComponentLevels("auth")
+	assert.Error(t, err)
+	_, err = ParseComponentLevels("auth=loud")
+	assert.ErrorContains(t, err, "unknown log level [loud]")
+
+	level, err := parseLogLevel("")
+	require.NoError(t, err)
+	assert.Equal(t, zerolog.ErrorLevel, level)
+	level, err = parseLogLevel("Warn")
+	require.NoError(t, err)
+	assert.Equal(t, zerolog.WarnLevel, level)
+}
+
+func TestLogLevels(t *testing.T) {
+	logger := zerolog.Nop()
+
+	t.Run("should apply overrides before configured levels", func(t *testing.T) {
+		keepGlobalLevel(t)
+		levels := NewLogLevels(zerolog.ErrorLevel, map[string]zerolog.Level{"db": zerolog.InfoLevel}, &logger)
+		defer levels.Stop()
+		levels.Configure(zerolog.ErrorLevel, map[string]zerolog.Level{"db": zerolog.InfoLevel})
+		assert.Equal(t, zerolog.ErrorLevel, levels.Level(""))
+		assert.Equal(t, zerolog.InfoLevel, levels.Level("db"))
+		assert.Equal(t, zerolog.ErrorLevel, levels.Level("auth"))
+		// the global level lets through the lowest
+		assert.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel())
+
+		require.NoError(t, levels.Override("", zerolog.WarnLevel, 0))
+		assert.Equal(t, zerolog.WarnLevel, levels.Level(""))
+		assert.Equal(t, zerolog.WarnLevel, levels.Level("db"))
+
+		require.NoError(t, levels.Override("auth", zerolog.TraceLevel, 0))
+		assert.Equal(t, zerolog.TraceLevel, levels.Level("auth"))
+		assert.Equal(t, zerolog.TraceLevel, zerolog.GlobalLevel())
+
+		levels.Revert("auth")
+		assert.Equal(t, zerolog.WarnLevel, levels.Level("auth"))
+		levels.Revert("")
+		assert.Equal(t, zerolog.InfoLevel, levels.Level("db"))
+		assert.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel())
+	})
+
+	t.Run("should keep overrides when reconfigured", func(t *testing.T) {
+		keepGlobalLevel(t)
+		levels := NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+		defer levels.Stop()
+		require.NoError(t, levels.Override("http", zerolog.DebugLevel, 0))
+		levels.Configure(zerolog.WarnLevel, map[string]zerolog.Level{"http": zerolog.InfoLevel})
+		assert.Equal(t, zerolog.DebugLevel, levels.Level("http"))
+		assert.Equal(t, zerolog.WarnLevel, levels.Level(""))
+	})
+
+	t.Run("should revert after the duration", func(t *testing.T) {
+		keepGlobalLevel(t)
+		levels := NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+		defer levels.Stop()
+		require.NoError(t, levels.Override("db", zerolog.DebugLevel, 20*time.Millisecond))
+		snapshot := levels.Snapshot()
+		assert.True(t, snapshot.Components["db"].Overridden)
+		require.NotNil(t, snapshot.Components["db"].ExpiresAt)
+
+		assert.Eventually(t, func() bool {
+			return levels.Level("db") == zerolog.ErrorLevel
+		}, time.Second, 5*time.Millisecond)
+		assert.False(t, levels.Snapshot().Components["db"].Overridden)
+	})
+
+	t.Run("should not let an old timer remove a newer override", func(t *testing.T) {
+		keepGlobalLevel(t)
+		levels := NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+		defer levels.Stop()
+		require.NoError(t, levels.Override("db", zerolog.DebugLevel, 20*time.Millisecond))
+		require.NoError(t, levels.Override("db", zerolog.InfoLevel, 0))
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, zerolog.InfoLevel, levels.Level("db"))
+	})
+
+	t.Run("should reject bad overrides", func(t *testing.T) {
+		levels := NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+		assert.Error(t, levels.Override("cache", zerolog.DebugLevel, 0))
+		assert.Error(t, levels.Override("", zerolog.DebugLevel, 25*time.Hour))
+	})
+}
+
+func TestComponentLog(t *testing.T) {
+	keepGlobalLevel(t)
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.levels = NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+	app.levels.Configure(zerolog.ErrorLevel, map[string]zerolog.Level{"auth": zerolog.DebugLevel})
+	sampled := app.Log.Sample(app.levels.Sampler(""))
+	app.Log = &sampled
+
+	app.Log.Debug().Msg("general debug")
+	app.componentLog(componentDB).Debug().Msg("db debug")
+	app.componentLog(componentAuth).Debug().Msg("auth debug")
+
+	assert.NotContains(t, logs.String(), "general debug")
+	assert.NotContains(t, logs.String(), "db debug")
+	assert.Contains(t, logs.String(), `"component":"auth"`)
+	assert.Contains(t, logs.String(), "auth debug")
+}
+
+func TestCommandLogMonitor(t *testing.T) {
+	keepGlobalLevel(t)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	monitor := CommandLogMonitor(&logger)
+	ctx := WithRequestID(context.Background(), "req-123")
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{
+		CommandName:  "find",
+		DatabaseName: "poptape_lister",
+	}})
+	monitor.Failed(context.Background(), &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "insert"},
+		Failure:              "duplicate key",
+	})
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"command":"find"`)
+	assert.Contains(t, lines[0], `"request_id":"req-123"`)
+	assert.Contains(t, lines[1], `"failure":"duplicate key"`)
+	assert.NotContains(t, lines[1], "request_id")
+}
+
+func TestLogLevelRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keepGlobalLevel(t)
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	app := &App{
+		Log:    &logger,
+		Config: testConfig(t),
+		Auth: roleVerifier{
+			"admin-token": {PublicID: adminTestAdminID, Roles: []string{"admin"}},
+			"user-token":  {PublicID: adminTestUserID},
+		},
+	}
+	app.levels = NewLogLevels(zerolog.ErrorLevel, nil, &logger)
+	defer app.levels.Stop()
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	send := func(method, path, token, body string) (*httptest.ResponseRecorder, LogLevelsResponse) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", token)
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+		var levels LogLevelsResponse
+		_ = json.Unmarshal(resp.Body.Bytes(), &levels)
+		return resp, levels
+	}
+
+	t.Run("should need the admin role", func(t *testing.T) {
+		resp, _ := send("PUT", "/list/admin/log-level", "user-token", `{"level": "debug"}`)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Equal(t, zerolog.ErrorLevel, app.levels.Level(""))
+	})
+
+	t.Run("should report the levels", func(t *testing.T) {
+		resp, levels := send("GET", "/list/admin/log-level", "admin-token", "")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "error", levels.Level)
+		assert.Equal(t, "error", levels.Configured)
+		assert.Equal(t, "error", levels.Components["db"].Level)
+	})
+
+	t.Run("should override a component for a while", func(t *testing.T) {
+		resp, levels := send("PUT", "/list/admin/log-level", "admin-token", `{"level": "debug", "component": "db", "revert_after": "15m"}`)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "error", levels.Level)
+		assert.Equal(t, "debug", levels.Components["db"].Level)
+		assert.True(t, levels.Components["db"].Overridden)
+		require.NotNil(t, levels.Components["db"].ExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(15*time.Minute), *levels.Components["db"].ExpiresAt, time.Minute)
+		// the change is logged even though warnings aren't
+		assert.Contains(t, logs.String(), "Log level overridden")
+	})
+
+	t.Run("should reject bad requests", func(t *testing.T) {
+		for body, field := range map[string]string{
+			`{"level": "loud"}`:                           "level",
+			`{"level": "disabled"}`:                       "level",
+			`{"level": "debug", "component": "cache"}`:    "component",
+			`{"level": "debug", "revert_after": "48h"}`:   "revert_after",
+			`{"level": "debug", "revert_after": "-1m"}`:   "revert_after",
+			`{"level": "debug", "revert_after": "later"}`: "revert_after",
+		} {
+			req := httptest.NewRequest("PUT", "/list/admin/log-level", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Access-Token", "admin-token")
+			resp := httptest.NewRecorder()
+			app.Router.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+			assert.Contains(t, resp.Body.String(), field, body)
+		}
+	})
+
+	t.Run("should revert overrides", func(t *testing.T) {
+		resp, _ := send("DELETE", "/list/admin/log-level?component=cache", "admin-token", "")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		resp, levels := send("DELETE", "/list/admin/log-level?component=db", "admin-token", "")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "error", levels.Components["db"].Level)
+		assert.False(t, levels.Components["db"].Overridden)
+	})
+}
+
+func TestReloadLogLevels(t *testing.T) {
+	keepGlobalLevel(t)
+	clearConfigEnv(t)
+	setRequiredConfig(t)
+	env := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(env, []byte("LOGLEVEL=error\n"), 0644))
+
+	config, err := LoadConfig(env)
+	require.NoError(t, err)
+	logger := zerolog.Nop()
+	app := &App{Log: &logger, Config: config}
+	app.initialiseLogLevels()
+	defer app.levels.Stop()
+	assert.Equal(t, zerolog.ErrorLevel, app.levels.Level(""))
+
+	t.Run("should re-read the .env file on a signal", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(env, []byte("LOGLEVEL=warn\nLOG_COMPONENT_LEVELS=db=debug\n"), 0644))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 1)
+		go app.watchReloads(ctx, signals)
+		signals <- syscall.SIGHUP
+
+		assert.Eventually(t, func() bool {
+			return app.levels.Level("db") == zerolog.DebugLevel
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, zerolog.WarnLevel, app.levels.Level(""))
+	})
+
+	t.Run("should keep the levels when the settings are bad", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(env, []byte("LOGLEVEL=loud\n"), 0644))
+		app.reloadLogLevels()
+		assert.Equal(t, zerolog.WarnLevel, app.levels.Level(""))
+	})
+
+	t.Run("should re-read the config file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lister.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("loglevel: error\nlog:\n  component_levels: auth=trace\n"), 0644))
+		t.Setenv("CONFIG_FILE", path)
+		require.NoError(t, os.WriteFile(env, []byte(""), 0644))
+		app.reloadLogLevels()
+		assert.Equal(t, zerolog.ErrorLevel, app.levels.Level(""))
+		assert.Equal(t, zerolog.TraceLevel, app.levels.Level("auth"))
+	})
+
+	t.Run("should still prefer the environment", func(t *testing.T) {
+		clearConfigEnv(t)
+		setRequiredConfig(t)
+		t.Setenv("LOGLEVEL", "info")
+		require.NoError(t, os.WriteFile(env, []byte("LOGLEVEL=warn\n"), 0644))
+		config, err := LoadConfig(env)
+		require.NoError(t, err)
+		app := &App{Log: &logger, Config: config}
+		app.initialiseLogLevels()
+		defer app.levels.Stop()
+
+		require.NoError(t, os.WriteFile(env, []byte("LOGLEVEL=debug\n"), 0644))
+		app.reloadLogLevels()
+		assert.Equal(t, zerolog.InfoLevel, app.levels.Level(""))
+	})
+}
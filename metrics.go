@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+//-----------------------------------------------------------------------------
+// Prometheus metrics
+// served at /metrics on a separate listener, METRICS_ADDR, unless
+// METRICS_ENABLED is false. Keeping the scrape endpoint off the API port
+// means it can't be reached through the public ingress. HTTP metrics are
+// labelled by gin route template rather than path so item and user IDs
+// don't each get their own series. MongoDB timings come from the driver's
+// command monitor, so every query is covered without touching the handlers
+
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	httpInFlight  prometheus.Gauge
+	mongoDuration *prometheus.HistogramVec
+	mongoErrors   *prometheus.CounterVec
+	authyDuration *prometheus.HistogramVec
+	listSize      *prometheus.HistogramVec
+}
+
+// unmatchedRoute labels requests that didn't match a route
+const unmatchedRoute = "unmatched"
+
+// NewMetrics builds the registry. maxListSize is the configured
+// MAX_LIST_SIZE, which sets the list size buckets
+func NewMetrics(maxListSize int) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lister_http_requests_total",
+			Help: "HTTP requests by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lister_http_request_duration_seconds",
+			Help:    "HTTP request latency by method, route template and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "lister_http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		mongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lister_mongo_operation_duration_seconds",
+			Help:    "MongoDB command latency by collection and operation.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"collection", "operation"}),
+		mongoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lister_mongo_operation_errors_total",
+			Help: "Failed MongoDB commands by collection and operation.",
+		}, []string{"collection", "operation"}),
+		authyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lister_authy_request_duration_seconds",
+			Help:    "Authy token lookups, including retries, by outcome: valid, rejected, unavailable or error.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		listSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lister_list_size_items",
+			Help:    "Number of items in a list after each change, by list type.",
+			Buckets: listSizeBuckets(maxListSize),
+		}, []string{"list_type"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.httpInFlight,
+		m.mongoDuration,
+		m.mongoErrors,
+		m.authyDuration,
+		m.listSize,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware counts and times every request
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MongoMonitor times MongoDB commands. The collection is only in the
+// started event, so it's held by request ID until the command finishes
+func (m *Metrics) MongoMonitor() *event.CommandMonitor {
+	var collections sync.Map
+	finished := func(e event.CommandFinishedEvent, failed bool) {
+		v, ok := collections.LoadAndDelete(e.RequestID)
+		if !ok || v.(string) == "" {
+			// handshakes, pings and the like aren't on a collection
+			return
+		}
+		collection := v.(string)
+		m.mongoDuration.WithLabelValues(collection, e.CommandName).Observe(e.Duration.Seconds())
+		if failed {
+			m.mongoErrors.WithLabelValues(collection, e.CommandName).Inc()
+		}
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			collections.Store(e.RequestID, commandCollection(e.CommandName, e.Command))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent, false)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, true)
+		},
+	}
+}
+
+// commandCollection finds the collection a command runs against. Most
+// commands name it as their first value, e.g. {"find": "watchlist"}
+func commandCollection(name string, command bson.Raw) string {
+	if name == "getMore" {
+		collection, _ := command.Lookup("collection").StringValueOK()
+		return collection
+	}
+	elements, err := command.Elements()
+	if err != nil || len(elements) == 0 {
+		return ""
+	}
+	collection, _ := elements[0].Value().StringValueOK()
+	return collection
+}
+
+// ObserveAuthy records one authy token lookup
+func (m *Metrics) ObserveAuthy(err error, d time.Duration) {
+	m.authyDuration.WithLabelValues(authyOutcome(err)).Observe(d.Seconds())
+}
+
+func authyOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "valid"
+	case errors.Is(err, ErrTokenRejected):
+		return "rejected"
+	case errors.Is(err, ErrAuthyUnavailable):
+		return "unavailable"
+	}
+	return "error"
+}
+
+// listSizeBuckets spreads about ten buckets evenly from 0 up to the largest
+// a list can be, so full lists always have a bucket of their own
+func listSizeBuckets(maxListSize int) []float64 {
+	if maxListSize < 1 {
+		maxListSize = 1
+	}
+	step := (maxListSize + 9) / 10
+	var buckets []float64
+	for bound := 0; bound < maxListSize; bound += step {
+		buckets = append(buckets, float64(bound))
+	}
+	return append(buckets, float64(maxListSize))
+}
+
+// ObserveListSize records the size of a list after a change
+func (m *Metrics) ObserveListSize(listType string, size int) {
+	m.listSize.WithLabelValues(listType).Observe(float64(size))
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+// appMetrics returns the app's metrics, creating them on first use so the
+// database monitor and routes share one registry
+func (a *App) appMetrics() *Metrics {
+	a.metricsOnce.Do(func() {
+		if a.metrics == nil {
+			a.metrics = NewMetrics(a.config().MaxListSize)
+		}
+	})
+	return a.metrics
+}
+
+// newMetricsRouter returns the router for the internal metrics listener
+func (a *App) newMetricsRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/metrics", a.appMetrics().Handler())
+	return router
+}
+
+// serveMetrics serves the metrics router on ln. The returned func closes
+// the listener, so scrapes carry on while the API drains
+func (a *App) serveMetrics(ln net.Listener) func() {
+	server := &http.Server{
+		Handler:           a.metricsRouter,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.Log.Error().Err(err).Msg("Metrics server failed")
+		}
+	}()
+	a.Log.Info().Msgf("Metrics listening on %s", ln.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			_ = server.Close()
+		}
+	}
+}
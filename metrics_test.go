@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	newApp := func() *App {
+		app := &App{Log: &logger, Config: testConfig(t)}
+		app.Router = gin.New()
+		app.initialiseRoutes()
+		return app
+	}
+	get := func(app *App, path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		return resp
+	}
+	scrape := func(app *App) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		app.metricsRouter.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+		return resp
+	}
+
+	t.Run("should label requests by route template and status", func(t *testing.T) {
+		app := newApp()
+		get(app, "/list/status")
+		get(app, "/list/watching/not-a-uuid")
+		get(app, "/list/watching/also-not-a-uuid")
+		get(app, "/no/such/route")
+
+		resp := scrape(app)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Header().Get("Content-Type"), "text/plain")
+
+		body := resp.Body.String()
+		assert.Contains(t, body, `lister_http_requests_total{method="GET",route="/list/status",status="200"} 1`)
+		assert.Contains(t, body, `lister_http_requests_total{method="GET",route="/list/watching/:item_id",status="400"} 2`)
+		assert.Contains(t, body, `lister_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+		assert.Contains(t, body, `lister_http_request_duration_seconds_count{method="GET",route="/list/status",status="200"} 1`)
+		assert.NotContains(t, body, "not-a-uuid")
+		// scrapes go through their own router, so aren't counted
+		assert.Contains(t, body, "lister_http_requests_in_flight 0")
+		assert.Contains(t, body, "go_goroutines")
+	})
+
+	t.Run("should not be served on the API router", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get(newApp(), "/metrics").Code)
+	})
+
+	t.Run("should serve scrapes on the metrics listener", func(t *testing.T) {
+		app := newApp()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		stop := app.serveMetrics(ln)
+		defer stop()
+
+		resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should be switched off by METRICS_ENABLED", func(t *testing.T) {
+		t.Setenv("METRICS_ENABLED", "false")
+		assert.Nil(t, newApp().metricsRouter)
+	})
+}
+
+func TestMongoMetrics(t *testing.T) {
+	command := func(doc bson.D) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		return raw
+	}
+
+	t.Run("should find the collection a command runs against", func(t *testing.T) {
+		assert.Equal(t, "watchlist", commandCollection("find", command(bson.D{{Key: "find", Value: "watchlist"}, {Key: "filter", Value: bson.D{}}})))
+		assert.Equal(t, "audit", commandCollection("insert", command(bson.D{{Key: "insert", Value: "audit"}})))
+		assert.Equal(t, "shares", commandCollection("getMore", command(bson.D{{Key: "getMore", Value: int64(42)}, {Key: "collection", Value: "shares"}})))
+		assert.Equal(t, "", commandCollection("ping", command(bson.D{{Key: "ping", Value: 1}})))
+		assert.Equal(t, "", commandCollection("saslStart", nil))
+	})
+
+	t.Run("should time commands and count failures", func(t *testing.T) {
+		m := NewMetrics(50)
+		monitor := m.MongoMonitor()
+		ctx := context.Background()
+
+		run := func(requestID int64, name string, doc bson.D, failed bool) {
+			monitor.Started(ctx, &event.CommandStartedEvent{CommandName: name, RequestID: requestID, Command: command(doc)})
+			finished := event.CommandFinishedEvent{CommandName: name, RequestID: requestID, Duration: 3 * time.Millisecond}
+			if failed {
+				monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished})
+			} else {
+				monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+			}
+		}
+		run(1, "find", bson.D{{Key: "find", Value: "watchlist"}}, false)
+		run(2, "find", bson.D{{Key: "find", Value: "watchlist"}}, true)
+		run(3, "update", bson.D{{Key: "update", Value: "favourites"}}, false)
+		run(4, "ping", bson.D{{Key: "ping", Value: 1}}, false)
+
+		assert.Equal(t, 2, testutil.CollectAndCount(m.mongoDuration))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.mongoErrors.WithLabelValues("watchlist", "find")))
+		assert.Equal(t, 0.0, testutil.ToFloat64(m.mongoErrors.WithLabelValues("favourites", "update")))
+	})
+}
+
+func TestAuthyMetrics(t *testing.T) {
+	t.Run("should sort lookups by outcome", func(t *testing.T) {
+		assert.Equal(t, "valid", authyOutcome(nil))
+		assert.Equal(t, "rejected", authyOutcome(errInvalidToken(nil)))
+		assert.Equal(t, "unavailable", authyOutcome(&AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable", Err: ErrAuthyUnavailable}))
+		assert.Equal(t, "unavailable", authyOutcome(&AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable", Err: fmt.Errorf("%w: connection refused", ErrAuthyUnavailable)}))
+		// the message alone doesn't decide it
+		assert.Equal(t, "error", authyOutcome(&AuthError{Status: http.StatusServiceUnavailable, Message: "Authentication service unavailable"}))
+		assert.Equal(t, "error", authyOutcome(&AuthError{Status: http.StatusInternalServerError, Message: "Authentication service response error"}))
+		assert.Equal(t, "error", authyOutcome(errors.New("boom")))
+	})
+
+	t.Run("should time each lookup", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Access-Token") != "good" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"public_id": "` + adminTestUserID + `"}`))
+		}))
+		defer server.Close()
+
+		logger := zerolog.Nop()
+		verifier := NewAuthyVerifier(server.URL, newTestAuthyClient(server), &logger)
+		verifier.metrics = NewMetrics(50)
+
+		_, err := verifier.Verify(context.Background(), "good")
+		require.NoError(t, err)
+		_, err = verifier.Verify(context.Background(), "bad")
+		require.Error(t, err)
+
+		histogram := verifier.metrics.authyDuration
+		assert.Equal(t, 2, testutil.CollectAndCount(histogram))
+	})
+}
+
+func TestListSizeMetrics(t *testing.T) {
+	m := NewMetrics(50)
+	m.ObserveListSize("watchlist", 3)
+	m.ObserveListSize("watchlist", 50)
+	m.ObserveListSize("viewed", 0)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.listSize))
+
+	t.Run("should fit the buckets to MAX_LIST_SIZE", func(t *testing.T) {
+		assert.Equal(t, []float64{0, 5, 10, 15, 20, 25, 30, 35, 40, 45, 50}, listSizeBuckets(50))
+		assert.Equal(t, []float64{0, 100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}, listSizeBuckets(1000))
+		assert.Equal(t, []float64{0, 6, 12, 18, 24, 30, 36, 42, 48, 54, 55}, listSizeBuckets(55))
+		assert.Equal(t, []float64{0, 1, 2, 3}, listSizeBuckets(3))
+	})
+}
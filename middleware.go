@@ -1,146 +1,108 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"mime"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 )
 
-// JSONOnlyMiddleware ensures only JSON requests are processed
+// JSONOnlyMiddleware ensures only JSON requests are processed, and caps the
+// body size before anything reads it
 func (a *App) JSONOnlyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		switch c.Request.Method {
 		case http.MethodPost, http.MethodPut, http.MethodPatch:
-			ct := c.GetHeader("Content-Type")
-			if !strings.HasPrefix(ct, "application/json") {
+			mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			if err != nil || mediaType != "application/json" {
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Content-Type must be application/json"})
 				return
 			}
+			limit := a.config().MaxBodyBytes
+			if c.Request.ContentLength > limit {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+					"message": "Request body too large",
+					"error":   fmt.Sprintf("limit is %d bytes", limit),
+				})
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
 		}
 		c.Next()
 	}
 }
 
 func (a *App) AuthMiddleware() gin.HandlerFunc {
+	verifier := a.tokenVerifier()
 	return func(c *gin.Context) {
-		accessToken := c.GetHeader("X-Access-Token")
-		a.Log.Info().Msgf("accessToken is [%s]", accessToken)
-		if accessToken == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Authentication required - missing X-Access-Token header"})
-			c.Abort()
-			return
-		}
-
-		authyURL := os.Getenv("AUTHYURL")
-		if authyURL == "" {
-			a.Log.Error().Msg("AUTHYURL environment variable not set")
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Authentication service env error"})
-			c.Abort()
+		if serviceKey := c.GetHeader("X-Service-Key"); serviceKey != "" {
+			if a.authenticateService(c, serviceKey) {
+				c.Next()
+			}
 			return
 		}
 
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", authyURL, nil)
-		if err != nil {
-			a.Log.Error().Err(err).Msg("Failed to create authentication request")
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Authentication service error"})
+		accessToken := c.GetHeader("X-Access-Token")
+		if accessToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Authentication required - missing X-Access-Token header"})
 			c.Abort()
 			return
 		}
 
-		req.Header.Set("X-Access-Token", accessToken)
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
+		identity, err := verifier.Verify(c.Request.Context(), accessToken)
 		if err != nil {
-			a.Log.Error().Err(err).Msg("Failed to call authentication service")
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Authentication service unavailable"})
-			c.Abort()
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			a.Log.Warn().Int("status", resp.StatusCode).Msg("Authentication failed")
-			var bd interface{}
-			//if err = c.ShouldBindBodyWith(&bd, binding.JSON); err != nil {
-			//	a.Log.Info().Msgf("error is [%s]", err.Error())
-			//}
-			if err := json.NewDecoder(resp.Body).Decode(&bd); err != nil {
-				a.Log.Info().Msgf("error is [%s]", err.Error())
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				authErr = &AuthError{Status: http.StatusInternalServerError, Message: "Authentication service error"}
 			}
-			a.Log.Info().Msgf("message is [%s]", bd)
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid or expired token"})
-			c.Abort()
-			return
-		}
-
-		var authResponse struct {
-			PublicID string `json:"public_id"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
-			a.Log.Error().Err(err).Msg("Failed to parse authentication response")
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Authentication service response error"})
-			c.Abort()
-			return
-		}
-
-		if authResponse.PublicID == "" {
-			a.Log.Error().Msg("Authentication service returned empty public_id")
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Authentication service response error"})
-			c.Abort()
-			return
-		}
-
-		if !IsValidUUID(authResponse.PublicID) {
-			a.Log.Error().Str("public_id", authResponse.PublicID).Msg("Authentication service returned invalid public_id format")
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Authentication service response error"})
+			if authErr.RetryAfter > 0 {
+				// round up so clients never retry before the breaker will let them
+				c.Header("Retry-After", seconds(authErr.RetryAfter))
+			}
+			c.JSON(authErr.Status, gin.H{"message": authErr.Message})
 			c.Abort()
 			return
 		}
 
-		c.Set("public_id", authResponse.PublicID)
-		a.Log.Info().Str("public_id", authResponse.PublicID).Msg("Authentication successful")
-		c.Next()
-	}
-}
-
-func (a *App) CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Access-Token")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusOK)
-			return
-		}
-
+		c.Set("public_id", identity.PublicID)
+		c.Set("roles", identity.Roles)
+		a.authLog(c).Info().Str("public_id", identity.PublicID).Msg("Authentication successful")
 		c.Next()
 	}
 }
 
+// LoggingMiddleware writes the access log, a line per request once it has
+// been handled. It's the only access log, so every line is JSON and goes
+// through the redacting writer
 func (a *App) LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		start := gin.Logger()
-		start(c)
+		start := time.Now()
+		c.Next()
 
-		a.Log.Info().
+		a.requestLog(c).Info().
 			Str("method", c.Request.Method).
-			Str("path", c.Request.URL.Path).
+			Str("path", loggedPath(c)).
+			Int("status", c.Writer.Status()).
+			Dur("duration", time.Since(start)).
 			Str("remote_addr", c.ClientIP()).
-			Msg("Request received")
-
-		c.Next()
+			Msg("Request handled")
 	}
 }
 
-func (a *App) RateLimitMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		//TODO: Rate a rate limiter!
-		c.Next()
+// secretPathParams are route parameters that are credentials, such as a
+// share link's token, so are never logged
+var secretPathParams = map[string]bool{"token": true}
+
+// loggedPath is the request path with any secret parameters masked
+func loggedPath(c *gin.Context) string {
+	path := c.Request.URL.Path
+	for _, p := range c.Params {
+		if secretPathParams[p.Key] && p.Value != "" {
+			path = strings.Replace(path, p.Value, redactedValue, 1)
+		}
 	}
+	return path
 }
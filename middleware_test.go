@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -61,7 +62,7 @@ func (suite *MiddlewareTestSuite) SetupTest() {
 	httpmock.Reset()
 
 	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	suite.app = &App{Log: &logger}
+	suite.app = &App{Log: &logger, Config: testConfig(suite.T())}
 	
 	suite.router = gin.New()
 }
@@ -74,7 +75,7 @@ func (suite *MiddlewareTestSuite) TearDownTest() {
 func TestMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	app := &App{Log: &logger}
+	app := &App{Log: &logger, Config: testConfig(t)}
 
 	t.Run("JSONOnlyMiddleware", func(t *testing.T) {
 		t.Run("should allow GET requests without JSON content type", func(t *testing.T) {
@@ -168,34 +169,38 @@ func TestMiddleware(t *testing.T) {
 	})
 
 	t.Run("CORSMiddleware", func(t *testing.T) {
-		t.Run("should set CORS headers", func(t *testing.T) {
+		newCORSRouter := func() *gin.Engine {
 			router := gin.New()
-			router.Use(app.CORSMiddleware())
+			corsApp := &App{Log: &logger, Config: testConfig(t), Router: router}
+			router.Use(corsApp.CORSMiddleware())
 			router.GET("/test", func(c *gin.Context) {
 				c.JSON(http.StatusOK, gin.H{"message": "success"})
 			})
+			return router
+		}
 
+		t.Run("should set CORS headers", func(t *testing.T) {
 			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set("Origin", "http://example.com")
 			resp := httptest.NewRecorder()
-			router.ServeHTTP(resp, req)
+			newCORSRouter().ServeHTTP(resp, req)
 
+			assert.Equal(t, http.StatusOK, resp.Code)
 			assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
-			assert.Equal(t, "GET, POST, DELETE, OPTIONS", resp.Header().Get("Access-Control-Allow-Methods"))
-			assert.Equal(t, "Content-Type, Authorization, X-Access-Token", resp.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, defaultCORSExposedHeaders, resp.Header().Get("Access-Control-Expose-Headers"))
+			assert.Equal(t, "Origin", resp.Header().Get("Vary"))
 		})
 
 		t.Run("should handle OPTIONS requests", func(t *testing.T) {
-			router := gin.New()
-			router.Use(app.CORSMiddleware())
-			router.GET("/test", func(c *gin.Context) {
-				c.JSON(http.StatusOK, gin.H{"message": "success"})
-			})
-
 			req := httptest.NewRequest("OPTIONS", "/test", nil)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set("Access-Control-Request-Method", "GET")
 			resp := httptest.NewRecorder()
-			router.ServeHTTP(resp, req)
+			newCORSRouter().ServeHTTP(resp, req)
 
-			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, http.StatusNoContent, resp.Code)
+			assert.Equal(t, "GET", resp.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, defaultCORSAllowedHeaders, resp.Header().Get("Access-Control-Allow-Headers"))
 		})
 	})
 
@@ -318,7 +323,8 @@ func (suite *MiddlewareTestSuite) TestAuthMiddleware() {
 		resp := httptest.NewRecorder()
 		suite.router.ServeHTTP(resp, req)
 
-		assert.Equal(suite.T(), http.StatusUnauthorized, resp.Code)
+		assert.Equal(suite.T(), http.StatusServiceUnavailable, resp.Code)
+		assert.NotEmpty(suite.T(), resp.Header().Get("Retry-After"))
 
 		var response map[string]string
 		err := json.Unmarshal(resp.Body.Bytes(), &response)
@@ -405,7 +411,9 @@ func (suite *MiddlewareTestSuite) TestAuthMiddleware() {
 		os.Unsetenv("AUTHYURL")
 		defer os.Setenv("AUTHYURL", originalURL)
 
-		suite.router.Use(suite.app.AuthMiddleware())
+		// the config is read once per app, so take a fresh one
+		app := &App{Log: suite.app.Log, Config: testConfig(suite.T())}
+		suite.router.Use(app.AuthMiddleware())
 		suite.router.GET("/test", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "success"})
 		})
@@ -432,7 +440,8 @@ func (suite *MiddlewareTestSuite) TestAuthMiddleware() {
 		os.Setenv("AUTHYURL", "://invalid-url")
 		defer os.Setenv("AUTHYURL", originalURL)
 
-		suite.router.Use(suite.app.AuthMiddleware())
+		app := &App{Log: suite.app.Log, Config: testConfig(suite.T())}
+		suite.router.Use(app.AuthMiddleware())
 		suite.router.GET("/test", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "success"})
 		})
@@ -477,6 +486,54 @@ func (suite *MiddlewareTestSuite) TestAuthMiddleware() {
 	})
 }
 
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	// gin's own access log would write here
+	var ginOut bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &ginOut
+	t.Cleanup(func() { gin.DefaultWriter = defaultWriter })
+
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	app := &App{Log: &logger, Config: testConfig(t), Auth: roleVerifier{}}
+	router, err := newRouter(nil)
+	require.NoError(t, err)
+	app.Router = router
+	app.initialiseRoutes()
+
+	send := func(method, path, contentType string) {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", contentType)
+		app.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("should log each request once, as JSON", func(t *testing.T) {
+		logs.Reset()
+		send("GET", "/list/health/live", "application/json")
+
+		assert.Empty(t, ginOut.String())
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		require.Len(t, lines, 1)
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+		assert.Equal(t, "Request handled", line["message"])
+		assert.Equal(t, "/list/health/live", line["path"])
+		assert.Equal(t, float64(http.StatusOK), line["status"])
+		assert.NotEmpty(t, line["request_id"])
+	})
+
+	t.Run("should log requests middleware turned away", func(t *testing.T) {
+		logs.Reset()
+		send("POST", "/list/watchlist", "text/plain")
+
+		assert.Empty(t, ginOut.String())
+		assert.Contains(t, logs.String(), `"status":400`)
+	})
+}
+
 // Run the enhanced test suite
 func TestMiddlewareTestSuite(t *testing.T) {
 	suite.Run(t, new(MiddlewareTestSuite))
@@ -2,6 +2,7 @@ package main
 
 import (
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -10,10 +11,79 @@ import (
 // the ID field is the publicId of the current user
 
 type UserList struct {
-	ID        string    `json:"_id" bson:"_id"`
-	ItemIds   []string  `json:"item_ids" bson:"item_ids"`
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	ID        string      `json:"_id" bson:"_id"`
+	ItemIds   []string    `json:"item_ids" bson:"item_ids"`
+	Entries   []ListEntry `json:"entries,omitempty" bson:"entries,omitempty"`
+	CreatedAt time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+// ListEntry records when an item was added to a list. Entries are kept in
+// the same order as ItemIds; documents written before entries existed only
+// have ItemIds and their items are treated as added at UpdatedAt
+
+type ListEntry struct {
+	ItemID  string    `json:"item_id" bson:"item_id"`
+	AddedAt time.Time `json:"added_at" bson:"added_at"`
+}
+
+//-----------------------------------------------------------------------------
+// Record of an action taken through the admin routes, stored in the
+// admin_actions collection
+
+type AdminAction struct {
+	ID        string    `json:"id" bson:"_id"`
+	AdminID   string    `json:"admin_id" bson:"admin_id"`
+	Action    string    `json:"action" bson:"action"`
+	PublicID  string    `json:"public_id,omitempty" bson:"public_id,omitempty"`
+	ListType  string    `json:"list_type,omitempty" bson:"list_type,omitempty"`
+	ItemID    string    `json:"item_id,omitempty" bson:"item_id,omitempty"`
+	Status    int       `json:"status" bson:"status"`
+	RequestID string    `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+//-----------------------------------------------------------------------------
+// Log levels, set through /list/admin/log-level. An empty component is
+// everything, and RevertAfter is a duration such as 15m
+
+type LogLevelRequest struct {
+	Level       string `json:"level" binding:"required"`
+	Component   string `json:"component,omitempty"`
+	RevertAfter string `json:"revert_after,omitempty"`
+}
+
+type ComponentLogLevel struct {
+	Level      string     `json:"level"`
+	Overridden bool       `json:"overridden"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+type LogLevelsResponse struct {
+	Level      string                       `json:"level"`
+	Configured string                       `json:"configured"`
+	ExpiresAt  *time.Time                   `json:"expires_at,omitempty"`
+	Components map[string]ComponentLogLevel `json:"components"`
+}
+
+//-----------------------------------------------------------------------------
+// Record of a change to a user's list, stored in the audit collection.
+// ActorType is user, service or admin and ActorID is the user or admin's
+// public_id or the service's name. For adds, EvictedIDs are items pushed
+// off the end of a full list
+
+type AuditRecord struct {
+	ID         string     `json:"id" bson:"_id"`
+	ActorType  string     `json:"actor_type" bson:"actor_type"`
+	ActorID    string     `json:"actor_id" bson:"actor_id"`
+	PublicID   string     `json:"public_id" bson:"public_id"`
+	ListType   string     `json:"list_type" bson:"list_type"`
+	Operation  string     `json:"operation" bson:"operation"`
+	ItemIDs    []string   `json:"item_ids" bson:"item_ids"`
+	EvictedIDs []string   `json:"evicted_ids,omitempty" bson:"evicted_ids,omitempty"`
+	RequestID  string     `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	At         time.Time  `json:"at" bson:"at"`
+	ExpiresAt  *time.Time `json:"-" bson:"expires_at,omitempty"`
 }
 
 //-----------------------------------------------------------------------------
@@ -23,6 +93,16 @@ type UUIDRequest struct {
 	UUID string `json:"uuid" binding:"required"`
 }
 
+func (r *UUIDRequest) Validate() []FieldError {
+	switch {
+	case r.UUID == "":
+		return []FieldError{{Field: "uuid", Message: "is required"}}
+	case !IsValidUUID(r.UUID):
+		return []FieldError{{Field: "uuid", Message: "must be a UUID"}}
+	}
+	return nil
+}
+
 type WatchlistResponse struct {
 	Watchlist []string `json:"watchlist"`
 }
@@ -40,8 +120,12 @@ type ViewedResponse struct {
 	RecentlyViewed []string `json:"recently_viewed"`
 }
 
+// WatchingResponse is a public watcher count after the privacy policy. When
+// Exact is false PeopleWatching is a lower bound and Display says so
 type WatchingResponse struct {
-	PeopleWatching int `json:"people_watching"`
+	PeopleWatching int    `json:"people_watching"`
+	Display        string `json:"display,omitempty"`
+	Exact          bool   `json:"exact"`
 }
 
 type RecentBidsResponse struct {
@@ -65,6 +149,71 @@ type PurchasedItem struct {
 	ItemID     string `json:"item_id"`
 }
 
+type ItemHoldersResponse struct {
+	ItemID string              `json:"item_id"`
+	Lists  map[string][]string `json:"lists"`
+}
+
+type UserSettings struct {
+	ID                    string    `bson:"_id" json:"-"`
+	HideFromWatcherCounts bool      `bson:"hide_from_watcher_counts" json:"hide_from_watcher_counts"`
+	UpdatedAt             time.Time `bson:"updated_at" json:"-"`
+}
+
+type UserSettingsRequest struct {
+	HideFromWatcherCounts *bool `json:"hide_from_watcher_counts"`
+}
+
+func (r *UserSettingsRequest) Validate() []FieldError {
+	if r.HideFromWatcherCounts == nil {
+		return []FieldError{{Field: "hide_from_watcher_counts", Message: "is required"}}
+	}
+	return nil
+}
+
+// Share is a read only link to one of a user's lists. Only a hash of the
+// token is stored, the token itself is returned once when the share is made
+type Share struct {
+	ID           string     `bson:"_id" json:"id"`
+	TokenHash    string     `bson:"token_hash" json:"-"`
+	PublicID     string     `bson:"public_id" json:"-"`
+	ListType     string     `bson:"list_type" json:"list_type"`
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt    *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	ViewCount    int64      `bson:"view_count" json:"view_count"`
+	LastViewedAt *time.Time `bson:"last_viewed_at,omitempty" json:"last_viewed_at,omitempty"`
+}
+
+// NewShareResponse is a share as first made, the only time its token is seen
+type NewShareResponse struct {
+	Share
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+type ShareRequest struct {
+	ListType  string `json:"list_type"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (r *ShareRequest) Validate() []FieldError {
+	var fields []FieldError
+	if r.ListType == "" {
+		fields = append(fields, FieldError{Field: "list_type", Message: "is required"})
+	} else if !IsShareableListType(r.ListType) {
+		fields = append(fields, FieldError{Field: "list_type", Message: "must be one of " + strings.Join(GetShareableListTypes(), ", ")})
+	}
+	if r.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, r.ExpiresAt)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "expires_at", Message: "must be an RFC 3339 date-time"})
+		} else if !expiresAt.After(time.Now()) {
+			fields = append(fields, FieldError{Field: "expires_at", Message: "must be in the future"})
+		}
+	}
+	return fields
+}
+
 type StatusResponse struct {
 	Message string `json:"message"`
 	Version string `json:"version,omitempty"`
@@ -0,0 +1,1122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// OpenAPI 3 document types
+// only the subset of the specification this service uses is modelled
+
+type OpenAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIPathItem maps a lower case HTTP method to its operation
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPISchema struct {
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *bool                     `json:"additionalProperties,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+//-----------------------------------------------------------------------------
+// Specification for the lister API
+// every route registered in initialiseRoutes must appear here - the tests
+// check the router against this document
+
+var openAPISpec = buildOpenAPISpec()
+
+func buildOpenAPISpec() *OpenAPIDocument {
+
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:       "poptape-lister-redux",
+			Description: "List management for the poptape auction system - watchlists, favourites, recently viewed, bids and purchases",
+			Version:     "dev",
+		},
+		Paths: map[string]OpenAPIPathItem{},
+		Components: OpenAPIComponents{
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"accessToken": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "X-Access-Token",
+					Description: "Access token validated by the authy service",
+				},
+				"serviceKey": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "X-Service-Key",
+					Description: "API key of a trusted service, sent with X-Public-ID naming the user it acts for",
+				},
+			},
+		},
+	}
+
+	doc.Paths["/list/status"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getStatus",
+			Summary:     "System status",
+			Tags:        []string{"system"},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("System is running", objectSchema(map[string]*OpenAPISchema{
+					"message": {Type: "string"},
+					"version": {Type: "string"},
+				})),
+			},
+		},
+	}
+
+	doc.Paths["/list/health/live"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getLiveness",
+			Summary:     "Liveness probe - the process is serving requests",
+			Tags:        []string{"system"},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Process is alive", objectSchema(map[string]*OpenAPISchema{
+					"status":  {Type: "string", Enum: []string{"alive"}},
+					"version": {Type: "string"},
+				}, "status")),
+			},
+		},
+	}
+
+	healthCheck := objectSchema(map[string]*OpenAPISchema{
+		"status":     {Type: "string", Enum: []string{"up", "down"}},
+		"detail":     {Type: "string"},
+		"error":      {Type: "string", Description: "Why the dependency is down"},
+		"latency_ms": {Type: "integer"},
+	}, "status", "latency_ms")
+	healthChecks := objectSchema(map[string]*OpenAPISchema{
+		"mongodb":    healthCheck,
+		"auth":       healthCheck,
+		"migrations": healthCheck,
+	})
+	healthChecks.Description = "Result by dependency, empty while shutting down"
+	healthReport := objectSchema(map[string]*OpenAPISchema{
+		"status":     {Type: "string", Enum: []string{"ready", "not_ready", "draining"}},
+		"checked_at": {Type: "string", Format: "date-time", Description: "When the checks ran - results are cached for HEALTH_CACHE_TTL"},
+		"checks":     healthChecks,
+	}, "status", "checked_at", "checks")
+	doc.Paths["/list/health/ready"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getReadiness",
+			Summary:     "Readiness probe - MongoDB, token verification and startup indexes",
+			Tags:        []string{"system"},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Every dependency is up", healthReport),
+				"503": jsonResponse("At least one dependency is down, or the app is shutting down", healthReport),
+			},
+		},
+	}
+
+	doc.Paths["/list/openapi.json"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getOpenAPISpec",
+			Summary:     "This OpenAPI document",
+			Tags:        []string{"system"},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("OpenAPI 3 document", &OpenAPISchema{Type: "object"}),
+			},
+		},
+	}
+
+	doc.Paths["/list/auth/token"] = OpenAPIPathItem{
+		"delete": {
+			OperationID: "invalidateToken",
+			Summary:     "Drop the token in X-Access-Token from the authentication cache, e.g. on logout",
+			Tags:        []string{"auth"},
+			Parameters: []OpenAPIParameter{{
+				Name:     "X-Access-Token",
+				In:       "header",
+				Required: true,
+				Schema:   &OpenAPISchema{Type: "string"},
+			}},
+			Responses: map[string]OpenAPIResponse{
+				"204": {Description: "Token removed from the cache"},
+				"400": messageResponse("Missing X-Access-Token header"),
+			},
+		},
+	}
+
+	doc.Paths["/list/watching/{item_id}"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getWatchingCount",
+			Summary:     "Count of people watching an item",
+			Tags:        []string{"public"},
+			Parameters:  []OpenAPIParameter{uuidPathParameter("item_id", "Item UUID")},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Number of people watching the item, leaving out users who have hidden themselves", objectSchema(map[string]*OpenAPISchema{
+					"people_watching": {Type: "integer", Description: "Exact count, or a lower bound when exact is false"},
+					"display":         {Type: "string", Description: "Count as it should be shown, e.g. 7, \"fewer than 3\" or \"50+\""},
+					"exact":           {Type: "boolean", Description: "False below the privacy floor and for bucketed counts"},
+				})),
+				"400": messageResponse("Invalid item ID format"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	for _, listType := range GetListRouteTypes() {
+		addListTypePaths(doc, listType)
+	}
+
+	addSettingsPaths(doc)
+
+	addSharePaths(doc)
+
+	addAdminPaths(doc)
+
+	return doc
+}
+
+func addAdminPaths(doc *OpenAPIDocument) {
+
+	security := []map[string][]string{{"accessToken": {}}}
+	tags := []string{"admin"}
+	target := []OpenAPIParameter{
+		uuidPathParameter("public_id", "User whose list to work on"),
+		{
+			Name:     "list_type",
+			In:       "path",
+			Required: true,
+			Schema:   &OpenAPISchema{Type: "string", Enum: GetListRouteTypes()},
+		},
+	}
+	forbidden := messageResponse("Admin role required")
+
+	doc.Paths["/list/admin/users/{public_id}/{list_type}"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "adminGetList",
+			Summary:     "Get any user's list",
+			Tags:        tags,
+			Security:    security,
+			Parameters:  append(listQueryParameters(), target...),
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Item UUIDs, as for the user's own list route", &OpenAPISchema{Type: "object"}),
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+				"404": messageResponse("User has no such list"),
+			},
+		},
+		"post": {
+			OperationID: "adminAddToList",
+			Summary:     "Add an item to any user's list",
+			Tags:        tags,
+			Security:    security,
+			Parameters:  target,
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					"application/json": {Schema: uuidRequestSchema()},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"201": messageResponse("Created"),
+				"400": messageResponse("Invalid request"),
+				"401": messageResponse("Authentication required"),
+				"413": messageResponse("Request body too large"),
+				"403": forbidden,
+				"500": messageResponse("Internal server error"),
+			},
+		},
+		"delete": {
+			OperationID: "adminClearList",
+			Summary:     "Remove every item from any user's list",
+			Tags:        tags,
+			Security:    security,
+			Parameters:  target,
+			Responses: map[string]OpenAPIResponse{
+				"410": {Description: "List removed"},
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	doc.Paths["/list/admin/users/{public_id}/{list_type}/{itemId}"] = OpenAPIPathItem{
+		"delete": {
+			OperationID: "adminRemoveItemFromList",
+			Summary:     "Remove an item from any user's list",
+			Tags:        tags,
+			Security:    security,
+			Parameters:  append(append([]OpenAPIParameter{}, target...), uuidPathParameter("itemId", "Item UUID")),
+			Responses: map[string]OpenAPIResponse{
+				"204": {Description: "Item removed"},
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+			},
+		},
+	}
+
+	doc.Paths["/list/admin/items/{item_id}"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "adminFindItemHolders",
+			Summary:     "Find the users who have an item in their lists",
+			Tags:        tags,
+			Security:    security,
+			Parameters: []OpenAPIParameter{
+				uuidPathParameter("item_id", "Item UUID"),
+				{
+					Name:        "list_type",
+					In:          "query",
+					Description: "Only search this list type",
+					Schema:      &OpenAPISchema{Type: "string", Enum: GetListRouteTypes()},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("public_ids of the users holding the item, by list type", objectSchema(map[string]*OpenAPISchema{
+					"item_id": {Type: "string", Format: "uuid"},
+					"lists": {
+						Type:        "object",
+						Description: "Map of list type to public_ids",
+					},
+				})),
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	record := objectSchema(map[string]*OpenAPISchema{
+		"id":          {Type: "string", Format: "uuid"},
+		"actor_type":  {Type: "string", Enum: []string{"user", "service", "admin"}},
+		"actor_id":    {Type: "string", Description: "public_id of the user or admin, or the service's name"},
+		"public_id":   {Type: "string", Format: "uuid", Description: "User whose list was changed"},
+		"list_type":   {Type: "string", Enum: GetListRouteTypes()},
+		"operation":   {Type: "string", Enum: auditOperations},
+		"item_ids":    {Type: "array", Items: &OpenAPISchema{Type: "string", Format: "uuid"}},
+		"evicted_ids": {Type: "array", Items: &OpenAPISchema{Type: "string", Format: "uuid"}, Description: "Items pushed off the end of a full list by an add"},
+		"request_id":  {Type: "string"},
+		"at":          {Type: "string", Format: "date-time"},
+	}, "id", "actor_type", "actor_id", "public_id", "list_type", "operation", "item_ids", "at")
+
+	doc.Paths["/list/admin/audit"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "adminGetAuditRecords",
+			Summary:     "Changes made to users' lists, newest first",
+			Tags:        tags,
+			Security:    security,
+			Parameters: []OpenAPIParameter{
+				{
+					Name:        "public_id",
+					In:          "query",
+					Description: "Only changes to this user's lists",
+					Schema:      &OpenAPISchema{Type: "string", Format: "uuid"},
+				},
+				{
+					Name:        "list_type",
+					In:          "query",
+					Description: "Only changes to this list type",
+					Schema:      &OpenAPISchema{Type: "string", Enum: GetListRouteTypes()},
+				},
+				{
+					Name:        "operation",
+					In:          "query",
+					Description: "Only this kind of change",
+					Schema:      &OpenAPISchema{Type: "string", Enum: auditOperations},
+				},
+				{
+					Name:        "since",
+					In:          "query",
+					Description: "Only changes at or after this time",
+					Schema:      &OpenAPISchema{Type: "string", Format: "date-time"},
+				},
+				{
+					Name:        "until",
+					In:          "query",
+					Description: "Only changes before this time",
+					Schema:      &OpenAPISchema{Type: "string", Format: "date-time"},
+				},
+				{
+					Name:        "limit",
+					In:          "query",
+					Description: "Most records to return, default 100",
+					Schema:      &OpenAPISchema{Type: "integer", Minimum: floatPtr(1), Maximum: floatPtr(maxAuditQueryLimit)},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Matching audit records", objectSchema(map[string]*OpenAPISchema{
+					"records":  {Type: "array", Items: record},
+					"has_more": {Type: "boolean", Description: "True when older records match; pass the last record's at as until to read them"},
+				})),
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	levelNames := []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
+	levels := objectSchema(map[string]*OpenAPISchema{
+		"level":      {Type: "string", Description: "Level in force for logs outside the named components"},
+		"configured": {Type: "string", Description: "Level from LOGLEVEL"},
+		"expires_at": {Type: "string", Format: "date-time", Description: "When the override on level reverts"},
+		"components": {
+			Type:        "object",
+			Description: "Map of component to its level, whether that is an override, and when the override reverts",
+		},
+	}, "level", "configured", "components")
+	levelRequest := objectSchema(map[string]*OpenAPISchema{
+		"level":        {Type: "string", Enum: levelNames},
+		"component":    {Type: "string", Enum: logComponents, Description: "Only change this component; everything when left out"},
+		"revert_after": {Type: "string", Description: "Go duration such as 15m after which the override is removed, at most 24h"},
+	}, "level")
+	levelRequest.AdditionalProperties = boolPtr(false)
+
+	doc.Paths["/list/admin/log-level"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "adminGetLogLevels",
+			Summary:     "Log levels in force on the replica that answers",
+			Tags:        tags,
+			Security:    security,
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Log levels", levels),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+			},
+		},
+		"put": {
+			OperationID: "adminSetLogLevel",
+			Summary:     "Override the log level on the replica that answers",
+			Tags:        tags,
+			Security:    security,
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					"application/json": {Schema: levelRequest},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Log levels after the change", levels),
+				"400": messageResponse("Invalid request body"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+				"413": messageResponse("Request body too large"),
+			},
+		},
+		"delete": {
+			OperationID: "adminRevertLogLevels",
+			Summary:     "Remove log level overrides on the replica that answers",
+			Tags:        tags,
+			Security:    security,
+			Parameters: []OpenAPIParameter{
+				{
+					Name:        "component",
+					In:          "query",
+					Description: "Only revert this component; every override when left out",
+					Schema:      &OpenAPISchema{Type: "string", Enum: logComponents},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Log levels after the change", levels),
+				"400": messageResponse("Invalid parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": forbidden,
+			},
+		},
+	}
+}
+
+func addSettingsPaths(doc *OpenAPIDocument) {
+
+	security := []map[string][]string{{"accessToken": {}}}
+	tags := []string{"settings"}
+	settings := objectSchema(map[string]*OpenAPISchema{
+		"hide_from_watcher_counts": {Type: "boolean", Description: "Leave the user out of public watcher counts"},
+	})
+	request := objectSchema(map[string]*OpenAPISchema{
+		"hide_from_watcher_counts": {Type: "boolean"},
+	}, "hide_from_watcher_counts")
+	request.AdditionalProperties = boolPtr(false)
+
+	doc.Paths["/list/settings"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getSettings",
+			Summary:     "Get the current user's settings",
+			Tags:        tags,
+			Security:    security,
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("The user's settings", settings),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Services can't use settings routes"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+		"patch": {
+			OperationID: "updateSettings",
+			Summary:     "Change the current user's settings",
+			Tags:        tags,
+			Security:    security,
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					"application/json": {Schema: request},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("The updated settings", settings),
+				"400": messageResponse("Invalid request body"),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Services can't use settings routes"),
+				"413": messageResponse("Request body too large"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+}
+
+func addSharePaths(doc *OpenAPIDocument) {
+
+	security := []map[string][]string{{"accessToken": {}}}
+	tags := []string{"shares"}
+	share := objectSchema(map[string]*OpenAPISchema{
+		"id":             {Type: "string", Format: "uuid"},
+		"list_type":      {Type: "string", Enum: GetShareableListTypes()},
+		"created_at":     {Type: "string", Format: "date-time"},
+		"expires_at":     {Type: "string", Format: "date-time", Description: "Absent for shares that don't expire"},
+		"view_count":     {Type: "integer", Description: "Times the shared list has been read"},
+		"last_viewed_at": {Type: "string", Format: "date-time"},
+	}, "id", "list_type", "created_at", "view_count")
+	newShare := objectSchema(map[string]*OpenAPISchema{
+		"id":         {Type: "string", Format: "uuid"},
+		"list_type":  {Type: "string", Enum: GetShareableListTypes()},
+		"created_at": {Type: "string", Format: "date-time"},
+		"expires_at": {Type: "string", Format: "date-time"},
+		"view_count": {Type: "integer"},
+		"token":      {Type: "string", Description: "Share token, only ever returned here"},
+		"path":       {Type: "string", Description: "Path of the shared list"},
+	}, "id", "list_type", "created_at", "token", "path")
+	request := objectSchema(map[string]*OpenAPISchema{
+		"list_type":  {Type: "string", Enum: GetShareableListTypes()},
+		"expires_at": {Type: "string", Format: "date-time", Description: "When the share stops working, never if left out"},
+	}, "list_type")
+	request.AdditionalProperties = boolPtr(false)
+
+	doc.Paths["/list/shares"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getShares",
+			Summary:     "List the current user's active shares, newest first",
+			Tags:        tags,
+			Security:    security,
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Shares that haven't expired", objectSchema(map[string]*OpenAPISchema{
+					"shares": {Type: "array", Items: share},
+				})),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Services can't use share routes"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+		"post": {
+			OperationID: "createShare",
+			Summary:     "Make a read only link to one of the current user's lists",
+			Tags:        tags,
+			Security:    security,
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					"application/json": {Schema: request},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"201": jsonResponse("The new share and its token", newShare),
+				"400": messageResponse("Invalid request body"),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Services can't use share routes"),
+				"413": messageResponse("Request body too large"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	doc.Paths["/list/shares/{share_id}"] = OpenAPIPathItem{
+		"delete": {
+			OperationID: "revokeShare",
+			Summary:     "Revoke one of the current user's shares",
+			Tags:        tags,
+			Security:    security,
+			Parameters:  []OpenAPIParameter{uuidPathParameter("share_id", "Share ID")},
+			Responses: map[string]OpenAPIResponse{
+				"204": {Description: "Share revoked"},
+				"400": messageResponse("Invalid share ID format"),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Services can't use share routes"),
+				"404": messageResponse("Share not found"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	listProperties := map[string]*OpenAPISchema{
+		"list_type":  {Type: "string", Enum: GetShareableListTypes()},
+		"expires_at": {Type: "string", Format: "date-time"},
+		"items":      {Type: "array", Items: expandedItemSchema(), Description: "Present with expand=item"},
+	}
+	for _, listType := range GetShareableListTypes() {
+		listProperties[listType] = &OpenAPISchema{Type: "array", Items: &OpenAPISchema{Type: "string", Format: "uuid"},
+			Description: "Item UUIDs, under the shared list's type"}
+	}
+
+	doc.Paths["/list/shared/{token}"] = OpenAPIPathItem{
+		"get": {
+			OperationID: "getSharedList",
+			Summary:     "Read a shared list",
+			Tags:        []string{"public", "shares"},
+			Parameters: []OpenAPIParameter{
+				{
+					Name:     "token",
+					In:       "path",
+					Required: true,
+					Schema:   &OpenAPISchema{Type: "string"},
+				},
+				{
+					Name:        "expand",
+					In:          "query",
+					Description: "Merge item details into the response",
+					Schema:      &OpenAPISchema{Type: "string", Enum: []string{"item"}},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Item UUIDs in the shared list", objectSchema(listProperties, "list_type")),
+				"400": messageResponse("Invalid query parameter"),
+				"404": messageResponse("Share not found, revoked or expired"),
+				"500": messageResponse("Internal server error"),
+				"501": messageResponse("Item expansion is not available"),
+			},
+		},
+	}
+}
+
+func addListTypePaths(doc *OpenAPIDocument, listType string) {
+
+	security := []map[string][]string{{"accessToken": {}}, {"serviceKey": {}}}
+	tags := []string{listType}
+	name := strings.ToUpper(listType[:1]) + listType[1:]
+	actingFor := publicIDHeaderParameter()
+
+	doc.Paths["/list/"+listType] = OpenAPIPathItem{
+		"get": {
+			OperationID: "get" + name,
+			Summary:     "Get the current user's " + listType,
+			Tags:        tags,
+			Security:    security,
+			Parameters:  append(listQueryParameters(), actingFor),
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("Item UUIDs, most recent first unless sorted", objectSchema(map[string]*OpenAPISchema{
+					listType:      {Type: "array", Items: &OpenAPISchema{Type: "string", Format: "uuid"}},
+					"entries":     {Type: "array", Items: listEntrySchema(), Description: "Present when filtering, sorting or paging"},
+					"next_cursor": {Type: "string", Description: "Pass as cursor to fetch the next page, absent on the last page"},
+					"items":       {Type: "array", Items: expandedItemSchema(), Description: "Present with expand=item"},
+				})),
+				"400": messageResponse("Invalid query parameter"),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Service not permitted to read this list"),
+				"404": messageResponse("No " + listType + " for current user"),
+				"501": messageResponse("Item expansion is not available"),
+			},
+		},
+		"post": {
+			OperationID: "addTo" + name,
+			Summary:     "Add an item to the current user's " + listType,
+			Tags:        tags,
+			Security:    security,
+			Parameters:  []OpenAPIParameter{actingFor},
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					"application/json": {Schema: uuidRequestSchema()},
+				},
+			},
+			Responses: map[string]OpenAPIResponse{
+				"201": messageResponse("Created"),
+				"400": messageResponse("Invalid request body"),
+				"401": messageResponse("Authentication required"),
+				"413": messageResponse("Request body too large"),
+				"403": messageResponse("Not permitted to change this list"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+		"delete": {
+			OperationID: "removeAllFrom" + name,
+			Summary:     "Remove every item from the current user's " + listType,
+			Tags:        tags,
+			Security:    security,
+			Parameters:  []OpenAPIParameter{actingFor},
+			Responses: map[string]OpenAPIResponse{
+				"410": {Description: "List removed"},
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Not permitted to change this list"),
+				"500": messageResponse("Internal server error"),
+			},
+		},
+	}
+
+	doc.Paths["/list/"+listType+"/{itemId}"] = OpenAPIPathItem{
+		"delete": {
+			OperationID: "removeItemFrom" + name,
+			Summary:     "Remove an item from the current user's " + listType,
+			Tags:        tags,
+			Security:    security,
+			Parameters:  []OpenAPIParameter{uuidPathParameter("itemId", "Item UUID"), actingFor},
+			Responses: map[string]OpenAPIResponse{
+				"204": {Description: "Item removed"},
+				"400": messageResponse("Bad request"),
+				"401": messageResponse("Authentication required"),
+				"403": messageResponse("Not permitted to change this list"),
+			},
+		},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Schema building helpers
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func objectSchema(properties map[string]*OpenAPISchema, required ...string) *OpenAPISchema {
+	return &OpenAPISchema{
+		Type:       "object",
+		Properties: properties,
+		Required:   required,
+	}
+}
+
+func uuidRequestSchema() *OpenAPISchema {
+	s := objectSchema(map[string]*OpenAPISchema{
+		"uuid": {Type: "string", Format: "uuid", Description: "Item UUID"},
+	}, "uuid")
+	s.AdditionalProperties = boolPtr(false)
+	return s
+}
+
+func listEntrySchema() *OpenAPISchema {
+	return objectSchema(map[string]*OpenAPISchema{
+		"item_id":  {Type: "string", Format: "uuid"},
+		"added_at": {Type: "string", Format: "date-time"},
+	}, "item_id", "added_at")
+}
+
+func expandedItemSchema() *OpenAPISchema {
+	return objectSchema(map[string]*OpenAPISchema{
+		"item_id": {Type: "string", Format: "uuid"},
+		"details": {Type: "object", Description: "Item as returned by the items service"},
+		"error":   {Type: "string", Description: "Why the details could not be fetched"},
+	}, "item_id")
+}
+
+func listQueryParameters() []OpenAPIParameter {
+	return []OpenAPIParameter{
+		{
+			Name:        "since",
+			In:          "query",
+			Description: "Only items added at or after this time",
+			Schema:      &OpenAPISchema{Type: "string", Format: "date-time"},
+		},
+		{
+			Name:        "until",
+			In:          "query",
+			Description: "Only items added before this time",
+			Schema:      &OpenAPISchema{Type: "string", Format: "date-time"},
+		},
+		{
+			Name:        "sort",
+			In:          "query",
+			Description: "Sort by time added, oldest first (added_at) or newest first (-added_at)",
+			Schema:      &OpenAPISchema{Type: "string", Enum: []string{"added_at", "-added_at"}},
+		},
+		{
+			Name:        "order",
+			In:          "query",
+			Description: "Sort direction, overrides the direction given by sort",
+			Schema:      &OpenAPISchema{Type: "string", Enum: []string{"asc", "desc"}},
+		},
+		{
+			Name:        "limit",
+			In:          "query",
+			Description: "Page size, capped at MAX_PAGE_SIZE",
+			Schema:      &OpenAPISchema{Type: "integer", Minimum: floatPtr(1)},
+		},
+		{
+			Name:        "cursor",
+			In:          "query",
+			Description: "Opaque next_cursor value from the previous page",
+			Schema:      &OpenAPISchema{Type: "string"},
+		},
+		{
+			Name:        "expand",
+			In:          "query",
+			Description: "Merge item details into the response",
+			Schema:      &OpenAPISchema{Type: "string", Enum: []string{"item"}},
+		},
+	}
+}
+
+func uuidPathParameter(name, description string) OpenAPIParameter {
+	return OpenAPIParameter{
+		Name:        name,
+		In:          "path",
+		Description: description,
+		Required:    true,
+		Schema:      &OpenAPISchema{Type: "string", Format: "uuid"},
+	}
+}
+
+func publicIDHeaderParameter() OpenAPIParameter {
+	return OpenAPIParameter{
+		Name:        "X-Public-ID",
+		In:          "header",
+		Description: "User a service is acting for, required with X-Service-Key",
+		Schema:      &OpenAPISchema{Type: "string", Format: "uuid"},
+	}
+}
+
+func jsonResponse(description string, schema *OpenAPISchema) OpenAPIResponse {
+	return OpenAPIResponse{
+		Description: description,
+		Content: map[string]OpenAPIMediaType{
+			"application/json": {Schema: schema},
+		},
+	}
+}
+
+func messageResponse(description string) OpenAPIResponse {
+	return jsonResponse(description, objectSchema(map[string]*OpenAPISchema{
+		"message":    {Type: "string"},
+		"request_id": {Type: "string", Description: "The request's X-Request-ID, on error responses"},
+	}, "message"))
+}
+
+//-----------------------------------------------------------------------------
+// Route lookup
+
+// GinPathToOpenAPI converts a gin route template such as /list/watching/:item_id
+// to its OpenAPI form /list/watching/{item_id}
+func GinPathToOpenAPI(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Operation returns the operation documented for a gin route template and
+// method or nil if the spec does not describe it
+func (d *OpenAPIDocument) Operation(method, ginPath string) *OpenAPIOperation {
+	item, ok := d.Paths[GinPathToOpenAPI(ginPath)]
+	if !ok {
+		return nil
+	}
+	return item[strings.ToLower(method)]
+}
+
+//-----------------------------------------------------------------------------
+// Handlers and middleware
+
+// GetOpenAPISpec serves the document with the running version
+func (a *App) GetOpenAPISpec(c *gin.Context) {
+	doc := *openAPISpec
+	if version := a.config().Version; version != "" {
+		doc.Info.Version = version
+	}
+	c.JSON(http.StatusOK, &doc)
+}
+
+// OpenAPIValidationMiddleware rejects requests whose parameters or body do not
+// match the operation documented for the matched route. Routes missing from
+// the spec are passed through untouched.
+func (a *App) OpenAPIValidationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := openAPISpec.Operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		problems := validateRequestParameters(c, op.Parameters)
+
+		if op.RequestBody != nil {
+			problems = append(problems, validateRequestBody(c, op.RequestBody)...)
+		}
+
+		if len(problems) > 0 {
+			a.requestLog(c).Debug().Strs("problems", problems).Msg("Request failed OpenAPI validation")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Request does not match API specification",
+				"errors":  problems,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func validateRequestParameters(c *gin.Context, params []OpenAPIParameter) []string {
+	var problems []string
+	for _, p := range params {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = c.Param(p.Name)
+			present = value != ""
+		case "query":
+			value, present = c.GetQuery(p.Name)
+		case "header":
+			value = c.GetHeader(p.Name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				problems = append(problems, fmt.Sprintf("%s parameter %s: is required", p.In, p.Name))
+			}
+			continue
+		}
+
+		if p.Schema != nil {
+			for _, msg := range validateStringValue(value, p.Schema) {
+				problems = append(problems, fmt.Sprintf("%s parameter %s: %s", p.In, p.Name, msg))
+			}
+		}
+	}
+	return problems
+}
+
+func validateRequestBody(c *gin.Context, rb *OpenAPIRequestBody) []string {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return []string{fmt.Sprintf("body: is larger than %d bytes", tooLarge.Limit)}
+		}
+		return []string{"body: could not be read"}
+	}
+	// put the body back for the handler
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return []string{"body: is required"}
+		}
+		return nil
+	}
+
+	media, ok := rb.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{"body: is not valid JSON"}
+	}
+
+	return validateValue(value, media.Schema, "body")
+}
+
+// validateStringValue checks a raw path, query or header value against a
+// scalar schema
+func validateStringValue(value string, s *OpenAPISchema) []string {
+	switch s.Type {
+	case "integer":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return []string{"must be an integer"}
+		}
+		return validateNumberRange(float64(n), s)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []string{"must be a number"}
+		}
+		return validateNumberRange(n, s)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return []string{"must be a boolean"}
+		}
+		return nil
+	}
+	return validateStringFormat(value, s)
+}
+
+func validateStringFormat(value string, s *OpenAPISchema) []string {
+	var problems []string
+	switch s.Format {
+	case "uuid":
+		if ValidateUUIDFormat(value) != nil {
+			problems = append(problems, "must be a UUID")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			problems = append(problems, "must be an RFC3339 date-time")
+		}
+	}
+	if len(s.Enum) > 0 && !Contains(s.Enum, value) {
+		problems = append(problems, "must be one of "+strings.Join(s.Enum, ", "))
+	}
+	return problems
+}
+
+func validateNumberRange(n float64, s *OpenAPISchema) []string {
+	if s.Minimum != nil && n < *s.Minimum {
+		return []string{fmt.Sprintf("must be at least %v", *s.Minimum)}
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return []string{fmt.Sprintf("must be at most %v", *s.Maximum)}
+	}
+	return nil
+}
+
+// validateValue checks a decoded JSON value against a schema
+func validateValue(value interface{}, s *OpenAPISchema, path string) []string {
+	var problems []string
+	prefix := func(msg string) string { return path + ": " + msg }
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{prefix("must be an object")}
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, path+"."+name+": is required")
+			}
+		}
+		for name, v := range obj {
+			ps, known := s.Properties[name]
+			if !known {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, path+"."+name+": is not allowed")
+				}
+				continue
+			}
+			problems = append(problems, validateValue(v, ps, path+"."+name)...)
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return []string{prefix("must be an array")}
+		}
+		if s.Items != nil {
+			for i, v := range arr {
+				problems = append(problems, validateValue(v, s.Items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{prefix("must be a string")}
+		}
+		for _, msg := range validateStringFormat(str, s) {
+			problems = append(problems, prefix(msg))
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return []string{prefix("must be a number")}
+		}
+		if s.Type == "integer" && n != float64(int64(n)) {
+			return []string{prefix("must be an integer")}
+		}
+		for _, msg := range validateNumberRange(n, s) {
+			problems = append(problems, prefix(msg))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{prefix("must be a boolean")}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	t.Run("should describe every registered route", func(t *testing.T) {
+		app := &App{Log: &logger, Config: testConfig(t)}
+		app.Router = gin.New()
+		app.initialiseRoutes()
+
+		routes := app.Router.Routes()
+		require.NotEmpty(t, routes)
+
+		for _, route := range routes {
+			assert.NotNil(t, openAPISpec.Operation(route.Method, route.Path),
+				"Route %s %s is missing from the OpenAPI spec", route.Method, route.Path)
+		}
+	})
+
+	t.Run("should serve the spec as JSON", func(t *testing.T) {
+		app := &App{Log: &logger, Config: testConfig(t)}
+		app.Router = gin.New()
+		app.initialiseRoutes()
+
+		req := httptest.NewRequest("GET", "/list/openapi.json", nil)
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var doc OpenAPIDocument
+		err := json.Unmarshal(resp.Body.Bytes(), &doc)
+		require.NoError(t, err)
+		assert.Equal(t, "3.0.3", doc.OpenAPI)
+		assert.Contains(t, doc.Paths, "/list/watching/{item_id}")
+		assert.Contains(t, doc.Paths, "/list/watchlist/{itemId}")
+	})
+
+	t.Run("should convert gin paths to OpenAPI paths", func(t *testing.T) {
+		assert.Equal(t, "/list/watching/{item_id}", GinPathToOpenAPI("/list/watching/:item_id"))
+		assert.Equal(t, "/list/watchlist", GinPathToOpenAPI("/list/watchlist"))
+		assert.Equal(t, "/files/{path}", GinPathToOpenAPI("/files/*path"))
+	})
+}
+
+func TestOpenAPIValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := &App{Log: &logger, Config: testConfig(t)}
+
+	router := gin.New()
+	router.Use(app.OpenAPIValidationMiddleware())
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	}
+	router.POST("/list/watchlist", ok)
+	router.DELETE("/list/watchlist/:itemId", ok)
+	router.GET("/not/in/spec", ok)
+
+	doRequest := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should allow a valid body", func(t *testing.T) {
+		resp := doRequest("POST", "/list/watchlist", `{"uuid":"987fcdeb-51a2-43d7-890e-123456789abc"}`)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should reject a missing body", func(t *testing.T) {
+		resp := doRequest("POST", "/list/watchlist", "")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "body: is required")
+	})
+
+	t.Run("should reject unknown fields and bad formats", func(t *testing.T) {
+		resp := doRequest("POST", "/list/watchlist", `{"uuid":"not-a-uuid","extra":1}`)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		var response struct {
+			Errors []string `json:"errors"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Contains(t, response.Errors, "body.uuid: must be a UUID")
+		assert.Contains(t, response.Errors, "body.extra: is not allowed")
+	})
+
+	t.Run("should reject a missing required property", func(t *testing.T) {
+		resp := doRequest("POST", "/list/watchlist", `{}`)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "body.uuid: is required")
+	})
+
+	t.Run("should validate path parameters", func(t *testing.T) {
+		resp := doRequest("DELETE", "/list/watchlist/not-a-uuid", "")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "path parameter itemId: must be a UUID")
+
+		resp = doRequest("DELETE", "/list/watchlist/987fcdeb-51a2-43d7-890e-123456789abc", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should pass through routes not in the spec", func(t *testing.T) {
+		resp := doRequest("GET", "/not/in/spec", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorCodec(t *testing.T) {
+	cursor := ListCursor{
+		ListType:  "viewed",
+		AddedAt:   time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Position:  12,
+		Ascending: true,
+	}
+
+	t.Run("should round trip a cursor", func(t *testing.T) {
+		codec, err := NewCursorCodec("test-secret")
+		require.NoError(t, err)
+
+		encoded, err := codec.Encode(cursor)
+		require.NoError(t, err)
+		assert.NotContains(t, encoded, "viewed")
+
+		decoded, err := codec.Decode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, cursor.ListType, decoded.ListType)
+		assert.True(t, cursor.AddedAt.Equal(decoded.AddedAt))
+		assert.Equal(t, cursor.Position, decoded.Position)
+		assert.Equal(t, cursor.Ascending, decoded.Ascending)
+	})
+
+	t.Run("should reject tampered cursors", func(t *testing.T) {
+		codec, err := NewCursorCodec("test-secret")
+		require.NoError(t, err)
+
+		encoded, err := codec.Encode(cursor)
+		require.NoError(t, err)
+
+		other, err := codec.Encode(ListCursor{ListType: "viewed", Position: 0})
+		require.NoError(t, err)
+
+		payload, _, _ := strings.Cut(other, ".")
+		_, sig, _ := strings.Cut(encoded, ".")
+
+		_, err = codec.Decode(payload + "." + sig)
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+
+		_, err = codec.Decode("not-a-cursor")
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("should reject cursors signed with another key", func(t *testing.T) {
+		codec1, err := NewCursorCodec("")
+		require.NoError(t, err)
+		codec2, err := NewCursorCodec("")
+		require.NoError(t, err)
+
+		encoded, err := codec1.Encode(cursor)
+		require.NoError(t, err)
+
+		_, err = codec2.Decode(encoded)
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// Rate limiting
+// token buckets holding RATE_LIMIT_REQUESTS tokens that refill over
+// RATE_LIMIT_WINDOW. Authenticated requests are limited per public_id (or per
+// service), public ones per client IP. RATE_LIMIT_ROUTES gives individual
+// routes their own limits, e.g.
+//
+//	RATE_LIMIT_ROUTES=GET /list/watching/:item_id=30/1m,POST /list/viewed=300/1m
+
+type RateLimitRule struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Disabled reports whether the rule lets everything through
+func (r RateLimitRule) Disabled() bool {
+	return r.Requests <= 0 || r.Window <= 0
+}
+
+// RateLimitDecision is the outcome of taking a token, with what's needed for
+// the RateLimit-* headers
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+//-----------------------------------------------------------------------------
+// Token buckets
+// the in-memory store
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	window time.Duration
+}
+
+type TokenBucketLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// rateLimitSweepInterval is how often idle buckets are looked for
+const rateLimitSweepInterval = time.Minute
+
+func NewTokenBucketLimiter() *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Take removes a token from key's bucket if there is one
+func (l *TokenBucketLimiter) Take(_ context.Context, key string, rule RateLimitRule) (RateLimitDecision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	capacity := float64(rule.Requests)
+	perToken := rule.Window / time.Duration(rule.Requests)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now, window: rule.Window}
+		l.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.last)
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(perToken))
+		b.last = now
+	}
+
+	d := RateLimitDecision{Limit: rule.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = time.Duration((capacity - b.tokens) * float64(perToken))
+	return d, nil
+}
+
+// sweep drops buckets idle long enough to have refilled, as they are no
+// different from a new bucket
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of live buckets
+func (l *TokenBucketLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+//-----------------------------------------------------------------------------
+// Limiter configuration
+
+type RateLimiter struct {
+	Default RateLimitRule
+	Routes  map[string]RateLimitRule
+	Store   RateLimitStore
+}
+
+// RateLimitConfig holds the RATE_LIMIT_* settings
+type RateLimitConfig struct {
+	Default      RateLimitRule
+	Routes       map[string]RateLimitRule
+	Store        string
+	Algorithm    string
+	StoreTimeout time.Duration
+}
+
+// NewRateLimitConfig reads RATE_LIMIT_REQUESTS, RATE_LIMIT_WINDOW,
+// RATE_LIMIT_ROUTES and the shared store settings. RATE_LIMIT_REQUESTS=0
+// turns limiting off
+func NewRateLimitConfig(s Settings) (*RateLimitConfig, error) {
+	r := &configReader{s: s}
+	config := &RateLimitConfig{
+		Default: RateLimitRule{
+			Requests: r.int("RATE_LIMIT_REQUESTS", 100, 0),
+			Window:   r.duration("RATE_LIMIT_WINDOW", 60*time.Second),
+		},
+		Store:        r.oneOf("RATE_LIMIT_STORE", "memory", "memory", "mongo"),
+		Algorithm:    r.oneOf("RATE_LIMIT_ALGORITHM", "sliding", "sliding", "fixed"),
+		StoreTimeout: r.duration("RATE_LIMIT_STORE_TIMEOUT", 250*time.Millisecond),
+	}
+	if config.StoreTimeout == 0 {
+		r.fail(fmt.Errorf("RATE_LIMIT_STORE_TIMEOUT must be more than 0"))
+	}
+	routes, err := ParseRateLimitRoutes(s.GetOrDefault("RATE_LIMIT_ROUTES", ""))
+	if err != nil {
+		r.fail(fmt.Errorf("invalid RATE_LIMIT_ROUTES: %w", err))
+	}
+	config.Routes = routes
+
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewRateLimiter builds a limiter counting in memory
+func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
+	return &RateLimiter{Default: config.Default, Routes: config.Routes, Store: NewTokenBucketLimiter()}
+}
+
+// ParseRateLimitRoutes parses comma separated "METHOD /path=requests/window"
+// overrides, keyed by "METHOD /path" with the path as registered in gin
+func ParseRateLimitRoutes(raw string) (map[string]RateLimitRule, error) {
+	routes := map[string]RateLimitRule{}
+	for _, spec := range strings.Split(raw, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(spec, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !hasPath || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("%q should look like \"GET /list/path=requests/window\"", spec)
+		}
+		requests, window, ok := strings.Cut(limit, "/")
+		if !ok {
+			return nil, fmt.Errorf("%q should give its limit as requests/window", spec)
+		}
+		n, err := strconv.Atoi(requests)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q has an invalid request count", spec)
+		}
+		w, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("%q has an invalid window: %w", spec, err)
+		}
+		routes[strings.ToUpper(method)+" "+path] = RateLimitRule{Requests: n, Window: w}
+	}
+	return routes, nil
+}
+
+// ruleFor returns the rule for a route and the name its buckets live under
+func (rl *RateLimiter) ruleFor(method, path string) (string, RateLimitRule) {
+	route := method + " " + path
+	if rule, ok := rl.Routes[route]; ok {
+		return route, rule
+	}
+	return "default", rl.Default
+}
+
+// rateLimitSubject is who a request is limited as - the service or user
+// when authenticated, otherwise the client IP
+func rateLimitSubject(c *gin.Context) string {
+	if v, ok := c.Get("service"); ok {
+		return "service:" + v.(*ServiceIdentity).Name
+	}
+	if publicID, ok := c.Get("public_id"); ok {
+		return "user:" + publicID.(string)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// initialiseRateLimiting sets up the limiter, sharing counts through MongoDB
+// when RATE_LIMIT_STORE is mongo
+func (a *App) initialiseRateLimiting() {
+	config := a.config().RateLimit
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid rate limit settings")
+	}
+	rl := NewRateLimiter(config)
+
+	if config.Store == "mongo" {
+		sliding := config.Algorithm == "sliding"
+		mongoStore := NewMongoRateLimitStore(a.GetCollection(rateLimitCollection), sliding, config.StoreTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := mongoStore.EnsureIndexes(ctx); err != nil {
+			a.Log.Error().Err(err).Msg("Failed to create rate limit TTL index")
+		}
+		rl.Store = NewFallbackRateLimitStore(mongoStore, rl.Store, a.Log)
+		a.Log.Info().Bool("sliding", sliding).Msg("Sharing rate limits through MongoDB")
+	}
+
+	a.rateLimits = rl
+}
+
+// rateLimiter returns the app's limiter, taking it from the config on first
+// use
+func (a *App) rateLimiter() *RateLimiter {
+	a.rateLimitOnce.Do(func() {
+		if a.rateLimits != nil {
+			return
+		}
+		config := a.config().RateLimit
+		if config == nil {
+			a.Log.Error().Msg("Invalid rate limit settings, rate limiting disabled")
+			a.rateLimits = &RateLimiter{Store: NewTokenBucketLimiter()}
+			return
+		}
+		a.rateLimits = NewRateLimiter(config)
+	})
+	return a.rateLimits
+}
+
+// seconds rounds a duration up to whole seconds for headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// RateLimitMiddleware takes a token for the request and answers 429 when the
+// bucket is empty. Register it after AuthMiddleware on authenticated routes
+// so requests are limited per user rather than per IP
+func (a *App) RateLimitMiddleware() gin.HandlerFunc {
+	limiter := a.rateLimiter()
+	return func(c *gin.Context) {
+		name, rule := limiter.ruleFor(c.Request.Method, c.FullPath())
+		if rule.Disabled() {
+			c.Next()
+			return
+		}
+
+		d, err := limiter.Store.Take(c.Request.Context(), name+"|"+rateLimitSubject(c), rule)
+		if err != nil {
+			// fail open rather than lock everyone out
+			a.requestLog(c).Error().Err(err).Msg("Rate limit check failed")
+			c.Next()
+			return
+		}
+		c.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		c.Header("RateLimit-Reset", seconds(d.Reset))
+
+		if !d.Allowed {
+			c.Header("Retry-After", seconds(d.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// take calls a store that never fails
+func take(store RateLimitStore, key string, rule RateLimitRule) RateLimitDecision {
+	d, _ := store.Take(context.Background(), key, rule)
+	return d
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	now := time.Now()
+	rule := RateLimitRule{Requests: 3, Window: 3 * time.Second}
+
+	t.Run("should allow a burst up to the limit then refuse", func(t *testing.T) {
+		l := NewTokenBucketLimiter()
+		l.now = func() time.Time { return now }
+
+		for i := 0; i < 3; i++ {
+			d := take(l, "a", rule)
+			require.True(t, d.Allowed)
+			assert.Equal(t, 2-i, d.Remaining)
+		}
+
+		d := take(l, "a", rule)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, 0, d.Remaining)
+		assert.Equal(t, time.Second, d.RetryAfter)
+		assert.Equal(t, 3*time.Second, d.Reset)
+
+		// other keys have their own bucket
+		assert.True(t, take(l, "b", rule).Allowed)
+	})
+
+	t.Run("should refill over the window", func(t *testing.T) {
+		clock := now
+		l := NewTokenBucketLimiter()
+		l.now = func() time.Time { return clock }
+
+		for i := 0; i < 3; i++ {
+			take(l, "a", rule)
+		}
+		assert.False(t, take(l, "a", rule).Allowed)
+
+		clock = clock.Add(time.Second)
+		assert.True(t, take(l, "a", rule).Allowed)
+		assert.False(t, take(l, "a", rule).Allowed)
+	})
+
+	t.Run("should evict idle buckets", func(t *testing.T) {
+		clock := now
+		l := NewTokenBucketLimiter()
+		l.now = func() time.Time { return clock }
+
+		take(l, "a", rule)
+		take(l, "b", rule)
+		assert.Equal(t, 2, l.Len())
+
+		clock = clock.Add(2 * rateLimitSweepInterval)
+		take(l, "c", rule)
+		assert.Equal(t, 1, l.Len())
+	})
+}
+
+func TestParseRateLimitRoutes(t *testing.T) {
+	t.Run("should parse route overrides", func(t *testing.T) {
+		routes, err := ParseRateLimitRoutes("GET /list/watching/:item_id=30/1m, post /list/viewed=300/60s")
+		require.NoError(t, err)
+		assert.Equal(t, RateLimitRule{Requests: 30, Window: time.Minute}, routes["GET /list/watching/:item_id"])
+		assert.Equal(t, RateLimitRule{Requests: 300, Window: time.Minute}, routes["POST /list/viewed"])
+	})
+
+	t.Run("should reject malformed overrides", func(t *testing.T) {
+		for _, raw := range []string{
+			"/list/viewed=30/1m",
+			"GET /list/viewed",
+			"GET /list/viewed=30",
+			"GET /list/viewed=many/1m",
+			"GET /list/viewed=30/soon",
+		} {
+			_, err := ParseRateLimitRoutes(raw)
+			assert.Error(t, err, raw)
+		}
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	newRouter := func(limiter *RateLimiter, publicID string) *gin.Engine {
+		app := &App{Log: &logger, Config: testConfig(t), rateLimits: limiter}
+		router := gin.New()
+		if publicID != "" {
+			router.Use(func(c *gin.Context) { c.Set("public_id", publicID) })
+		}
+		router.Use(app.RateLimitMiddleware())
+		router.GET("/list/watching/:item_id", func(c *gin.Context) { c.Status(http.StatusOK) })
+		router.GET("/list/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return router
+	}
+
+	send := func(router *gin.Engine, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should answer 429 with headers once the bucket is empty", func(t *testing.T) {
+		router := newRouter(&RateLimiter{
+			Default: RateLimitRule{Requests: 2, Window: time.Minute},
+			Store:   NewTokenBucketLimiter(),
+		}, "")
+
+		resp := send(router, "/list/status", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "2", resp.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", resp.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", resp.Header().Get("RateLimit-Reset"))
+
+		send(router, "/list/status", "192.0.2.1:1234")
+		resp = send(router, "/list/status", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Equal(t, "0", resp.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+
+		// a different client has its own bucket
+		resp = send(router, "/list/status", "192.0.2.2:1234")
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should limit authenticated requests per user", func(t *testing.T) {
+		limiter := &RateLimiter{
+			Default: RateLimitRule{Requests: 1, Window: time.Minute},
+			Store:   NewTokenBucketLimiter(),
+		}
+		alice := newRouter(limiter, "aaaaaaaa-aaaa-4aaa-8aaa-aaaaaaaaaaaa")
+		bob := newRouter(limiter, "bbbbbbbb-bbbb-4bbb-8bbb-bbbbbbbbbbbb")
+
+		assert.Equal(t, http.StatusOK, send(alice, "/list/status", "192.0.2.1:1234").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(alice, "/list/status", "192.0.2.9:1234").Code)
+		assert.Equal(t, http.StatusOK, send(bob, "/list/status", "192.0.2.1:1234").Code)
+	})
+
+	t.Run("should apply route overrides", func(t *testing.T) {
+		router := newRouter(&RateLimiter{
+			Default: RateLimitRule{Requests: 1, Window: time.Minute},
+			Routes: map[string]RateLimitRule{
+				"GET /list/watching/:item_id": {Requests: 3, Window: time.Minute},
+			},
+			Store: NewTokenBucketLimiter(),
+		}, "")
+
+		for i := 0; i < 3; i++ {
+			resp := send(router, "/list/watching/123e4567-e89b-12d3-a456-426614174000", "192.0.2.1:1234")
+			assert.Equal(t, http.StatusOK, resp.Code)
+		}
+		resp := send(router, "/list/watching/123e4567-e89b-12d3-a456-426614174000", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+		// the override has its own bucket, so the default quota is untouched
+		assert.Equal(t, http.StatusOK, send(router, "/list/status", "192.0.2.1:1234").Code)
+	})
+
+	t.Run("should let everything through when disabled", func(t *testing.T) {
+		router := newRouter(&RateLimiter{Store: NewTokenBucketLimiter()}, "")
+		for i := 0; i < 5; i++ {
+			resp := send(router, "/list/status", "192.0.2.1:1234")
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Empty(t, resp.Header().Get("RateLimit-Limit"))
+		}
+	})
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.Nop()
+
+	// the app's own router, limited to one request per client
+	newApp := func(t *testing.T) *App {
+		t.Setenv("RATE_LIMIT_REQUESTS", "1")
+		app := &App{Log: &logger, Config: testConfig(t), Auth: roleVerifier{}}
+		router, err := newRouter(app.config().TrustedProxies)
+		require.NoError(t, err)
+		app.Router = router
+		app.initialiseRoutes()
+		return app
+	}
+
+	send := func(app *App, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/list/status", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	t.Run("should not give a spoofed X-Forwarded-For a fresh bucket", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "")
+		app := newApp(t)
+		assert.Equal(t, http.StatusOK, send(app, "198.51.100.1"))
+		assert.Equal(t, http.StatusTooManyRequests, send(app, "198.51.100.2"))
+		assert.Equal(t, http.StatusTooManyRequests, send(app, "198.51.100.3, 198.51.100.4"))
+	})
+
+	t.Run("should take the client IP from a trusted proxy", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "192.0.2.0/24")
+		app := newApp(t)
+		assert.Equal(t, http.StatusOK, send(app, "198.51.100.1"))
+		assert.Equal(t, http.StatusOK, send(app, "198.51.100.2"))
+		assert.Equal(t, http.StatusTooManyRequests, send(app, "198.51.100.1"))
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//-----------------------------------------------------------------------------
+// Rate limit stores
+// where request counts live. The in-memory token buckets only see this
+// replica's traffic; the MongoDB store shares counts between replicas
+
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitDecision, error)
+}
+
+//-----------------------------------------------------------------------------
+// MongoDB store
+// counts requests in fixed windows with an atomic $inc per request. In
+// sliding mode the previous window's count is weighted by how much of it
+// still overlaps the sliding window. Counters expire through a TTL index
+
+const rateLimitCollection = "rate_limits"
+
+type MongoRateLimitStore struct {
+	Collection *mongo.Collection
+	Sliding    bool
+	Timeout    time.Duration
+
+	now func() time.Time
+}
+
+type rateLimitCounter struct {
+	ID        string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func NewMongoRateLimitStore(collection *mongo.Collection, sliding bool, timeout time.Duration) *MongoRateLimitStore {
+	return &MongoRateLimitStore{
+		Collection: collection,
+		Sliding:    sliding,
+		Timeout:    timeout,
+		now:        time.Now,
+	}
+}
+
+// EnsureIndexes creates the TTL index that clears out old counters
+func (s *MongoRateLimitStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+	})
+	return err
+}
+
+func windowKey(key string, start time.Time) string {
+	return fmt.Sprintf("%s|%d", key, start.Unix())
+}
+
+func (s *MongoRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitDecision, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	now := s.now()
+	start := now.Truncate(rule.Window)
+	end := start.Add(rule.Window)
+
+	// counters outlive their window by one more so sliding mode can read the
+	// previous one
+	var counter rateLimitCounter
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.Collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": windowKey(key, start)},
+			bson.M{
+				"$inc":         bson.M{"count": 1},
+				"$setOnInsert": bson.M{"expires_at": end.Add(rule.Window)},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&counter)
+		// two replicas upserting a new window at once can collide, the loser
+		// just needs to increment the winner's counter
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return RateLimitDecision{}, err
+	}
+
+	used := float64(counter.Count)
+	if s.Sliding {
+		var previous rateLimitCounter
+		err := s.Collection.FindOne(ctx, bson.M{"_id": windowKey(key, start.Add(-rule.Window))}).Decode(&previous)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return RateLimitDecision{}, err
+		}
+		overlap := 1 - float64(now.Sub(start))/float64(rule.Window)
+		used += float64(previous.Count) * overlap
+	}
+
+	d := RateLimitDecision{Limit: rule.Requests, Reset: end.Sub(now)}
+	if used <= float64(rule.Requests) {
+		d.Allowed = true
+		d.Remaining = rule.Requests - int(math.Ceil(used))
+	} else {
+		d.RetryAfter = d.Reset
+	}
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	return d, nil
+}
+
+//-----------------------------------------------------------------------------
+// Fallback store
+// uses Primary while it's healthy and Fallback (normally the in-memory
+// buckets) when it errors. A circuit breaker stops every request waiting on a
+// store that is down
+
+type FallbackRateLimitStore struct {
+	Primary  RateLimitStore
+	Fallback RateLimitStore
+	Breaker  *CircuitBreaker
+
+	log *zerolog.Logger
+}
+
+func NewFallbackRateLimitStore(primary, fallback RateLimitStore, log *zerolog.Logger) *FallbackRateLimitStore {
+	return &FallbackRateLimitStore{
+		Primary:  primary,
+		Fallback: fallback,
+		Breaker:  NewCircuitBreaker(3, 10*time.Second),
+		log:      log,
+	}
+}
+
+func (s *FallbackRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitDecision, error) {
+	if ok, _ := s.Breaker.Allow(); ok {
+		d, err := s.Primary.Take(ctx, key, rule)
+		if err == nil {
+			s.Breaker.Success()
+			return d, nil
+		}
+		s.Breaker.Failure()
+		s.log.Warn().Err(err).Msg("Rate limit store unavailable, limiting locally")
+	}
+	return s.Fallback.Take(ctx, key, rule)
+}
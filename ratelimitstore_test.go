@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore errors on every call
+type failingStore struct {
+	calls int
+}
+
+func (s *failingStore) Take(context.Context, string, RateLimitRule) (RateLimitDecision, error) {
+	s.calls++
+	return RateLimitDecision{}, errors.New("connection refused")
+}
+
+func TestFallbackRateLimitStore(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rule := RateLimitRule{Requests: 2, Window: time.Minute}
+
+	t.Run("should use the primary while it is healthy", func(t *testing.T) {
+		primary := NewTokenBucketLimiter()
+		fallback := NewTokenBucketLimiter()
+		store := NewFallbackRateLimitStore(primary, fallback, &logger)
+
+		d, err := store.Take(context.Background(), "a", rule)
+		require.NoError(t, err)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, 1, primary.Len())
+		assert.Equal(t, 0, fallback.Len())
+	})
+
+	t.Run("should limit locally when the primary fails", func(t *testing.T) {
+		primary := &failingStore{}
+		store := NewFallbackRateLimitStore(primary, NewTokenBucketLimiter(), &logger)
+
+		for i := 0; i < 2; i++ {
+			d, err := store.Take(context.Background(), "a", rule)
+			require.NoError(t, err)
+			assert.True(t, d.Allowed)
+		}
+		d, err := store.Take(context.Background(), "a", rule)
+		require.NoError(t, err)
+		assert.False(t, d.Allowed)
+	})
+
+	t.Run("should stop calling the primary once the breaker opens", func(t *testing.T) {
+		primary := &failingStore{}
+		store := NewFallbackRateLimitStore(primary, NewTokenBucketLimiter(), &logger)
+
+		for i := 0; i < 10; i++ {
+			_, err := store.Take(context.Background(), "a", RateLimitRule{Requests: 100, Window: time.Minute})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, store.Breaker.Threshold, primary.calls)
+		assert.Equal(t, CircuitOpen, store.Breaker.State())
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+// Log redaction
+// sits between zerolog and the log file. Every event is decoded and
+//   - fields named in LOG_REDACT_FIELDS (plus the defaults) are masked
+//   - fields named in LOG_PSEUDONYMISE_FIELDS are replaced by a keyed hash,
+//     so one user's lines can still be followed without saying who they are
+//   - anything token shaped in any string, such as JWTs, bearer tokens and
+//     long opaque keys, is masked wherever it turns up
+//
+// LOG_REDACTION_KEY keys the hash. Without one a random key is used and
+// pseudonyms only hold for the life of the process
+
+const redactedValue = "[REDACTED]"
+
+var (
+	defaultRedactFields       = []string{"token", "access_token", "x_access_token", "authorization", "password", "secret", "service_key", "x_service_key", "api_key", "cookie"}
+	defaultPseudonymiseFields = []string{"public_id", "admin_id", "user_id"}
+
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	opaquePattern = regexp.MustCompile(`[A-Za-z0-9_+=-]{32,}`)
+	uuidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+type Redactor struct {
+	redact       map[string]bool
+	pseudonymise map[string]bool
+	key          []byte
+}
+
+// NewRedactor masks the given field names on top of the defaults and
+// pseudonymises the given ID fields with key
+func NewRedactor(redactFields, pseudonymiseFields []string, key []byte) *Redactor {
+	r := &Redactor{redact: map[string]bool{}, pseudonymise: map[string]bool{}, key: key}
+	for _, f := range append(defaultRedactFields, redactFields...) {
+		r.redact[fieldName(f)] = true
+	}
+	for _, f := range pseudonymiseFields {
+		r.pseudonymise[fieldName(f)] = true
+	}
+	return r
+}
+
+// RedactionConfig holds the LOG_REDACT_FIELDS, LOG_PSEUDONYMISE_FIELDS and
+// LOG_REDACTION_KEY settings
+type RedactionConfig struct {
+	Key                string
+	RedactFields       []string
+	PseudonymiseFields []string
+}
+
+// NewRedactionConfig reads the redaction settings. LOG_PSEUDONYMISE_FIELDS
+// set but empty turns pseudonymising off, so only a missing one gets the
+// defaults
+func NewRedactionConfig(s Settings) *RedactionConfig {
+	pseudonymise := defaultPseudonymiseFields
+	if raw, ok := s.Lookup("LOG_PSEUDONYMISE_FIELDS"); ok {
+		pseudonymise = SplitCommaList(raw)
+	}
+	return &RedactionConfig{
+		Key:                s.Get("LOG_REDACTION_KEY"),
+		RedactFields:       SplitCommaList(s.Get("LOG_REDACT_FIELDS")),
+		PseudonymiseFields: pseudonymise,
+	}
+}
+
+// NewRedactorFromConfig builds the log redactor. Without a key a random one
+// is generated, and the bool reports that it was
+func NewRedactorFromConfig(config *RedactionConfig) (*Redactor, bool, error) {
+	key := []byte(config.Key)
+	generated := len(key) == 0
+	if generated {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, false, err
+		}
+	}
+	return NewRedactor(config.RedactFields, config.PseudonymiseFields, key), generated, nil
+}
+
+// fieldName normalises field names so X-Access-Token and x_access_token match
+func fieldName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+}
+
+// Pseudonym is a stable stand in for an ID, keyed so it can't be reversed by
+// hashing every known ID
+func (r *Redactor) Pseudonym(id string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(id))
+	return "anon:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// RedactString masks token shaped values in free text
+func (r *Redactor) RedactString(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redactedValue)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+redactedValue)
+	return opaquePattern.ReplaceAllStringFunc(s, func(m string) string {
+		// item and user ids are UUIDs, which look opaque but aren't secrets,
+		// and long words or numbers aren't keys
+		if uuidPattern.MatchString(m) || !strings.ContainsAny(m, "0123456789") ||
+			strings.Trim(m, "0123456789") == "" {
+			return m
+		}
+		return redactedValue
+	})
+}
+
+// redactValue walks a decoded event, name being the field the value sits under
+func (r *Redactor) redactValue(name string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		if r.redact[name] {
+			return redactedValue
+		}
+		if r.pseudonymise[name] && val != "" {
+			return r.Pseudonym(val)
+		}
+		return r.RedactString(val)
+	case map[string]interface{}:
+		for k, child := range val {
+			val[k] = r.redactValue(fieldName(k), child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = r.redactValue(name, child)
+		}
+		return val
+	case nil:
+		return nil
+	default:
+		if r.redact[name] {
+			return redactedValue
+		}
+		return val
+	}
+}
+
+// Redact rewrites one JSON log event. Anything that isn't a JSON object
+// still has token shaped values masked
+func (r *Redactor) Redact(event []byte) []byte {
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(event))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return []byte(r.RedactString(string(event)))
+	}
+	r.redactValue("", fields)
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return []byte(r.RedactString(string(event)))
+	}
+	// Encode always ends with a newline, keep the event's own ending
+	if !bytes.HasSuffix(event, []byte("\n")) {
+		out.Truncate(out.Len() - 1)
+	}
+	return out.Bytes()
+}
+
+//-----------------------------------------------------------------------------
+// Writer
+
+type RedactingWriter struct {
+	Out      io.Writer
+	Redactor *Redactor
+}
+
+func NewRedactingWriter(out io.Writer, redactor *Redactor) *RedactingWriter {
+	return &RedactingWriter{Out: out, Redactor: redactor}
+}
+
+// Write redacts and forwards one event, reporting the whole event as written
+// as zerolog expects
+func (w *RedactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.Out.Write(w.Redactor.Redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+//-----------------------------------------------------------------------------
+// Request IDs
+// every request gets an ID, taken from X-Request-ID when the caller sent a
+// sensible one and generated otherwise. The ID is echoed in the response,
+// added to JSON error bodies, tagged on every log line written through the
+// request's logger, stored on audit records and passed on to authy and the
+// items service
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern keeps IDs short and free of anything that could break a
+// log line or header
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID in ctx, or "" if there isn't
+// one
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// setRequestIDHeader passes the request ID on to an outbound call
+func setRequestIDHeader(ctx context.Context, req *http.Request) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+}
+
+// loggerFromContext returns the request's logger if ctx has one, and
+// fallback if not
+func loggerFromContext(ctx context.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return fallback
+}
+
+// requestLog returns the http component's logger for a request, which tags
+// each line with the request ID
+func (a *App) requestLog(c *gin.Context) *zerolog.Logger {
+	return loggerFromContext(c.Request.Context(), a.Log)
+}
+
+// RequestIDMiddleware sets up the request ID. It runs first so that every
+// later middleware, and every error response, has it
+func (a *App) RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		c.Set("request_id", id)
+		c.Header(requestIDHeader, id)
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, id: id}
+
+		logger := a.componentLog(componentHTTP).With().Str("request_id", id).Logger()
+		ctx := logger.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// requestIDWriter adds request_id to JSON error bodies, so a user reporting
+// an error can quote it without digging through headers
+type requestIDWriter struct {
+	gin.ResponseWriter
+	id string
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	if w.Status() < 400 || w.Written() || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil || body == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, ok := body["request_id"]; !ok {
+		body["request_id"] = w.id
+	}
+	tagged, err := json.Marshal(body)
+	if err != nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(tagged); err != nil {
+		return 0, err
+	}
+	// gin checks the whole of what it wrote was written
+	return len(b), nil
+}
+
+func (w *requestIDWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	var authyRequestID string
+	authy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authyRequestID = r.Header.Get(requestIDHeader)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authy.Close()
+
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	app := &App{Log: &logger, Config: testConfig(t), Auth: NewAuthyVerifier(authy.URL, newTestAuthyClient(authy), &logger)}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	request := func(method, path, requestID string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should echo a request ID it was given", func(t *testing.T) {
+		resp := request("GET", "/list/status", "trace-42.a:b_c", nil)
+		assert.Equal(t, "trace-42.a:b_c", resp.Header().Get(requestIDHeader))
+		// success bodies are left alone
+		assert.NotContains(t, resp.Body.String(), "request_id")
+	})
+
+	t.Run("should generate one when none or a bad one is sent", func(t *testing.T) {
+		for _, sent := range []string{"", "has spaces", strings.Repeat("a", 129), "new\x7fline"} {
+			resp := request("GET", "/list/status", sent, nil)
+			id := resp.Header().Get(requestIDHeader)
+			assert.True(t, IsValidUUID(id), "%q gave %q", sent, id)
+		}
+	})
+
+	t.Run("should add the request ID to error bodies", func(t *testing.T) {
+		resp := request("GET", "/list/watching/not-a-uuid", "req-400", nil)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "req-400", body["request_id"])
+		assert.Equal(t, "Invalid item ID format", body["message"])
+
+		resp = request("GET", "/no/such/route", "req-404", nil)
+		require.Equal(t, http.StatusNotFound, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"request_id":"req-404"`)
+	})
+
+	t.Run("should tag logs and pass the ID to authy", func(t *testing.T) {
+		logs.Reset()
+		resp := request("GET", "/list/watchlist", "req-auth", map[string]string{"X-Access-Token": "some-token"})
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"request_id":"req-auth"`)
+		assert.Equal(t, "req-auth", authyRequestID)
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		require.NotEmpty(t, lines)
+		for _, line := range lines {
+			assert.Contains(t, line, `"request_id":"req-auth"`)
+		}
+		assert.Contains(t, logs.String(), "Authentication failed")
+	})
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	t.Run("should pass the ID to the items service", func(t *testing.T) {
+		var sent string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent = r.Header.Get(requestIDHeader)
+			_, _ = w.Write([]byte(`{"items": []}`))
+		}))
+		defer server.Close()
+
+		provider := NewHTTPItemProvider(server.URL, time.Second, 10)
+		provider.GetItems(WithRequestID(context.Background(), "req-items"), []string{testItemID1})
+		assert.Equal(t, "req-items", sent)
+	})
+
+	t.Run("should fall back to the app logger outside a request", func(t *testing.T) {
+		logger := zerolog.Nop()
+		assert.Same(t, &logger, loggerFromContext(context.Background(), &logger))
+		assert.Equal(t, "", RequestIDFromContext(context.Background()))
+	})
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"os"
 )
 
 func (a *App) initialiseRoutes() {
@@ -11,24 +10,72 @@ func (a *App) initialiseRoutes() {
 	a.Log.Info().Msg("Initialising routes")
 
 	// Add middleware
+	a.Router.Use(a.RequestIDMiddleware())
+	// logs after the rest have run, so requests they turn away are logged too
+	a.Router.Use(a.LoggingMiddleware())
+	a.Router.Use(a.TracingMiddleware())
+	metricsEnabled := a.config().MetricsEnabled
+	if metricsEnabled {
+		a.Router.Use(a.appMetrics().Middleware())
+	}
 	a.Router.Use(a.CORSMiddleware())
 	a.Router.Use(a.JSONOnlyMiddleware())
-	a.Router.Use(a.LoggingMiddleware())
-	a.Router.Use(a.RateLimitMiddleware())
+	if a.config().OpenAPIValidation {
+		a.Router.Use(a.OpenAPIValidationMiddleware())
+	}
 
-	// Public routes (no authentication required)
-	a.Router.GET("/list/status", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "System running...", "version": os.Getenv("VERSION")})
-	})
+	// Prometheus scrape endpoint, on its own listener rather than the API
+	if metricsEnabled {
+		a.metricsRouter = a.newMetricsRouter()
+	}
 
-	// Route to get count of people watching an item (unauthenticated)
-	a.Router.GET("/list/watching/:item_id", func(c *gin.Context) {
-		a.GetWatchingCount(c)
-	})
+	// Liveness and readiness probes, not rate limited so the orchestrator
+	// is never turned away
+	health := a.Router.Group("/list/health")
+	{
+		health.GET("/live", func(c *gin.Context) {
+			a.GetLiveness(c)
+		})
+
+		health.GET("/ready", func(c *gin.Context) {
+			a.GetReadiness(c)
+		})
+	}
+
+	// Public routes (no authentication required), rate limited by client IP
+	public := a.Router.Group("/list")
+	public.Use(a.RateLimitMiddleware())
+	{
+		public.GET("/status", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"message": "System running...", "version": a.config().Version})
+		})
+
+		// OpenAPI document describing every route below
+		public.GET("/openapi.json", func(c *gin.Context) {
+			a.GetOpenAPISpec(c)
+		})
+
+		// Drop a logged out token from the authentication cache
+		public.DELETE("/auth/token", func(c *gin.Context) {
+			a.InvalidateToken(c)
+		})
+
+		// Route to get count of people watching an item (unauthenticated)
+		public.GET("/watching/:item_id", func(c *gin.Context) {
+			a.GetWatchingCount(c)
+		})
+
+		// Read only view of a list through a share token
+		public.GET("/shared/:token", func(c *gin.Context) {
+			a.GetSharedList(c)
+		})
+	}
 
-	// Authenticated routes
+	// Authenticated routes, rate limited per user
 	authenticated := a.Router.Group("/list")
 	authenticated.Use(a.AuthMiddleware())
+	authenticated.Use(a.ListAccessMiddleware())
+	authenticated.Use(a.RateLimitMiddleware())
 	{
 		// Watchlist routes
 		authenticated.GET("/watchlist", func(c *gin.Context) {
@@ -101,6 +148,73 @@ func (a *App) initialiseRoutes() {
 		})
 	}
 
+	// Routes on the user's own account, closed to services whatever their scopes
+	account := a.Router.Group("/list")
+	account.Use(a.AuthMiddleware())
+	account.Use(a.UserOnlyMiddleware())
+	account.Use(a.RateLimitMiddleware())
+	{
+		// The current user's settings
+		account.GET("/settings", func(c *gin.Context) {
+			a.GetSettings(c)
+		})
+		account.PATCH("/settings", func(c *gin.Context) {
+			a.UpdateSettings(c)
+		})
+
+		// The current user's share links
+		account.GET("/shares", func(c *gin.Context) {
+			a.GetShares(c)
+		})
+		account.POST("/shares", func(c *gin.Context) {
+			a.CreateShare(c)
+		})
+		account.DELETE("/shares/:share_id", func(c *gin.Context) {
+			a.RevokeShare(c)
+		})
+	}
+
+	// Admin routes - any user's lists, for support staff holding the admin role
+	admin := a.Router.Group("/list/admin")
+	admin.Use(a.AuthMiddleware())
+	admin.Use(a.AdminMiddleware())
+	admin.Use(a.RateLimitMiddleware())
+	{
+		admin.GET("/users/:public_id/:list_type", a.AdminAudit("read_list"), a.AdminTargetMiddleware(), func(c *gin.Context) {
+			a.GetAllFromList(c, c.Param("list_type"))
+		})
+		admin.POST("/users/:public_id/:list_type", a.AdminAudit("add_item"), a.AdminTargetMiddleware(), func(c *gin.Context) {
+			a.AddToList(c, c.Param("list_type"))
+		})
+		admin.DELETE("/users/:public_id/:list_type/:itemId", a.AdminAudit("remove_item"), a.AdminTargetMiddleware(), func(c *gin.Context) {
+			a.RemoveItemFromList(c, c.Param("list_type"))
+		})
+		admin.DELETE("/users/:public_id/:list_type", a.AdminAudit("clear_list"), a.AdminTargetMiddleware(), func(c *gin.Context) {
+			a.RemoveAllFromList(c, c.Param("list_type"))
+		})
+
+		// Which users have an item
+		admin.GET("/items/:item_id", a.AdminAudit("find_item"), func(c *gin.Context) {
+			a.FindItemHolders(c)
+		})
+
+		// Changes made to users' lists
+		admin.GET("/audit", a.AdminAudit("read_audit"), func(c *gin.Context) {
+			a.GetAuditRecords(c)
+		})
+
+		// Log levels on this replica
+		admin.GET("/log-level", a.AdminAudit("read_log_level"), func(c *gin.Context) {
+			a.GetLogLevels(c)
+		})
+		admin.PUT("/log-level", a.AdminAudit("set_log_level"), func(c *gin.Context) {
+			a.SetLogLevel(c)
+		})
+		admin.DELETE("/log-level", a.AdminAudit("revert_log_level"), func(c *gin.Context) {
+			a.RevertLogLevels(c)
+		})
+	}
+
 	// Handle 404s
 	a.Router.NoRoute(func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Resource not found"})
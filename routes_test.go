@@ -72,7 +72,8 @@ func (suite *RoutesTestSuite) SetupTest() {
 
 	// Create app instance without database for route testing
 	suite.app = &App{
-		Log: &logger,
+		Log:    &logger,
+		Config: testConfig(suite.T()),
 	}
 
 	// Initialize router
@@ -153,19 +154,25 @@ func (suite *RoutesTestSuite) TestAuthenticatedRoutes() {
 			assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
 		})
 
+		// bids and purchased can only be changed by services
+		writeStatus := http.StatusInternalServerError
+		if listType == "bids" || listType == "purchased" {
+			writeStatus = http.StatusForbidden
+		}
+
 		suite.Run("should handle DELETE /list/"+listType+"/:itemId", func() {
 			validUUID := "123e4567-e89b-12d3-a456-426614174000"
 			resp := suite.makeRequest("DELETE", "/list/"+listType+"/"+validUUID, validAuthToken)
 
 			// Will return 500 due to no database, but route is correctly mapped
-			assert.Equal(suite.T(), http.StatusInternalServerError, resp.Code)
+			assert.Equal(suite.T(), writeStatus, resp.Code)
 		})
 
 		suite.Run("should handle DELETE /list/"+listType, func() {
 			resp := suite.makeRequest("DELETE", "/list/"+listType, validAuthToken)
 
 			// Will return 500 due to no database, but route is correctly mapped
-			assert.Equal(suite.T(), http.StatusInternalServerError, resp.Code)
+			assert.Equal(suite.T(), writeStatus, resp.Code)
 		})
 
 		suite.Run("should require authentication for "+listType+" routes", func() {
@@ -235,16 +242,16 @@ func (suite *RoutesTestSuite) TestNotFoundHandling() {
 // Test OPTIONS handling (CORS preflight)
 func (suite *RoutesTestSuite) TestOptionsHandling() {
 	suite.Run("should handle OPTIONS requests for all routes", func() {
-		testRoutes := []string{
-			"/list/status",
-			"/list/watchlist",
-			"/list/favourites",
-			"/list/viewed",
-			"/list/bids",
-			"/list/purchased",
+		testRoutes := map[string]string{
+			"/list/status":     "GET",
+			"/list/watchlist":  "DELETE, GET, POST",
+			"/list/favourites": "DELETE, GET, POST",
+			"/list/viewed":     "DELETE, GET, POST",
+			"/list/bids":       "DELETE, GET, POST",
+			"/list/purchased":  "DELETE, GET, POST",
 		}
 
-		for _, route := range testRoutes {
+		for route, methods := range testRoutes {
 			req := httptest.NewRequest("OPTIONS", route, nil)
 			req.Header.Set("Origin", "http://example.com")
 			req.Header.Set("Access-Control-Request-Method", "GET")
@@ -253,25 +260,39 @@ func (suite *RoutesTestSuite) TestOptionsHandling() {
 			resp := httptest.NewRecorder()
 			suite.router.ServeHTTP(resp, req)
 
-			assert.Equal(suite.T(), http.StatusOK, resp.Code)
+			assert.Equal(suite.T(), http.StatusNoContent, resp.Code)
 
 			// Verify CORS headers are set
 			assert.Equal(suite.T(), "*", resp.Header().Get("Access-Control-Allow-Origin"))
-			assert.Equal(suite.T(), "GET, POST, DELETE, OPTIONS", resp.Header().Get("Access-Control-Allow-Methods"))
-			assert.Equal(suite.T(), "Content-Type, Authorization, X-Access-Token", resp.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(suite.T(), methods, resp.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(suite.T(), "Content-Type, Authorization, X-Access-Token, X-Request-ID", resp.Header().Get("Access-Control-Allow-Headers"))
 		}
 	})
+
+	suite.Run("should reject preflights for methods the route doesn't have", func() {
+		req := httptest.NewRequest("OPTIONS", "/list/status", nil)
+		req.Header.Set("Origin", "http://example.com")
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+
+		resp := httptest.NewRecorder()
+		suite.router.ServeHTTP(resp, req)
+
+		assert.Equal(suite.T(), http.StatusForbidden, resp.Code)
+		assert.Empty(suite.T(), resp.Header().Get("Access-Control-Allow-Methods"))
+	})
 }
 
 // Test middleware application on routes
 func (suite *RoutesTestSuite) TestMiddlewareApplication() {
 	suite.Run("should apply CORS middleware to all routes", func() {
-		resp := suite.makeRequest("GET", "/list/status", "")
+		req := httptest.NewRequest("GET", "/list/status", nil)
+		req.Header.Set("Origin", "http://example.com")
+		resp := httptest.NewRecorder()
+		suite.router.ServeHTTP(resp, req)
 
 		// Verify CORS headers are present
 		assert.Equal(suite.T(), "*", resp.Header().Get("Access-Control-Allow-Origin"))
-		assert.Equal(suite.T(), "GET, POST, DELETE, OPTIONS", resp.Header().Get("Access-Control-Allow-Methods"))
-		assert.Equal(suite.T(), "Content-Type, Authorization, X-Access-Token", resp.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(suite.T(), "Origin", resp.Header().Get("Vary"))
 	})
 
 	suite.Run("should apply JSON middleware to POST requests", func() {
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//-----------------------------------------------------------------------------
+// Service authentication
+// trusted services (auction, payments...) send an API key in X-Service-Key
+// and the user they are acting for in X-Public-ID. Keys live in the file at
+// SERVICE_KEYS_FILE as SHA-256 hashes, each with the scopes it grants:
+//
+//	{"services": [{"name": "auction", "key_sha256": "...", "scopes": ["bids:write"]}]}
+//
+// A scope is <list type>:<read|write>, with * allowed for either half
+
+type ServiceKey struct {
+	Name      string   `json:"name"`
+	KeySHA256 string   `json:"key_sha256"`
+	Scopes    []string `json:"scopes"`
+}
+
+// ServiceKeys maps key hashes to the services holding them
+type ServiceKeys struct {
+	byHash map[string]*ServiceKey
+}
+
+// ServiceIdentity is the service behind a request and the user it is acting for
+type ServiceIdentity struct {
+	Name     string
+	PublicID string
+	Scopes   []string
+}
+
+// Allows reports whether the service may perform access ("read" or "write")
+// on listType
+func (s *ServiceIdentity) Allows(listType, access string) bool {
+	for _, scope := range s.Scopes {
+		list, acc, ok := strings.Cut(scope, ":")
+		if !ok {
+			if scope == "*" {
+				return true
+			}
+			continue
+		}
+		if (list == "*" || list == listType) && (acc == "*" || acc == access) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadServiceKeys reads a keys file
+func LoadServiceKeys(path string) (*ServiceKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServiceKeys(b)
+}
+
+func ParseServiceKeys(b []byte) (*ServiceKeys, error) {
+	var doc struct {
+		Services []*ServiceKey `json:"services"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	keys := &ServiceKeys{byHash: make(map[string]*ServiceKey, len(doc.Services))}
+	for _, s := range doc.Services {
+		hash := strings.ToLower(s.KeySHA256)
+		if s.Name == "" || len(hash) != 64 {
+			return nil, fmt.Errorf("service %q needs a name and a hex sha256 key hash", s.Name)
+		}
+		if _, dup := keys.byHash[hash]; dup {
+			return nil, fmt.Errorf("service %q shares a key with another service", s.Name)
+		}
+		for _, scope := range s.Scopes {
+			if !validScope(scope) {
+				return nil, fmt.Errorf("service %q has invalid scope %q", s.Name, scope)
+			}
+		}
+		keys.byHash[hash] = s
+	}
+	return keys, nil
+}
+
+func validScope(scope string) bool {
+	if scope == "*" {
+		return true
+	}
+	list, access, ok := strings.Cut(scope, ":")
+	if !ok || (access != "read" && access != "write" && access != "*") {
+		return false
+	}
+	return list == "*" || IsListRouteType(list)
+}
+
+// Lookup returns the service holding key. Keys are compared by hash so the
+// raw key is never stored
+func (k *ServiceKeys) Lookup(key string) (*ServiceKey, bool) {
+	if k == nil || key == "" {
+		return nil, false
+	}
+	s, ok := k.byHash[HashToken(key)]
+	return s, ok
+}
+
+// Len returns the number of configured services
+func (k *ServiceKeys) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.byHash)
+}
+
+//-----------------------------------------------------------------------------
+// List write rules
+// lists named in SERVICE_ONLY_LISTS (bids and purchased by default) can only
+// be changed by services - end users can read them but not write them
+
+func parseServiceOnlyLists(raw string) (map[string]bool, error) {
+	lists := map[string]bool{}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !IsListRouteType(name) {
+			return nil, fmt.Errorf("unknown list type %q", name)
+		}
+		lists[name] = true
+	}
+	return lists, nil
+}
+
+// listRouteAccess works out the list type and access a list route needs
+// from its gin path, e.g. DELETE /list/bids/:itemId is a write to bids. ok
+// is false when the path isn't a list
+func listRouteAccess(c *gin.Context) (listType, access string, ok bool) {
+	path := strings.TrimPrefix(c.FullPath(), "/list/")
+	listType, _, _ = strings.Cut(path, "/")
+	ok = IsListRouteType(listType) || IsValidListType(listType)
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return listType, "read", ok
+	}
+	return listType, "write", ok
+}
+
+// ListAccessMiddleware applies scopes to service requests and the
+// service-only rules to end user requests. Services are refused anything
+// that isn't a list, whatever their scopes. It runs after AuthMiddleware
+func (a *App) ListAccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listType, access, isList := listRouteAccess(c)
+
+		if v, ok := c.Get("service"); ok {
+			service := v.(*ServiceIdentity)
+			if !isList {
+				a.authLog(c).Warn().Str("service", service.Name).Str("route", c.FullPath()).Msg("Service refused on non list route")
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Only the signed in user can use this route"})
+				return
+			}
+			if !service.Allows(listType, access) {
+				a.authLog(c).Warn().Str("service", service.Name).Str("list", listType).Str("access", access).Msg("Service not permitted")
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("Service is not permitted to %s %s", access, listType)})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if access == "write" && a.serviceOnlyLists()[listType] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("The %s list can only be changed by trusted services", listType)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// UserOnlyMiddleware refuses service requests on routes that act on the
+// signed in user's own account rather than on a list. It runs after
+// AuthMiddleware
+func (a *App) UserOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v, ok := c.Get("service"); ok {
+			a.authLog(c).Warn().Str("service", v.(*ServiceIdentity).Name).Str("route", c.FullPath()).Msg("Service refused on user only route")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Only the signed in user can use this route"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticateService handles a request carrying X-Service-Key, returning
+// false if it has been aborted
+func (a *App) authenticateService(c *gin.Context, key string) bool {
+	service, ok := a.ServiceKeys.Lookup(key)
+	if !ok {
+		a.authLog(c).Warn().Msg("Unknown service key")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid service key"})
+		return false
+	}
+
+	publicID := c.GetHeader("X-Public-ID")
+	if publicID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "X-Public-ID header is required for service requests"})
+		return false
+	}
+	if !IsValidUUID(publicID) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid X-Public-ID format"})
+		return false
+	}
+
+	c.Set("public_id", publicID)
+	c.Set("service", &ServiceIdentity{Name: service.Name, PublicID: publicID, Scopes: service.Scopes})
+	a.authLog(c).Info().Str("service", service.Name).Str("public_id", publicID).Msg("Service authentication successful")
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// App wiring
+
+// initialiseServiceAuth loads service keys from SERVICE_KEYS_FILE, if set,
+// and the service-only list rules
+func (a *App) initialiseServiceAuth() {
+	config := a.config()
+	if path := config.ServiceKeysFile; path != "" {
+		keys, err := LoadServiceKeys(path)
+		if err != nil {
+			a.Log.Fatal().Err(err).Msg("Failed to load service keys")
+		}
+		a.ServiceKeys = keys
+		a.Log.Info().Int("services", keys.Len()).Msg("Loaded service keys")
+	}
+
+	if config.ServiceOnlyLists == nil {
+		a.Log.Fatal().Msg("Invalid SERVICE_ONLY_LISTS")
+	}
+	a.serviceOnly = config.ServiceOnlyLists
+}
+
+// serviceOnlyLists returns the configured rules, falling back to the default
+// for apps that haven't been through InitialiseApp
+func (a *App) serviceOnlyLists() map[string]bool {
+	if a.serviceOnly != nil {
+		return a.serviceOnly
+	}
+	if lists := a.config().ServiceOnlyLists; lists != nil {
+		return lists
+	}
+	return map[string]bool{"bids": true, "purchased": true}
+}
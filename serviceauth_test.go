@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	serviceTestKey      = "auction-service-key"
+	serviceTestPublicID = "123e4567-e89b-12d3-a456-426614174000"
+)
+
+func serviceTestKeysJSON() string {
+	return `{"services": [
+		{"name": "auction", "key_sha256": "` + HashToken(serviceTestKey) + `", "scopes": ["bids:write", "bids:read"]},
+		{"name": "reporting", "key_sha256": "` + HashToken("reporting-key") + `", "scopes": ["*:read"]}
+	]}`
+}
+
+func TestServiceKeys(t *testing.T) {
+	t.Run("should load keys from a file and look them up by key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		require.NoError(t, os.WriteFile(path, []byte(serviceTestKeysJSON()), 0600))
+
+		keys, err := LoadServiceKeys(path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, keys.Len())
+
+		service, ok := keys.Lookup(serviceTestKey)
+		require.True(t, ok)
+		assert.Equal(t, "auction", service.Name)
+
+		_, ok = keys.Lookup("wrong-key")
+		assert.False(t, ok)
+	})
+
+	t.Run("should reject invalid key files", func(t *testing.T) {
+		bad := []string{
+			`not json`,
+			`{"services": [{"name": "", "key_sha256": "` + HashToken("k") + `"}]}`,
+			`{"services": [{"name": "short", "key_sha256": "abc"}]}`,
+			`{"services": [{"name": "scope", "key_sha256": "` + HashToken("k") + `", "scopes": ["shopping:write"]}]}`,
+			`{"services": [{"name": "scope", "key_sha256": "` + HashToken("k") + `", "scopes": ["bids:delete"]}]}`,
+			`{"services": [{"name": "a", "key_sha256": "` + HashToken("k") + `"}, {"name": "b", "key_sha256": "` + HashToken("k") + `"}]}`,
+		}
+		for _, doc := range bad {
+			_, err := ParseServiceKeys([]byte(doc))
+			assert.Error(t, err, doc)
+		}
+	})
+
+	t.Run("should match scopes", func(t *testing.T) {
+		s := &ServiceIdentity{Scopes: []string{"bids:write", "*:read"}}
+		assert.True(t, s.Allows("bids", "write"))
+		assert.True(t, s.Allows("watchlist", "read"))
+		assert.False(t, s.Allows("purchased", "write"))
+
+		assert.True(t, (&ServiceIdentity{Scopes: []string{"*"}}).Allows("purchased", "write"))
+		assert.False(t, (&ServiceIdentity{}).Allows("bids", "read"))
+	})
+
+	t.Run("should parse service only lists", func(t *testing.T) {
+		lists, err := parseServiceOnlyLists("bids, purchased")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"bids": true, "purchased": true}, lists)
+
+		_, err = parseServiceOnlyLists("bids,shopping")
+		assert.Error(t, err)
+	})
+}
+
+func TestServiceAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	keys, err := ParseServiceKeys([]byte(serviceTestKeysJSON()))
+	require.NoError(t, err)
+
+	app := &App{Log: &logger, Config: testConfig(t), Auth: &countingVerifier{}, ServiceKeys: keys}
+
+	router := gin.New()
+	group := router.Group("/list")
+	group.Use(app.AuthMiddleware(), app.ListAccessMiddleware())
+	handler := func(c *gin.Context) {
+		publicID, _ := c.Get("public_id")
+		c.JSON(http.StatusOK, gin.H{"public_id": publicID})
+	}
+	for _, listType := range []string{"watchlist", "bids"} {
+		group.GET("/"+listType, handler)
+		group.POST("/"+listType, handler)
+		group.DELETE("/"+listType+"/:itemId", handler)
+	}
+	group.GET("/settings", handler)
+
+	send := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	service := func(key string) map[string]string {
+		return map[string]string{"X-Service-Key": key, "X-Public-ID": serviceTestPublicID}
+	}
+
+	t.Run("should let a service write for a user within its scopes", func(t *testing.T) {
+		resp := send("POST", "/list/bids", service(serviceTestKey))
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), serviceTestPublicID)
+
+		resp = send("DELETE", "/list/bids/"+serviceTestPublicID, service(serviceTestKey))
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should refuse a service outside its scopes", func(t *testing.T) {
+		resp := send("POST", "/list/watchlist", service(serviceTestKey))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = send("POST", "/list/bids", service("reporting-key"))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = send("GET", "/list/watchlist", service("reporting-key"))
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should refuse a service on routes that aren't lists", func(t *testing.T) {
+		resp := send("GET", "/list/settings", service("reporting-key"))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = send("GET", "/list/settings", map[string]string{"X-Access-Token": "good"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("should reject unknown keys", func(t *testing.T) {
+		resp := send("GET", "/list/bids", service("wrong-key"))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("should require a valid X-Public-ID", func(t *testing.T) {
+		resp := send("GET", "/list/bids", map[string]string{"X-Service-Key": serviceTestKey})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		resp = send("GET", "/list/bids", map[string]string{"X-Service-Key": serviceTestKey, "X-Public-ID": "nope"})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("should stop end users writing service only lists", func(t *testing.T) {
+		user := map[string]string{"X-Access-Token": "good"}
+
+		resp := send("GET", "/list/bids", user)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		resp = send("POST", "/list/bids", user)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = send("DELETE", "/list/bids/"+serviceTestPublicID, user)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = send("POST", "/list/watchlist", user)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//-----------------------------------------------------------------------------
+// Shared lists
+// owners mint share tokens for their watchlist or favourites. Anyone holding
+// a token can read that one list through /list/shared/:token without
+// logging in. Only the token's hash is stored, shares past expires_at stop
+// working at once and are cleared out by a TTL index, and revoking a share
+// deletes it
+
+const (
+	sharesCollection = "shares"
+	shareTokenPrefix = "shr_"
+	shareTokenLength = 48
+)
+
+// NewShareToken makes a random share token. The prefix makes tokens easy to
+// spot, and the length keeps them masked by the log redactor
+func NewShareToken() (string, error) {
+	random, err := GenerateRandomString(shareTokenLength)
+	if err != nil {
+		return "", err
+	}
+	return shareTokenPrefix + random, nil
+}
+
+// isShareTokenFormat checks the shape of a token before it costs a lookup
+func isShareTokenFormat(token string) bool {
+	random, ok := strings.CutPrefix(token, shareTokenPrefix)
+	if !ok || len(random) != shareTokenLength {
+		return false
+	}
+	for _, r := range random {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// activeShareFilter matches shares that haven't expired. The TTL index only
+// runs once a minute so expiry is checked here too
+func activeShareFilter(filter bson.M, now time.Time) bson.M {
+	filter["$or"] = bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": now}},
+	}
+	return filter
+}
+
+// EnsureShareIndexes creates the token lookup and expiry indexes
+func EnsureShareIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("token_hash_unique"),
+		},
+		{
+			Keys:    bson.D{{Key: "public_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("public_id_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+		},
+	})
+	return err
+}
+
+// initialiseShares creates the share indexes
+func (a *App) initialiseShares() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := EnsureShareIndexes(ctx, a.GetCollection(sharesCollection)); err != nil {
+		a.Log.Fatal().Err(err).Msg("Failed to create share indexes")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Handlers
+
+func (a *App) CreateShare(c *gin.Context) {
+	var req ShareRequest
+	if !a.DecodeJSON(c, &req) {
+		return
+	}
+
+	token, err := NewShareToken()
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error generating share token")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	publicID, _ := c.Get("public_id")
+	share := Share{
+		ID:        uuid.New().String(),
+		TokenHash: HashToken(token),
+		PublicID:  publicID.(string),
+		ListType:  req.ListType,
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.ExpiresAt != "" {
+		// already checked by Validate
+		expiresAt, _ := time.Parse(time.RFC3339, req.ExpiresAt)
+		expiresAt = expiresAt.UTC()
+		share.ExpiresAt = &expiresAt
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := a.GetCollection(sharesCollection).InsertOne(ctx, share); err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error creating share")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	a.requestLog(c).Info().Str("public_id", share.PublicID).Str("share_id", share.ID).Str("list_type", share.ListType).Msg("Share created")
+	c.JSON(http.StatusCreated, NewShareResponse{Share: share, Token: token, Path: "/list/shared/" + token})
+}
+
+func (a *App) GetShares(c *gin.Context) {
+	publicID, _ := c.Get("public_id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := a.GetCollection(sharesCollection).Find(ctx,
+		activeShareFilter(bson.M{"public_id": publicID.(string)}, time.Now()),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error finding shares")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	shares := make([]Share, 0)
+	if err := cursor.All(ctx, &shares); err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error reading shares")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+func (a *App) RevokeShare(c *gin.Context) {
+	shareID := c.Param("share_id")
+	if !IsValidUUID(shareID) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid share ID format"})
+		return
+	}
+
+	publicID, _ := c.Get("public_id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// the owner is part of the filter so one user can't revoke another's share
+	result, err := a.GetCollection(sharesCollection).DeleteOne(ctx, bson.M{"_id": shareID, "public_id": publicID.(string)})
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error revoking share")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Share not found"})
+		return
+	}
+
+	a.requestLog(c).Info().Str("public_id", publicID.(string)).Str("share_id", shareID).Msg("Share revoked")
+	c.Status(http.StatusNoContent)
+}
+
+// GetSharedList is the unauthenticated read of a shared list. Unknown,
+// revoked and expired tokens all get the same 404
+func (a *App) GetSharedList(c *gin.Context) {
+	expand, err := wantsItemExpansion(c)
+	if err != nil {
+		qe := err.(*ListQueryError)
+		c.JSON(http.StatusBadRequest, NewValidationError(qe.Field, qe.Message))
+		return
+	}
+	if expand && a.Items == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "Item expansion is not available"})
+		return
+	}
+
+	notFound := gin.H{"message": "Share not found"}
+	token := c.Param("token")
+	if !isShareTokenFormat(token) {
+		c.JSON(http.StatusNotFound, notFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	share := &Share{}
+	err = a.GetCollection(sharesCollection).FindOneAndUpdate(ctx,
+		activeShareFilter(bson.M{"token_hash": HashToken(token)}, now),
+		bson.M{
+			"$inc": bson.M{"view_count": 1},
+			"$set": bson.M{"last_viewed_at": now},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(share)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, notFound)
+		return
+	}
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error finding share")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	// an owner who has emptied their list still has a valid share
+	itemIDs := make([]string, 0)
+	document, err := a.getListDocument(ctx, share.PublicID, share.ListType)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		a.requestLog(c).Error().Err(err).Msg("Error reading shared list")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if document != nil {
+		itemIDs = append(itemIDs, document.ItemIds...)
+	}
+
+	response := gin.H{"list_type": share.ListType, share.ListType: itemIDs}
+	if share.ExpiresAt != nil {
+		response["expires_at"] = share.ExpiresAt
+	}
+	if expand {
+		response["items"] = a.expandItems(c.Request.Context(), itemIDs)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
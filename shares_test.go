@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareTokens(t *testing.T) {
+	t.Run("should make distinct tokens of the expected shape", func(t *testing.T) {
+		first, err := NewShareToken()
+		require.NoError(t, err)
+		second, err := NewShareToken()
+		require.NoError(t, err)
+
+		assert.NotEqual(t, first, second)
+		assert.True(t, isShareTokenFormat(first), first)
+		assert.True(t, strings.HasPrefix(first, shareTokenPrefix))
+	})
+
+	t.Run("should refuse tokens of the wrong shape", func(t *testing.T) {
+		for _, token := range []string{
+			"",
+			"shr_",
+			strings.Repeat("a", shareTokenLength),
+			shareTokenPrefix + strings.Repeat("a", shareTokenLength-1),
+			shareTokenPrefix + strings.Repeat("A", shareTokenLength),
+			"tok_" + strings.Repeat("a", shareTokenLength),
+		} {
+			assert.False(t, isShareTokenFormat(token), token)
+		}
+	})
+
+	t.Run("should keep tokens out of the log", func(t *testing.T) {
+		token, err := NewShareToken()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		logger, _ := newRedactedLogger(&buf, "key")
+		logger.Info().Str("path", "/list/shared/"+token).Msg("request")
+		assert.NotContains(t, buf.String(), token)
+	})
+}
+
+func TestShareRequestValidation(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name   string
+		req    ShareRequest
+		fields []FieldError
+	}{
+		{"watchlist without expiry", ShareRequest{ListType: "watchlist"}, nil},
+		{"favourites with expiry", ShareRequest{ListType: "favourites", ExpiresAt: future}, nil},
+		{"missing list type", ShareRequest{}, []FieldError{{Field: "list_type", Message: "is required"}}},
+		{"unshareable list", ShareRequest{ListType: "purchased"}, []FieldError{{Field: "list_type", Message: "must be one of watchlist, favourites"}}},
+		{"bad expiry", ShareRequest{ListType: "watchlist", ExpiresAt: "tomorrow"}, []FieldError{{Field: "expires_at", Message: "must be an RFC 3339 date-time"}}},
+		{"past expiry", ShareRequest{ListType: "watchlist", ExpiresAt: past}, []FieldError{{Field: "expires_at", Message: "must be in the future"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.fields, tt.req.Validate())
+		})
+	}
+}
+
+func TestShareRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	keys, err := ParseServiceKeys([]byte(`{"services": [
+		{"name": "everything", "key_sha256": "` + HashToken("wildcard-key") + `", "scopes": ["*"]}
+	]}`))
+	require.NoError(t, err)
+
+	// none of these requests get as far as the database
+	app := &App{
+		Log:         &logger,
+		Config:      testConfig(t),
+		Auth:        roleVerifier{"user-token": {PublicID: adminTestUserID}},
+		ServiceKeys: keys,
+	}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("X-Access-Token", token)
+		}
+		resp := httptest.NewRecorder()
+		app.Router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("should not need a login to read a share", func(t *testing.T) {
+		resp := send("GET", "/list/shared/not-a-share-token", "", nil)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Share not found")
+	})
+
+	t.Run("should check expand before the token", func(t *testing.T) {
+		resp := send("GET", "/list/shared/not-a-share-token?expand=everything", "", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("should not log share tokens", func(t *testing.T) {
+		token := shareTokenPrefix + strings.Repeat("ab", shareTokenLength/2)
+		logs.Reset()
+		// item expansion isn't set up, so this stops before the database
+		resp := send("GET", "/list/shared/"+token+"?expand=item", "", nil)
+		assert.Equal(t, http.StatusNotImplemented, resp.Code)
+		assert.Contains(t, logs.String(), `"path":"/list/shared/`+redactedValue+`"`)
+		assert.NotContains(t, logs.String(), token)
+	})
+
+	t.Run("should need a login to manage shares", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/list/shares", "", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, send("POST", "/list/shares", "", map[string]string{"list_type": "watchlist"}).Code)
+	})
+
+	t.Run("should refuse services whatever their scopes", func(t *testing.T) {
+		for _, route := range []struct{ method, path string }{
+			{"GET", "/list/shares"},
+			{"POST", "/list/shares"},
+			{"DELETE", "/list/shares/" + serviceTestPublicID},
+		} {
+			req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{"list_type": "watchlist"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Service-Key", "wildcard-key")
+			req.Header.Set("X-Public-ID", serviceTestPublicID)
+			resp := httptest.NewRecorder()
+			app.Router.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusForbidden, resp.Code, route.method+" "+route.path)
+			assert.Contains(t, resp.Body.String(), "Only the signed in user can use this route")
+		}
+	})
+
+	t.Run("should refuse shares of other lists", func(t *testing.T) {
+		resp := send("POST", "/list/shares", "user-token", map[string]string{"list_type": "bids"})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "list_type: must be one of watchlist, favourites")
+	})
+
+	t.Run("should refuse bad share IDs", func(t *testing.T) {
+		resp := send("DELETE", "/list/shares/not-a-uuid", "user-token", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
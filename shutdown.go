@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Graceful shutdown
+// on SIGINT or SIGTERM the readiness probe starts failing, then after
+// SHUTDOWN_DELAY (time for the orchestrator to notice and stop sending
+// traffic) the server stops accepting connections and waits up to
+// SHUTDOWN_TIMEOUT for in-flight requests to finish. Background workers are
+// stopped and MongoDB disconnected last, so draining requests can still use
+// them
+
+const (
+	defaultShutdownDelay   = 5 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+type ShutdownConfig struct {
+	Delay   time.Duration
+	Timeout time.Duration
+}
+
+// NewShutdownConfig reads SHUTDOWN_DELAY and SHUTDOWN_TIMEOUT
+func NewShutdownConfig(s Settings) (*ShutdownConfig, error) {
+	delay, err := time.ParseDuration(s.GetOrDefault("SHUTDOWN_DELAY", defaultShutdownDelay.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_DELAY: %w", err)
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("SHUTDOWN_DELAY must not be negative")
+	}
+	timeout, err := time.ParseDuration(s.GetOrDefault("SHUTDOWN_TIMEOUT", defaultShutdownTimeout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive")
+	}
+	return &ShutdownConfig{Delay: delay, Timeout: timeout}, nil
+}
+
+// initialiseShutdown reads the shutdown timings
+func (a *App) initialiseShutdown() {
+	config := a.config().Shutdown
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid shutdown settings")
+	}
+	a.shutdown = config
+}
+
+// shutdownConfig returns the app's shutdown timings, taking them from the
+// config on first use
+func (a *App) shutdownConfig() *ShutdownConfig {
+	a.shutdownOnce.Do(func() {
+		if a.shutdown != nil {
+			return
+		}
+		config := a.config().Shutdown
+		if config == nil {
+			a.Log.Error().Msg("Invalid shutdown settings, using defaults")
+			config = &ShutdownConfig{Delay: defaultShutdownDelay, Timeout: defaultShutdownTimeout}
+		}
+		a.shutdown = config
+	})
+	return a.shutdown
+}
+
+// Draining says the app has been told to stop
+func (a *App) Draining() bool {
+	return a.draining.Load()
+}
+
+// Serve handles requests on ln until ctx is done, then shuts down
+// gracefully. It only returns an error if the server fails on its own
+func (a *App) Serve(ctx context.Context, ln net.Listener) error {
+	config := a.shutdownConfig()
+	server := &http.Server{
+		Handler:           a.Router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+	a.Log.Info().Msgf("Server listening on %s", ln.Addr())
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	a.Log.Info().Dur("delay", config.Delay).Dur("timeout", config.Timeout).Msg("Shutting down - readiness now failing")
+	a.draining.Store(true)
+	time.Sleep(config.Delay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		a.Log.Error().Err(err).Msg("Requests still in flight at the shutdown timeout, closing their connections")
+		_ = server.Close()
+	} else {
+		a.Log.Info().Msg("In-flight requests finished")
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		a.Log.Error().Err(err).Msg("Server error while shutting down")
+	}
+
+	a.stopBackgroundWorkers()
+	a.Cleanup()
+	a.Log.Info().Msg("Shutdown complete")
+	return nil
+}
+
+// stopBackgroundWorkers stops the JWKS refresher and log level revert
+// timers, and flushes any spans not yet exported
+func (a *App) stopBackgroundWorkers() {
+	verifier := a.Auth
+	if caching, ok := verifier.(*CachingVerifier); ok {
+		verifier = caching.Next
+	}
+	if v, ok := verifier.(*JWTVerifier); ok && v.Keys != nil {
+		v.Keys.Stop()
+	}
+
+	if a.levels != nil {
+		a.levels.Stop()
+	}
+
+	if a.tracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.tracerProvider.Shutdown(ctx); err != nil {
+			a.Log.Error().Err(err).Msg("Error flushing traces")
+		}
+	}
+}
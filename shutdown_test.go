@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestShutdownConfig(t *testing.T) {
+	t.Run("should use the defaults", func(t *testing.T) {
+		t.Setenv("SHUTDOWN_DELAY", "")
+		t.Setenv("SHUTDOWN_TIMEOUT", "")
+		config, err := NewShutdownConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, &ShutdownConfig{Delay: 5 * time.Second, Timeout: 30 * time.Second}, config)
+	})
+
+	t.Run("should read the timings", func(t *testing.T) {
+		t.Setenv("SHUTDOWN_DELAY", "0")
+		t.Setenv("SHUTDOWN_TIMEOUT", "1m")
+		config, err := NewShutdownConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, &ShutdownConfig{Delay: 0, Timeout: time.Minute}, config)
+	})
+
+	t.Run("should reject bad timings", func(t *testing.T) {
+		for key, raw := range map[string]string{
+			"SHUTDOWN_DELAY":   "-1s",
+			"SHUTDOWN_TIMEOUT": "0",
+		} {
+			t.Run(key, func(t *testing.T) {
+				t.Setenv(key, raw)
+				_, err := NewShutdownConfig(EnvSettings())
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+
+	newApp := func(config *ShutdownConfig) (*App, chan struct{}, chan struct{}) {
+		app := &App{Log: &logger, Config: testConfig(t), Auth: roleVerifier{}, shutdown: config}
+		app.Router = gin.New()
+		app.initialiseRoutes()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		app.Router.GET("/slow", func(c *gin.Context) {
+			close(started)
+			<-release
+			c.JSON(http.StatusOK, gin.H{"message": "done"})
+		})
+		return app, started, release
+	}
+	serve := func(app *App) (string, context.CancelFunc, chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- app.Serve(ctx, ln)
+		}()
+		return "http://" + ln.Addr().String(), cancel, done
+	}
+
+	t.Run("should fail readiness then drain in-flight requests", func(t *testing.T) {
+		app, started, release := newApp(&ShutdownConfig{Delay: 200 * time.Millisecond, Timeout: 5 * time.Second})
+		url, cancel, done := serve(app)
+
+		slow := make(chan int, 1)
+		go func() {
+			resp, err := http.Get(url + "/slow")
+			if err != nil {
+				slow <- 0
+				return
+			}
+			resp.Body.Close()
+			slow <- resp.StatusCode
+		}()
+		<-started
+
+		cancel()
+		require.Eventually(t, app.Draining, time.Second, 5*time.Millisecond)
+
+		// still answering during the delay, but no longer ready
+		resp, err := http.Get(url + "/list/health/ready")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-slow)
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server didn't shut down")
+		}
+
+		_, err = http.Get(url + "/list/health/live")
+		assert.Error(t, err)
+	})
+
+	t.Run("should give up on requests at the timeout", func(t *testing.T) {
+		app, started, release := newApp(&ShutdownConfig{Timeout: 50 * time.Millisecond})
+		defer close(release)
+		url, cancel, done := serve(app)
+
+		go func() {
+			resp, err := http.Get(url + "/slow")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+
+		cancel()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server didn't shut down")
+		}
+	})
+
+	t.Run("should stop background workers", func(t *testing.T) {
+		exporter := &keptSpansExporter{}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(time.Hour)))
+		_, span := provider.Tracer("test").Start(context.Background(), "unexported")
+		span.End()
+
+		keys := NewJWKSKeySet("keys.json", time.Hour, &logger)
+		keys.Start()
+
+		app := &App{
+			Log:            &logger,
+			Config:         testConfig(t),
+			Auth:           NewCachingVerifier(&JWTVerifier{Keys: keys}, NewTokenCache(10), time.Minute, time.Second),
+			tracerProvider: provider,
+		}
+		app.stopBackgroundWorkers()
+
+		assert.Nil(t, keys.stop)
+		// the batched span was flushed on the way out
+		assert.Len(t, exporter.spans, 1)
+	})
+}
+
+// keptSpansExporter holds on to exported spans after shutdown, which the
+// tracetest in-memory exporter doesn't
+type keptSpansExporter struct {
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *keptSpansExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *keptSpansExporter) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//-----------------------------------------------------------------------------
+// OpenTelemetry tracing
+// every request gets a server span, named by gin route template so item and
+// user IDs stay out of span names. The authy lookup and each MongoDB command
+// are child spans, and W3C trace context is passed on to authy. Spans go to
+// OTEL_TRACES_EXPORTER: otlp, stdout or none (the default)
+
+const (
+	tracerName             = "github.com/cliveyg/poptape-lister-redux"
+	defaultTraceService    = "poptape-lister"
+	defaultTraceExporter   = "none"
+	defaultTraceSampler    = "parentbased_always_on"
+	defaultTraceSamplerArg = 1.0
+)
+
+type TracingConfig struct {
+	Exporter    string
+	Sampler     string
+	SamplerArg  float64
+	ServiceName string
+	Endpoint    string
+}
+
+// NewTracingConfig reads OTEL_TRACES_EXPORTER, OTEL_TRACES_SAMPLER,
+// OTEL_TRACES_SAMPLER_ARG, OTEL_SERVICE_NAME and the collector's base URL
+// from OTEL_EXPORTER_OTLP_ENDPOINT
+func NewTracingConfig(s Settings) (*TracingConfig, error) {
+	config := &TracingConfig{
+		Exporter:    strings.ToLower(s.GetOrDefault("OTEL_TRACES_EXPORTER", defaultTraceExporter)),
+		Sampler:     strings.ToLower(s.GetOrDefault("OTEL_TRACES_SAMPLER", defaultTraceSampler)),
+		SamplerArg:  defaultTraceSamplerArg,
+		ServiceName: s.GetOrDefault("OTEL_SERVICE_NAME", defaultTraceService),
+		Endpoint:    s.Get("OTEL_EXPORTER_OTLP_ENDPOINT"),
+	}
+
+	switch config.Exporter {
+	case "otlp", "stdout", "none":
+	case "console":
+		// the name the OpenTelemetry spec uses
+		config.Exporter = "stdout"
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER must be otlp, stdout or none")
+	}
+
+	switch config.Sampler {
+	case "always_on", "always_off", "traceidratio",
+		"parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio":
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_SAMPLER %q is not supported", config.Sampler)
+	}
+
+	if config.Endpoint != "" {
+		if u, err := url.Parse(config.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must be an absolute URL")
+		}
+	}
+
+	if raw := s.GetOrDefault("OTEL_TRACES_SAMPLER_ARG", ""); raw != "" {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be a ratio between 0 and 1")
+		}
+		config.SamplerArg = ratio
+	}
+	return config, nil
+}
+
+// SamplerFor builds the configured sampler
+func (tc *TracingConfig) SamplerFor() sdktrace.Sampler {
+	switch tc.Sampler {
+	case "always_on":
+		return sdktrace.AlwaysSample()
+	case "always_off":
+		return sdktrace.NeverSample()
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(tc.SamplerArg)
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tc.SamplerArg))
+	}
+	return sdktrace.ParentBased(sdktrace.AlwaysSample())
+}
+
+// NewTracerProvider builds a provider that exports through the configured
+// exporter. It returns nil when tracing is off
+func NewTracerProvider(ctx context.Context, tc *TracingConfig) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch tc.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if tc.Endpoint != "" {
+			// as in the spec, the base endpoint gets the traces path added
+			opts = append(opts, otlptracehttp.WithEndpointURL(strings.TrimSuffix(tc.Endpoint, "/")+"/v1/traces"))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(tc.SamplerFor()),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tc.ServiceName))),
+	), nil
+}
+
+// initialiseTracing sets up the global tracer provider and propagator.
+// Trace context is propagated even with tracing off, so a trace started
+// upstream still reaches authy
+func (a *App) initialiseTracing() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	config := a.config().Tracing
+	if config == nil {
+		a.Log.Fatal().Msg("Invalid tracing settings")
+	}
+
+	provider, err := NewTracerProvider(context.Background(), config)
+	if err != nil {
+		a.Log.Fatal().Err(err).Msg("Failed to create trace exporter")
+	}
+	if provider == nil {
+		a.Log.Info().Msg("Tracing disabled")
+		return
+	}
+
+	otel.SetTracerProvider(provider)
+	a.tracerProvider = provider
+	a.Log.Info().
+		Str("exporter", config.Exporter).
+		Str("sampler", config.Sampler).
+		Float64("sampler_arg", config.SamplerArg).
+		Msg("Tracing configured")
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+//-----------------------------------------------------------------------------
+// HTTP
+
+// TracingMiddleware starts a server span for each request, continuing any
+// trace passed in the request headers
+func (a *App) TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		ctx, span := tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				attribute.String("request_id", c.GetString("request_id")),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// MongoDB
+
+// TracingMonitor starts a client span for each MongoDB command under the
+// span of the request that ran it. The filter isn't recorded, as it holds
+// user and item IDs
+func TracingMonitor() *event.CommandMonitor {
+	var spans sync.Map
+	finished := func(requestID int64, failure string) {
+		v, ok := spans.LoadAndDelete(requestID)
+		if !ok {
+			return
+		}
+		span := v.(trace.Span)
+		if failure != "" {
+			span.RecordError(errors.New(failure))
+			span.SetStatus(codes.Error, "")
+		}
+		span.End()
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			collection := commandCollection(e.CommandName, e.Command)
+			name := e.CommandName
+			attrs := []attribute.KeyValue{
+				semconv.DBSystemMongoDB,
+				semconv.DBOperationName(e.CommandName),
+				semconv.DBNamespace(e.DatabaseName),
+			}
+			if collection != "" {
+				name += " " + collection
+				attrs = append(attrs, semconv.DBCollectionName(collection))
+			}
+
+			_, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+			if !span.IsRecording() {
+				span.End()
+				return
+			}
+			spans.Store(e.RequestID, span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.RequestID, "")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.RequestID, e.Failure)
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans swaps in a tracer provider that keeps finished spans in
+// memory, putting the globals back when the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTracingConfig(t *testing.T) {
+	t.Run("should be off by default", func(t *testing.T) {
+		t.Setenv("OTEL_TRACES_EXPORTER", "")
+		t.Setenv("OTEL_TRACES_SAMPLER", "")
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", "")
+		t.Setenv("OTEL_SERVICE_NAME", "")
+		config, err := NewTracingConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, &TracingConfig{
+			Exporter:    "none",
+			Sampler:     "parentbased_always_on",
+			SamplerArg:  1,
+			ServiceName: "poptape-lister",
+		}, config)
+
+		provider, err := NewTracerProvider(context.Background(), config)
+		require.NoError(t, err)
+		assert.Nil(t, provider)
+	})
+
+	t.Run("should read the exporter and sampler", func(t *testing.T) {
+		t.Setenv("OTEL_TRACES_EXPORTER", "console")
+		t.Setenv("OTEL_TRACES_SAMPLER", "ParentBased_TraceIdRatio")
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+		config, err := NewTracingConfig(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, "stdout", config.Exporter)
+		assert.Equal(t, "parentbased_traceidratio", config.Sampler)
+		assert.Equal(t, 0.25, config.SamplerArg)
+		assert.Contains(t, config.SamplerFor().Description(), "TraceIDRatioBased{0.25}")
+
+		provider, err := NewTracerProvider(context.Background(), config)
+		require.NoError(t, err)
+		require.NotNil(t, provider)
+		require.NoError(t, provider.Shutdown(context.Background()))
+	})
+
+	t.Run("should reject bad settings", func(t *testing.T) {
+		for key, raw := range map[string]string{
+			"OTEL_TRACES_EXPORTER":    "jaeger",
+			"OTEL_TRACES_SAMPLER":     "sometimes",
+			"OTEL_TRACES_SAMPLER_ARG": "1.5",
+		} {
+			t.Run(key, func(t *testing.T) {
+				t.Setenv(key, raw)
+				_, err := NewTracingConfig(EnvSettings())
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	t.Setenv("RATE_LIMIT_REQUESTS", "0")
+	recorder := recordSpans(t)
+
+	app := &App{Log: &logger, Config: testConfig(t)}
+	app.Router = gin.New()
+	app.initialiseRoutes()
+
+	req := httptest.NewRequest("GET", "/list/watching/not-a-uuid", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	resp := httptest.NewRecorder()
+	app.Router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /list/watching/:item_id", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Equal(t, "/list/watching/:item_id", spanAttribute(span, "http.route").AsString())
+	assert.Equal(t, int64(http.StatusBadRequest), spanAttribute(span, "http.response.status_code").AsInt64())
+	// client errors aren't the server's fault
+	assert.Equal(t, codes.Unset, span.Status().Code)
+}
+
+func TestAuthyTracing(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.Header.Get("X-Access-Token") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"public_id": "` + adminTestUserID + `"}`))
+	}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	verifier := NewAuthyVerifier(server.URL, newTestAuthyClient(server), &logger)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	_, err := verifier.Verify(ctx, "good")
+	require.NoError(t, err)
+	_, err = verifier.Verify(ctx, "bad")
+	require.Error(t, err)
+	parent.End()
+
+	t.Run("should pass trace context to authy", func(t *testing.T) {
+		require.NotEmpty(t, traceparent)
+		assert.Contains(t, traceparent, parent.SpanContext().TraceID().String())
+	})
+
+	t.Run("should record each lookup under the request", func(t *testing.T) {
+		var lookups []sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.Name() == "authy.verify" {
+				lookups = append(lookups, span)
+			}
+		}
+		require.Len(t, lookups, 2)
+		for _, span := range lookups {
+			assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+			// a rejected token isn't an error
+			assert.Equal(t, codes.Unset, span.Status().Code)
+		}
+		assert.Equal(t, "valid", spanAttribute(lookups[0], "authy.outcome").AsString())
+		assert.Equal(t, "rejected", spanAttribute(lookups[1], "authy.outcome").AsString())
+	})
+}
+
+func TestMongoTracing(t *testing.T) {
+	recorder := recordSpans(t)
+	monitor := combineMonitors(NewMetrics(50).MongoMonitor(), TracingMonitor())
+
+	command, err := bson.Marshal(bson.D{{Key: "find", Value: "watchlist"}, {Key: "filter", Value: bson.D{{Key: "_id", Value: adminTestUserID}}}})
+	require.NoError(t, err)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "find", DatabaseName: "poptape_lister", RequestID: 1, Command: command})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1}})
+	monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "find", DatabaseName: "poptape_lister", RequestID: 2, Command: command})
+	monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 2}, Failure: "boom"})
+	parent.End()
+
+	var commands []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "find watchlist" {
+			commands = append(commands, span)
+		}
+	}
+	require.Len(t, commands, 2)
+
+	span := commands[0]
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, "mongodb", spanAttribute(span, "db.system").AsString())
+	assert.Equal(t, "watchlist", spanAttribute(span, "db.collection.name").AsString())
+	assert.Equal(t, "find", spanAttribute(span, "db.operation.name").AsString())
+	assert.Equal(t, "poptape_lister", spanAttribute(span, "db.namespace").AsString())
+	for _, kv := range span.Attributes() {
+		assert.NotContains(t, kv.Value.Emit(), adminTestUserID)
+	}
+	assert.Equal(t, codes.Unset, span.Status().Code)
+
+	assert.Equal(t, codes.Error, commands[1].Status().Code)
+	require.Len(t, commands[1].Events(), 1)
+}
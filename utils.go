@@ -87,6 +87,18 @@ func FilterEmptyStrings(slice []string) []string {
 	return result
 }
 
+// SplitCommaList splits a comma separated value, trimming entries and
+// dropping empty ones
+func SplitCommaList(raw string) []string {
+	result := []string{}
+	for _, str := range strings.Split(raw, ",") {
+		if str = strings.TrimSpace(str); str != "" {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
 // ChunkStrings splits a slice into chunks of specified size
 func ChunkStrings(slice []string, chunkSize int) [][]string {
 	if chunkSize <= 0 {
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//-----------------------------------------------------------------------------
+// Watcher count privacy
+// public watcher counts leave out users who have opted out, and are never
+// exact below WATCHER_COUNT_MIN - a seller seeing 0 turn into 1 learns that
+// someone just started watching, so small counts all read "fewer than N".
+// WATCHER_COUNT_BUCKETS optionally coarsens larger counts, e.g. 10,50,100
+// shows 73 as "50+"
+
+const (
+	userSettingsCollection = "user_settings"
+	defaultWatcherCountMin = 3
+)
+
+type WatcherCountPolicy struct {
+	MinCount int
+	Buckets  []int
+}
+
+// NewWatcherCountPolicy reads WATCHER_COUNT_MIN and
+// WATCHER_COUNT_BUCKETS
+func NewWatcherCountPolicy(s Settings) (*WatcherCountPolicy, error) {
+	r := &configReader{s: s}
+	minCount := r.int("WATCHER_COUNT_MIN", defaultWatcherCountMin, 0)
+	buckets, err := ParseWatcherCountBuckets(s.GetOrDefault("WATCHER_COUNT_BUCKETS", ""))
+	if err != nil {
+		r.fail(fmt.Errorf("invalid WATCHER_COUNT_BUCKETS: %w", err))
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return &WatcherCountPolicy{MinCount: minCount, Buckets: buckets}, nil
+}
+
+// ParseWatcherCountBuckets parses comma separated bucket floors, which must
+// be positive and ascending
+func ParseWatcherCountBuckets(raw string) ([]int, error) {
+	var buckets []int
+	for _, v := range SplitCommaList(raw) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not a positive count", v)
+		}
+		if len(buckets) > 0 && n <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be ascending, %d follows %d", n, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, n)
+	}
+	return buckets, nil
+}
+
+// Apply turns a raw count into what may be shown publicly. Every public
+// count must go through here
+func (p *WatcherCountPolicy) Apply(count int) WatchingResponse {
+	if p.MinCount > 1 && count < p.MinCount {
+		return WatchingResponse{PeopleWatching: 0, Display: fmt.Sprintf("fewer than %d", p.MinCount)}
+	}
+	i := sort.SearchInts(p.Buckets, count+1) - 1
+	if i >= 0 {
+		return WatchingResponse{PeopleWatching: p.Buckets[i], Display: fmt.Sprintf("%d+", p.Buckets[i])}
+	}
+	return WatchingResponse{PeopleWatching: count, Display: strconv.Itoa(count), Exact: true}
+}
+
+// initialiseWatcherCounts reads the count policy, refusing to start with a
+// bad one
+func (a *App) initialiseWatcherCounts() {
+	policy := a.config().WatcherCounts
+	if policy == nil {
+		a.Log.Fatal().Msg("Invalid watcher count settings")
+	}
+	a.watcherCounts = policy
+	a.Log.Info().Int("min", policy.MinCount).Ints("buckets", policy.Buckets).Msg("Watcher count privacy configured")
+}
+
+// watcherCountPolicy returns the app's count policy, taking it from the
+// config on first use
+func (a *App) watcherCountPolicy() *WatcherCountPolicy {
+	a.watcherCountOnce.Do(func() {
+		if a.watcherCounts != nil {
+			return
+		}
+		policy := a.config().WatcherCounts
+		if policy == nil {
+			// fall back to the floor alone rather than exact counts
+			a.Log.Error().Msg("Invalid watcher count settings, ignoring buckets")
+			policy = &WatcherCountPolicy{MinCount: defaultWatcherCountMin}
+		}
+		a.watcherCounts = policy
+	})
+	return a.watcherCounts
+}
+
+// countWatchers counts the watchlists holding an item, leaving out users who
+// have hidden themselves from counts
+func (a *App) countWatchers(ctx context.Context, itemID string) (int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"item_ids": itemID}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         userSettingsCollection,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "settings",
+		}}},
+		{{Key: "$match", Value: bson.M{"settings.hide_from_watcher_counts": bson.M{"$ne": true}}}},
+		{{Key: "$count", Value: "watchers"}},
+	}
+
+	cursor, err := a.GetCollection("watchlist").Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Watchers int `bson:"watchers"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Watchers, cursor.Err()
+}
+
+//-----------------------------------------------------------------------------
+// Handlers
+
+func (a *App) GetWatchingCount(c *gin.Context) {
+	parsedID, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid item ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	count, err := a.countWatchers(ctx, parsedID.String())
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error counting watching users")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, a.watcherCountPolicy().Apply(count))
+}
+
+func (a *App) getUserSettings(ctx context.Context, publicID string) (*UserSettings, error) {
+	settings := &UserSettings{ID: publicID}
+	err := a.GetCollection(userSettingsCollection).FindOne(ctx, bson.M{"_id": publicID}).Decode(settings)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (a *App) GetSettings(c *gin.Context) {
+	publicID, _ := c.Get("public_id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.getUserSettings(ctx, publicID.(string))
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error reading user settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func (a *App) UpdateSettings(c *gin.Context) {
+	var req UserSettingsRequest
+	if !a.DecodeJSON(c, &req) {
+		return
+	}
+
+	publicID, _ := c.Get("public_id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	settings := &UserSettings{}
+	err := a.GetCollection(userSettingsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": publicID.(string)},
+		bson.M{"$set": bson.M{
+			"hide_from_watcher_counts": *req.HideFromWatcherCounts,
+			"updated_at":               time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(settings)
+	if err != nil {
+		a.requestLog(c).Error().Err(err).Msg("Error updating user settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	a.requestLog(c).Info().Str("public_id", settings.ID).Bool("hide_from_watcher_counts", settings.HideFromWatcherCounts).Msg("User settings updated")
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcherCountPolicy(t *testing.T) {
+	t.Run("should hide every count below the floor", func(t *testing.T) {
+		policy := &WatcherCountPolicy{MinCount: 3}
+		for _, count := range []int{0, 1, 2} {
+			assert.Equal(t, WatchingResponse{PeopleWatching: 0, Display: "fewer than 3"}, policy.Apply(count), count)
+		}
+		assert.Equal(t, WatchingResponse{PeopleWatching: 3, Display: "3", Exact: true}, policy.Apply(3))
+	})
+
+	t.Run("should give exact counts without a floor", func(t *testing.T) {
+		for _, min := range []int{0, 1} {
+			policy := &WatcherCountPolicy{MinCount: min}
+			assert.Equal(t, WatchingResponse{PeopleWatching: 0, Display: "0", Exact: true}, policy.Apply(0))
+			assert.Equal(t, WatchingResponse{PeopleWatching: 1, Display: "1", Exact: true}, policy.Apply(1))
+		}
+	})
+
+	t.Run("should bucket larger counts", func(t *testing.T) {
+		policy := &WatcherCountPolicy{MinCount: 3, Buckets: []int{10, 50, 100}}
+
+		assert.Equal(t, "fewer than 3", policy.Apply(2).Display)
+		assert.Equal(t, WatchingResponse{PeopleWatching: 9, Display: "9", Exact: true}, policy.Apply(9))
+		assert.Equal(t, WatchingResponse{PeopleWatching: 10, Display: "10+"}, policy.Apply(10))
+		assert.Equal(t, WatchingResponse{PeopleWatching: 50, Display: "50+"}, policy.Apply(73))
+		assert.Equal(t, WatchingResponse{PeopleWatching: 100, Display: "100+"}, policy.Apply(5000))
+	})
+
+	t.Run("should read the policy from the environment", func(t *testing.T) {
+		t.Setenv("WATCHER_COUNT_MIN", "5")
+		t.Setenv("WATCHER_COUNT_BUCKETS", "10, 25")
+
+		policy, err := NewWatcherCountPolicy(EnvSettings())
+		require.NoError(t, err)
+		assert.Equal(t, &WatcherCountPolicy{MinCount: 5, Buckets: []int{10, 25}}, policy)
+	})
+
+	t.Run("should reject bad buckets", func(t *testing.T) {
+		for _, raw := range []string{"ten", "0", "-5", "50,10", "10,10"} {
+			_, err := ParseWatcherCountBuckets(raw)
+			assert.Error(t, err, raw)
+		}
+	})
+}